@@ -0,0 +1,86 @@
+// Tiny SVG line and bar charts, so the charts need no CDN:
+//
+//   SpiderCharts.render(element, points, {kind: 'line', label: 'Period', series: ['Count', 'Links']})
+//
+// The points are the objects with the label and the series fields.
+var SpiderCharts = (function () {
+    var SVG = 'http://www.w3.org/2000/svg';
+    var colors = ['#337ab7', '#d9534f', '#5cb85c', '#f0ad4e'];
+    var width = 800, height = 300, left = 50, right = 10, top = 20, bottom = 40;
+
+    function node(name, attrs, text) {
+        var el = document.createElementNS(SVG, name);
+        for (var key in attrs) {
+            el.setAttribute(key, attrs[key]);
+        }
+        if (text !== undefined) {
+            el.appendChild(document.createTextNode(text));
+        }
+        return el;
+    }
+
+    function render(element, points, options) {
+        var kind = options.kind || 'line';
+        var series = options.series;
+        while (element.firstChild) {
+            element.removeChild(element.firstChild);
+        }
+        var svg = node('svg', {width: width, height: height, viewBox: '0 0 ' + width + ' ' + height, 'font-size': 10});
+        element.appendChild(svg);
+        if (!points.length) {
+            svg.appendChild(node('text', {x: width / 2, y: height / 2, 'text-anchor': 'middle'}, 'No data'));
+            return;
+        }
+        var max = 1;
+        points.forEach(function (p) {
+            series.forEach(function (s) {
+                max = Math.max(max, p[s]);
+            });
+        });
+        var plotWidth = width - left - right, plotHeight = height - top - bottom;
+        var step = plotWidth / points.length;
+        var y = function (value) {
+            return top + plotHeight - plotHeight * value / max;
+        };
+
+        // the axes, four grid lines and every label which fits
+        for (var i = 0; i <= 4; i++) {
+            var value = max * i / 4;
+            svg.appendChild(node('line', {x1: left, x2: width - right, y1: y(value), y2: y(value), stroke: '#eee'}));
+            svg.appendChild(node('text', {x: left - 5, y: y(value) + 3, 'text-anchor': 'end'}, Math.round(value)));
+        }
+        var every = Math.ceil(points.length / Math.floor(plotWidth / 70));
+        points.forEach(function (p, i) {
+            if (i % every === 0) {
+                svg.appendChild(node('text', {x: left + step * (i + 0.5), y: height - bottom + 15, 'text-anchor': 'middle'}, p[options.label]));
+            }
+        });
+
+        series.forEach(function (s, n) {
+            var color = colors[n % colors.length];
+            if (kind === 'bar') {
+                var barWidth = step * 0.8 / series.length;
+                points.forEach(function (p, i) {
+                    var x = left + step * (i + 0.1) + barWidth * n;
+                    var bar = node('rect', {x: x, y: y(p[s]), width: Math.max(barWidth, 1), height: top + plotHeight - y(p[s]), fill: color});
+                    bar.appendChild(node('title', {}, p[options.label] + ' ' + s + ': ' + Math.round(p[s] * 10) / 10));
+                    svg.appendChild(bar);
+                });
+            } else {
+                var path = points.map(function (p, i) {
+                    return (i ? 'L' : 'M') + (left + step * (i + 0.5)) + ' ' + y(p[s]);
+                }).join(' ');
+                svg.appendChild(node('path', {d: path, fill: 'none', stroke: color, 'stroke-width': 2}));
+                points.forEach(function (p, i) {
+                    var dot = node('circle', {cx: left + step * (i + 0.5), cy: y(p[s]), r: 3, fill: color});
+                    dot.appendChild(node('title', {}, p[options.label] + ' ' + s + ': ' + Math.round(p[s] * 10) / 10));
+                    svg.appendChild(dot);
+                });
+            }
+            svg.appendChild(node('rect', {x: left + 80 * n, y: height - 15, width: 10, height: 10, fill: color}));
+            svg.appendChild(node('text', {x: left + 80 * n + 14, y: height - 6}, s));
+        });
+    }
+
+    return {render: render};
+})();
@@ -1,6 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
 	"gopkg.in/gin-gonic/gin.v1"
 	"github.com/maddevsio/spiderwoman/lib"
 	"github.com/gin-contrib/gzip"
@@ -8,28 +17,56 @@ import (
 	"log"
 )
 
+const eventsPollInterval = time.Second
+
 func GetAPIEngine(config simple_config.SimpleConfig) *gin.Engine {
 	lib.CreateDBIfNotExists(config.GetString("db-path"))
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
-	r.Use(gzip.Gzip(gzip.BestCompression))
+	compress := gzip.Gzip(gzip.BestCompression)
+	r.Use(func(c *gin.Context) {
+		// the gzip writer buffers the event stream, it must go to the browser at once
+		if !strings.HasSuffix(c.Request.URL.Path, "/events") {
+			compress(c)
+		}
+	})
 	r.LoadHTMLGlob("templates/*")
 	r.Static("/assets", "./assets")
 	r.Static("/images", "./images")
 	r.StaticFile("/spiderwoman.zip", config.GetString("zip-xls-path"))
 
 	r.GET("/", func(c *gin.Context) {
+		if !validDates(c, "date") {
+			return
+		}
 		s, _ := lib.GetCrawlStatus(config.GetString("db-path"))
 		dates, _ := lib.GetAllDaysFromMonitor(config.GetString("db-path"))
+		runs, _ := lib.GetLastRuns(config.GetString("db-path"), 1)
+		report := ""
+		resumed := false
+		var activeRun int64
+		if len(runs) > 0 {
+			report = runs[0].Report
+			resumed = runs[0].Resumed > 0
+			if runs[0].Finished == "" {
+				activeRun = runs[0].ID
+			}
+		}
 		c.HTML(200, "index.html", gin.H{
 			"title": "Spiderwoman",
 			"status": s,
+			"report": report,
+			"resumed": resumed,
+			"activeRun": activeRun,
 			"dates" : dates,
 			"dateQS" : c.Query("date"),
 		})
 	})
 
 	r.GET("/all", func(c *gin.Context) {
+		if !validDates(c, "date") {
+			return
+		}
 		var m []lib.Monitor
 		if c.Query("date") != "" {
 			m, _ = lib.GetAllDataFromMonitorByDay(config.GetString("db-path"), c.Query("date"))
@@ -39,6 +76,400 @@ func GetAPIEngine(config simple_config.SimpleConfig) *gin.Engine {
 		c.JSON(200, m)
 	})
 
+	r.GET("/api/crawls", func(c *gin.Context) {
+		runs, _ := lib.GetLastRuns(config.GetString("db-path"), 30)
+		c.JSON(200, runs)
+	})
+
+	r.POST("/api/crawls", func(c *gin.Context) {
+		var request struct {
+			Sites []string `json:"sites"`
+		}
+		err := json.NewDecoder(c.Request.Body).Decode(&request)
+		if err != nil && err != io.EOF {
+			c.JSON(400, gin.H{"error": "Bad request: " + err.Error()})
+			return
+		}
+		unknown, err := lib.UnknownSites(config.GetString("db-path"), request.Sites)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if len(unknown) > 0 {
+			c.JSON(400, gin.H{"error": "Bad request: the sites are not in the sites list: " + strings.Join(unknown, ", ")})
+			return
+		}
+		run, unfinished, _ := lib.GetUnfinishedRun(config.GetString("db-path"))
+		if unfinished {
+			c.JSON(409, gin.H{"error": "The previous crawl is not finished yet", "run": run})
+			return
+		}
+		id, err := lib.RequestRun(config.GetString("db-path"), request.Sites)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		run, _, _ = lib.GetRun(config.GetString("db-path"), id)
+		c.JSON(201, run)
+	})
+
+	r.GET("/api/crawls/:id", func(c *gin.Context) {
+		run, found := getRunByParam(c, config.GetString("db-path"))
+		if found {
+			c.JSON(200, run)
+		}
+	})
+
+	r.GET("/api/crawls/:id/proxies", func(c *gin.Context) {
+		run, found := getRunByParam(c, config.GetString("db-path"))
+		if !found {
+			return
+		}
+		stats, err := lib.GetProxyStats(config.GetString("db-path"), run.ID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, stats)
+	})
+
+	// the progress of the crawl as Server-Sent Events, the stream ends when
+	// the run is finished. The browser reconnects with Last-Event-ID and
+	// gets the events it missed
+	r.GET("/api/crawls/:id/events", func(c *gin.Context) {
+		run, found := getRunByParam(c, config.GetString("db-path"))
+		if !found {
+			return
+		}
+		lastID, _ := strconv.ParseInt(c.Request.Header.Get("Last-Event-ID"), 10, 64)
+		if c.Query("after") != "" {
+			lastID, _ = strconv.ParseInt(c.Query("after"), 10, 64)
+		}
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Stream(func(w io.Writer) bool {
+			events, err := lib.GetEventsAfter(config.GetString("db-path"), run.ID, lastID)
+			if err != nil {
+				return false
+			}
+			for _, event := range events {
+				data, _ := json.Marshal(event)
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Kind, data)
+				lastID = event.ID
+			}
+			if len(events) == 0 {
+				run, _, _ = lib.GetRun(config.GetString("db-path"), run.ID)
+				if run.Finished != "" {
+					return false
+				}
+				// keeps the connection alive through proxies
+				fmt.Fprint(w, ": ping\n\n")
+			}
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case <-time.After(eventsPollInterval):
+				return true
+			}
+		})
+	})
+
+	r.DELETE("/api/crawls/:id", func(c *gin.Context) {
+		run, found := getRunByParam(c, config.GetString("db-path"))
+		if !found {
+			return
+		}
+		canceled, err := lib.RequestRunCancel(config.GetString("db-path"), run.ID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if !canceled {
+			c.JSON(409, gin.H{"error": "The crawl is already finished", "run": run})
+			return
+		}
+		run, _, _ = lib.GetRun(config.GetString("db-path"), run.ID)
+		c.JSON(202, run)
+	})
+
+	// the links filtered out on the day by the content type or the suffix
+	r.GET("/filtered", func(c *gin.Context) {
+		if !validDates(c, "date") {
+			return
+		}
+		f, err := lib.GetFilteredLinksByDay(config.GetString("db-path"), latestDay(config, c.Query("date")))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, f)
+	})
+
+	// the banners found on the day by the source host
+	r.GET("/banners", func(c *gin.Context) {
+		if !validDates(c, "date") {
+			return
+		}
+		inventory, err := lib.GetBannerInventory(config.GetString("db-path"), latestDay(config, c.Query("date")))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, inventory)
+	})
+
+	// the hidden links found on the day by the source host
+	r.GET("/hidden", func(c *gin.Context) {
+		if !validDates(c, "date") {
+			return
+		}
+		hidden, err := lib.GetHiddenLinks(config.GetString("db-path"), latestDay(config, c.Query("date")))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, hidden)
+	})
+
+	// the networks embedded on the source hosts by the runs on the days
+	// from and to inclusive, e.g. ?from=2017-01-01&to=2017-01-31
+	r.GET("/api/networks", func(c *gin.Context) {
+		if !validDates(c, "from", "to") {
+			return
+		}
+		presences, err := lib.GetNetworkPresences(config.GetString("db-path"), c.Query("from"), c.Query("to"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, presences)
+	})
+
+	// the links added and removed between the days and the changes of the counts
+	// by the hosts, the latest two days by default
+	r.GET("/api/diff", func(c *gin.Context) {
+		if !validDates(c, "from", "to") {
+			return
+		}
+		diff, err := lib.GetDiff(config.GetString("db-path"), c.Query("from"), c.Query("to"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, diff)
+	})
+
+	// the links and the occurrences of the selection by the days of the runs,
+	// e.g. ?source=a.kg&external=b.kg&type=B&interval=week
+	r.GET("/api/timeseries", func(c *gin.Context) {
+		if interval := c.Query("interval"); interval != "" && !lib.TimeSeriesIntervals[interval] {
+			c.JSON(400, gin.H{"error": "Bad request: use interval run, day, week or month"})
+			return
+		}
+		points, err := lib.GetTimeSeries(config.GetString("db-path"), lib.TimeSeriesQuery{
+			SourceHost:   c.Query("source"),
+			ExternalHost: c.Query("external"),
+			SourceType:   c.Query("source-type"),
+			ExternalType: c.Query("type"),
+			Interval:     c.Query("interval"),
+		})
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, points)
+	})
+
+	r.GET("/charts", func(c *gin.Context) {
+		c.HTML(200, "charts.html", gin.H{"title": "Spiderwoman charts"})
+	})
+
+	// the top external hosts of the day by the sources or by the count,
+	// e.g. ?date=2017-01-20&by=count&limit=20
+	r.GET("/api/leaderboard", func(c *gin.Context) {
+		if !validDates(c, "date") {
+			return
+		}
+		by := c.Query("by")
+		if by != "" && by != lib.RankBySources && by != lib.RankByCount {
+			c.JSON(400, gin.H{"error": "Bad request: use by sources or count"})
+			return
+		}
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		ranks, err := lib.GetLeaderboard(config.GetString("db-path"), latestDay(config, c.Query("date")), by, limit)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, ranks)
+	})
+
+	// the share of the external host types in the links of every source type
+	r.GET("/api/share-of-voice", func(c *gin.Context) {
+		if !validDates(c, "date") {
+			return
+		}
+		shares, err := lib.GetShareOfVoice(config.GetString("db-path"), latestDay(config, c.Query("date")))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, shares)
+	})
+
+	// the external hosts which gained and lost the most between the days,
+	// the latest two days by default
+	r.GET("/api/movers", func(c *gin.Context) {
+		if !validDates(c, "from", "to") {
+			return
+		}
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		movers, err := lib.GetMovers(config.GetString("db-path"), c.Query("from"), c.Query("to"), limit)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, movers)
+	})
+
+	// the graph of the source and the external hosts for Gephi or Graphviz,
+	// e.g. ?format=gexf&from=2017-01-20&to=2017-01-21 or ?format=dot&run=12
+	r.GET("/api/graph", func(c *gin.Context) {
+		format := c.DefaultQuery("format", "graphml")
+		write, ok := lib.GraphFormats[format]
+		if !ok {
+			c.JSON(400, gin.H{"error": "Bad request: use format graphml, gexf or dot"})
+			return
+		}
+		if !validDates(c, "from", "to") {
+			return
+		}
+		from, to := c.Query("from"), c.Query("to")
+		var runID int64
+		if c.Query("run") != "" {
+			id, err := strconv.ParseInt(c.Query("run"), 10, 64)
+			if err != nil {
+				c.JSON(400, gin.H{"error": "Bad crawl id"})
+				return
+			}
+			run, found, err := lib.GetRun(config.GetString("db-path"), id)
+			if err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			if !found {
+				c.JSON(404, gin.H{"error": "Crawl not found"})
+				return
+			}
+			from, to, runID = lib.RunDay(run), lib.RunDay(run), run.ID
+		}
+		graph, err := lib.GetLinkGraph(config.GetString("db-path"), from, to, runID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		var buf bytes.Buffer
+		if err := write(&buf, graph); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=spiderwoman.%s", format))
+		c.Data(200, lib.GraphContentTypes[format], buf.Bytes())
+	})
+
+	// the sources which linked to the external host over time
+	r.GET("/api/external-hosts/:host", func(c *gin.Context) {
+		report, err := lib.GetExternalHostReport(config.GetString("db-path"), c.Param("host"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, report)
+	})
+
+	r.GET("/external-hosts/:host", func(c *gin.Context) {
+		c.HTML(200, "external_host.html", gin.H{
+			"title": c.Param("host"),
+			"host":  c.Param("host"),
+		})
+	})
+
+	// the dashboard of the watched domains
+	r.GET("/watch", func(c *gin.Context) {
+		watchlist, _ := lib.GetWatchedDomains(config.GetString("db-path"))
+		c.HTML(200, "watch.html", gin.H{
+			"title":     "Spiderwoman watchlist",
+			"watchlist": watchlist,
+			"domain":    c.Query("domain"),
+		})
+	})
+
+	// the watched domains the links were saved for
+	r.GET("/api/watchlist", func(c *gin.Context) {
+		saved, err := lib.GetWatchedDomains(config.GetString("db-path"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		watchlistPath := config.GetString("watchlist-path")
+		if watchlistPath == "" {
+			watchlistPath = path.Join("..", lib.WatchlistFilepath)
+		}
+		watchlist, err := lib.GetWatchlist(watchlistPath, path.Join("..", lib.WatchlistDefaultFilepath))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, lib.MergeWatchlist(saved, watchlist))
+	})
+
+	// the sources linking to the domain on the latest day and the trend by the days
+	r.GET("/api/watchlist/:domain", func(c *gin.Context) {
+		report, err := lib.GetWatchReport(config.GetString("db-path"), c.Param("domain"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, report)
+	})
+
+	// the links found first on the days from and to inclusive
+	r.GET("/api/links/new", func(c *gin.Context) {
+		if !validDates(c, "from", "to") {
+			return
+		}
+		links, err := lib.GetNewLinks(config.GetString("db-path"), c.Query("from"), c.Query("to"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, links)
+	})
+
+	// the links removed from the source hosts on the days from and to inclusive
+	r.GET("/api/links/removed", func(c *gin.Context) {
+		if !validDates(c, "from", "to") {
+			return
+		}
+		links, err := lib.GetRemovedLinks(config.GetString("db-path"), c.Query("from"), c.Query("to"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, links)
+	})
+
+	// the history of the links of the source host, of all the hosts without it
+	r.GET("/api/links/lifetime", func(c *gin.Context) {
+		links, err := lib.GetLinkLifetimes(config.GetString("db-path"), c.Query("source"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, links)
+	})
+
 	r.GET("/ping", func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"message": "pong",
@@ -48,6 +479,50 @@ func GetAPIEngine(config simple_config.SimpleConfig) *gin.Engine {
 	return r
 }
 
+// validDates checks the dates of the query, the error is sent if any of them
+// is not like 2017-01-20. The dates left out are valid
+func validDates(c *gin.Context, names ...string) bool {
+	for _, name := range names {
+		if day := c.Query(name); day != "" {
+			if _, err := time.Parse("2006-01-02", day); err != nil {
+				c.JSON(400, gin.H{"error": "Bad request: use " + name + " like 2017-01-20"})
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// getRunByParam finds the run by the :id of the url, the error is sent if there is no such run
+func getRunByParam(c *gin.Context, dbPath string) (lib.Run, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Bad crawl id"})
+		return lib.Run{}, false
+	}
+	run, found, err := lib.GetRun(dbPath, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return lib.Run{}, false
+	}
+	if !found {
+		c.JSON(404, gin.H{"error": "Crawl not found"})
+		return lib.Run{}, false
+	}
+	return run, true
+}
+
+// latestDay is the day, the latest day of the monitor if it is empty
+func latestDay(config simple_config.SimpleConfig, day string) string {
+	if day == "" {
+		dates, _ := lib.GetAllDaysFromMonitor(config.GetString("db-path"))
+		if len(dates) > 0 {
+			day = dates[0]
+		}
+	}
+	return day
+}
+
 func main() {
 	config := simple_config.NewSimpleConfig("../config", "yml")
 	log.Printf("Server started on %v", config.GetString("api-port"))
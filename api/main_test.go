@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
 	"github.com/maddevsio/spiderwoman/lib"
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
@@ -77,6 +78,7 @@ func TestAll(t *testing.T) {
 		externalHost := "b"
 		_ = lib.SaveRecordToMonitor(config.GetString("db-path"), sourceHost, externalLink, count, externalHost)
 	}
+	lib.SaveRecordToMonitorWithInfo(config.GetString("db-path"), "http://a", "http://c/", 30, "c", lib.LinkInfo{Pages: 10, PagesCrawled: 20})
 
 	ts := httptest.NewServer(GetAPIEngine(config))
 	defer ts.Close()
@@ -96,4 +98,531 @@ func TestAll(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-}
\ No newline at end of file
+
+	for _, path := range []string{"/all?date=20170120", "/?date=x"} {
+		resp, err = http.Get(ts.URL + path)
+		assert.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode, path)
+	}
+	assert.Equal(t, 3, len(r))
+	assert.Equal(t, 30, r[2].Count)
+	assert.Equal(t, 10, r[2].Pages)
+	assert.Equal(t, 20, r[2].PagesCrawled)
+}
+func TestBanners(t *testing.T) {
+	os.Remove(config.GetString("db-path"))
+	lib.CreateDBIfNotExists(config.GetString("db-path"))
+	lib.SaveRecordToMonitorWithInfo(config.GetString("db-path"), "a", "http://b/1", 1, "b",
+		lib.LinkInfo{Banner: &lib.Banner{ImageURL: "http://a/1.gif", Width: 300, Height: 250, Size: "Medium Rectangle"}})
+	lib.SaveRecordToMonitor(config.GetString("db-path"), "a", "http://c/1", 1, "c")
+
+	ts := httptest.NewServer(GetAPIEngine(config))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/banners")
+	assert.NoError(t, err)
+	var inventory []lib.BannerInventory
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&inventory))
+	assert.Equal(t, 1, len(inventory))
+	assert.Equal(t, 1, inventory[0].Sizes["Medium Rectangle"])
+	assert.Equal(t, "http://b/1", inventory[0].Links[0].ExternalLink)
+
+	resp, err = http.Get(ts.URL + "/banners?date=2017-01-2'")
+	assert.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestHidden(t *testing.T) {
+	os.Remove(config.GetString("db-path"))
+	lib.CreateDBIfNotExists(config.GetString("db-path"))
+	lib.SaveRecordToMonitorWithInfo(config.GetString("db-path"), "a", "http://b/1", 1, "b", lib.LinkInfo{Hidden: lib.HiddenDisplayNone})
+	lib.SaveRecordToMonitor(config.GetString("db-path"), "a", "http://c/1", 1, "c")
+
+	ts := httptest.NewServer(GetAPIEngine(config))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/hidden")
+	assert.NoError(t, err)
+	var hidden []lib.HiddenLinks
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&hidden))
+	assert.Equal(t, 1, len(hidden))
+	assert.Equal(t, 1, hidden[0].Reasons[lib.HiddenDisplayNone])
+	assert.Equal(t, "http://b/1", hidden[0].Links[0].ExternalLink)
+
+	resp, err = http.Get(ts.URL + "/hidden?date=yesterday")
+	assert.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestDiff(t *testing.T) {
+	os.Remove(config.GetString("db-path"))
+	lib.CreateDBIfNotExists(config.GetString("db-path"))
+	lib.SaveRecordToMonitor(config.GetString("db-path"), "a", "http://b/1", 1, "b")
+
+	ts := httptest.NewServer(GetAPIEngine(config))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/diff")
+	assert.NoError(t, err)
+	var diff lib.LinkDiff
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&diff))
+	assert.Equal(t, "", diff.From)
+	assert.Equal(t, 1, len(diff.Added))
+	assert.Equal(t, "http://b/1", diff.Added[0].ExternalLink)
+
+	resp, err = http.Get(ts.URL + "/api/diff?from=2017-01-20&to=2017-13-01")
+	assert.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestWatchlist(t *testing.T) {
+	os.Remove(config.GetString("db-path"))
+	lib.CreateDBIfNotExists(config.GetString("db-path"))
+	lib.SaveRecordToMonitorWithInfo(config.GetString("db-path"), "a", "http://b/1", 2, "b",
+		lib.LinkInfo{Watched: &lib.WatchedDomain{Domain: "b", Label: "competitor"}})
+
+	ts := httptest.NewServer(GetAPIEngine(config))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/watchlist")
+	assert.NoError(t, err)
+	var watchlist []lib.WatchedDomain
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&watchlist))
+	assert.Equal(t, []lib.WatchedDomain{{Domain: "b", Label: "competitor"}}, watchlist)
+
+	// the domain watched since the last run
+	ioutil.WriteFile(config.GetString("watchlist-path"), []byte("new.kg:\n  label: client\n"), 0644)
+	defer os.Remove(config.GetString("watchlist-path"))
+	resp, err = http.Get(ts.URL + "/api/watchlist")
+	assert.NoError(t, err)
+	watchlist = nil
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&watchlist))
+	assert.Equal(t, []lib.WatchedDomain{{Domain: "b", Label: "competitor"}, {Domain: "new.kg", Label: "client"}}, watchlist)
+
+	resp, err = http.Get(ts.URL + "/api/watchlist/b")
+	assert.NoError(t, err)
+	var report lib.WatchReport
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	assert.Equal(t, "a", report.Sources[0].SourceHost)
+	assert.Equal(t, 2, report.Sources[0].Count)
+
+	resp, err = http.Get(ts.URL + "/watch?domain=b")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	page, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(page), "competitor")
+}
+
+func TestExternalHost(t *testing.T) {
+	os.Remove(config.GetString("db-path"))
+	lib.CreateDBIfNotExists(config.GetString("db-path"))
+	lib.SaveRecordToMonitor(config.GetString("db-path"), "a", "http://b/1", 2, "b")
+	lib.UpdateLinkLifetimes(config.GetString("db-path"), 1, map[string]map[string]int{"a": {"http://b/1": 2}}, []string{"a"})
+
+	ts := httptest.NewServer(GetAPIEngine(config))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/external-hosts/b")
+	assert.NoError(t, err)
+	var report lib.ExternalHostReport
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	assert.Equal(t, 1, len(report.Sources))
+	assert.Equal(t, "a", report.Sources[0].SourceHost)
+	assert.Equal(t, 2, report.Sources[0].Runs[0].Count)
+	assert.Equal(t, "http://b/1", report.Sources[0].Links[0].ExternalLink)
+
+	resp, err = http.Get(ts.URL + "/external-hosts/b")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestTimeSeries(t *testing.T) {
+	os.Remove(config.GetString("db-path"))
+	lib.CreateDBIfNotExists(config.GetString("db-path"))
+	lib.SaveRecordToMonitor(config.GetString("db-path"), "a", "http://b/1", 2, "b")
+	lib.SaveRecordToMonitor(config.GetString("db-path"), "a", "http://c/1", 3, "c")
+
+	ts := httptest.NewServer(GetAPIEngine(config))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/timeseries?external=b&interval=week")
+	assert.NoError(t, err)
+	var points []lib.TimeSeriesPoint
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&points))
+	assert.Equal(t, 1, len(points))
+	assert.Equal(t, 2.0, points[0].Count)
+
+	resp, err = http.Get(ts.URL + "/api/timeseries?interval=year")
+	assert.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+
+	for _, path := range []string{"/charts", "/assets/charts.js"} {
+		resp, err = http.Get(ts.URL + path)
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+	}
+}
+
+func TestGraphExport(t *testing.T) {
+	os.Remove(config.GetString("db-path"))
+	lib.CreateDBIfNotExists(config.GetString("db-path"))
+	runID, _ := lib.StartRun(config.GetString("db-path"))
+	lib.SaveRunRecordToMonitor(config.GetString("db-path"), runID, "a", "http://b/1", 2, "b", lib.LinkInfo{})
+	lib.FinishRun(config.GetString("db-path"), runID, lib.RunDone, "")
+	// the later run of the day does not find the link to b
+	lib.SaveRunRecordToMonitor(config.GetString("db-path"), runID+1, "a", "http://c/1", 1, "c", lib.LinkInfo{})
+
+	ts := httptest.NewServer(GetAPIEngine(config))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/graph")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "application/graphml+xml", resp.Header.Get("Content-Type"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(body), `<edge id="e0" source="a" target="c">`)
+	assert.NotContains(t, string(body), `target="b"`)
+
+	resp, err = http.Get(ts.URL + "/api/graph?format=dot&run=" + strconv.FormatInt(runID, 10))
+	assert.NoError(t, err)
+	assert.Equal(t, "attachment; filename=spiderwoman.dot", resp.Header.Get("Content-Disposition"))
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(body), `"a" -> "b" [weight=2, links=1, count=2, days=1`)
+	assert.NotContains(t, string(body), `"a" -> "c"`)
+
+	resp, err = http.Get(ts.URL + "/api/graph?format=gexf&from=2000-01-01&to=2000-01-02")
+	assert.NoError(t, err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.NotContains(t, string(body), "<edge ")
+
+	resp, err = http.Get(ts.URL + "/api/graph?format=csv")
+	assert.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+
+	resp, err = http.Get(ts.URL + "/api/graph?from=20170120")
+	assert.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+
+	resp, err = http.Get(ts.URL + "/api/graph?run=999")
+	assert.NoError(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+}
+
+func TestLeaderboards(t *testing.T) {
+	os.Remove(config.GetString("db-path"))
+	lib.CreateDBIfNotExists(config.GetString("db-path"))
+	lib.SaveRecordToMonitor(config.GetString("db-path"), "a", "http://b/1", 2, "b")
+	lib.SaveRecordToMonitor(config.GetString("db-path"), "c", "http://b/1", 1, "b")
+	lib.SaveRecordToMonitor(config.GetString("db-path"), "a", "http://d/1", 5, "d")
+
+	ts := httptest.NewServer(GetAPIEngine(config))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/leaderboard")
+	assert.NoError(t, err)
+	var ranks []lib.HostRank
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&ranks))
+	assert.Equal(t, "b", ranks[0].ExternalHost)
+	assert.Equal(t, 2, ranks[0].Sources)
+
+	resp, err = http.Get(ts.URL + "/api/leaderboard?by=count&limit=1")
+	assert.NoError(t, err)
+	ranks = nil
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&ranks))
+	assert.Equal(t, []lib.HostRank{{ExternalHost: "d", ExternalHostType: "H", Sources: 1, Links: 1, Count: 5}}, ranks)
+
+	resp, err = http.Get(ts.URL + "/api/leaderboard?by=links")
+	assert.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+
+	resp, err = http.Get(ts.URL + "/api/share-of-voice")
+	assert.NoError(t, err)
+	var shares []lib.ShareOfVoice
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&shares))
+	assert.Equal(t, 1, len(shares))
+	assert.Equal(t, 1.0, shares[0].Share)
+
+	resp, err = http.Get(ts.URL + "/api/movers")
+	assert.NoError(t, err)
+	var movers lib.Movers
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&movers))
+	// there is no day before to compare with
+	assert.Equal(t, "", movers.From)
+	assert.Equal(t, 0, len(movers.Gains))
+
+	for _, path := range []string{"/api/leaderboard?date=20170120", "/api/share-of-voice?date=x", "/api/movers?from=2017-1-1"} {
+		resp, err = http.Get(ts.URL + path)
+		assert.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode, path)
+	}
+}
+
+func TestLinkChanges(t *testing.T) {
+	os.Remove(config.GetString("db-path"))
+	lib.CreateDBIfNotExists(config.GetString("db-path"))
+	lib.UpdateLinkLifetimes(config.GetString("db-path"), 1, map[string]map[string]int{"a": {"http://b/1": 1, "http://c/1": 1}}, []string{"a"})
+	lib.UpdateLinkLifetimes(config.GetString("db-path"), 2, map[string]map[string]int{"a": {"http://b/1": 1}}, []string{"a"})
+
+	ts := httptest.NewServer(GetAPIEngine(config))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/links/new")
+	assert.NoError(t, err)
+	var links []lib.LinkLifetime
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&links))
+	assert.Equal(t, 2, len(links))
+
+	resp, err = http.Get(ts.URL + "/api/links/removed")
+	assert.NoError(t, err)
+	links = nil
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&links))
+	assert.Equal(t, 1, len(links))
+	assert.Equal(t, "http://c/1", links[0].ExternalLink)
+
+	resp, err = http.Get(ts.URL + "/api/links/lifetime?source=a")
+	assert.NoError(t, err)
+	links = nil
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&links))
+	assert.Equal(t, 2, links[0].Runs)
+
+	for _, path := range []string{"/api/links/new?from=x", "/api/links/removed?to=2017-1-20"} {
+		resp, err = http.Get(ts.URL + path)
+		assert.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode, path)
+	}
+}
+
+func TestNetworks(t *testing.T) {
+	os.Remove(config.GetString("db-path"))
+	lib.CreateDBIfNotExists(config.GetString("db-path"))
+	lib.SaveNetworkPresences(config.GetString("db-path"), 1, []lib.NetworkPresence{
+		{SourceHost: "a", Network: "Yandex.Metrica", Category: "counter", Hosts: []string{"mc.yandex.ru"}, Pages: 2, PagesCrawled: 3},
+	})
+
+	ts := httptest.NewServer(GetAPIEngine(config))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/networks")
+	assert.NoError(t, err)
+	var presences []lib.NetworkPresence
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&presences))
+	assert.Equal(t, 1, len(presences))
+	assert.Equal(t, "Yandex.Metrica", presences[0].Network)
+
+	resp, err = http.Get(ts.URL + "/api/networks?to=2000-01-01")
+	assert.NoError(t, err)
+	presences = nil
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&presences))
+	assert.Equal(t, 0, len(presences))
+
+	resp, err = http.Get(ts.URL + "/api/networks?from=2000-1-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestFiltered(t *testing.T) {
+	os.Remove(config.GetString("db-path"))
+	lib.CreateDBIfNotExists(config.GetString("db-path"))
+	lib.SaveFilteredLink(config.GetString("db-path"), 1, lib.FilteredLink{SourceHost: "a", Link: "http://b/1.pdf", Reason: "bad suffix", Count: 1})
+	lib.SaveRecordToMonitor(config.GetString("db-path"), "a", "http://b/1", 1, "b")
+
+	ts := httptest.NewServer(GetAPIEngine(config))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/filtered")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r []lib.FilteredLink
+	err = json.Unmarshal([]byte(actual), &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(r))
+	assert.Equal(t, "bad suffix", r[0].Reason)
+
+	resp, err = http.Get(ts.URL + "/filtered?date=20170120")
+	assert.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestRuns(t *testing.T) {
+	os.Remove(config.GetString("db-path"))
+	lib.CreateDBIfNotExists(config.GetString("db-path"))
+	id, _ := lib.StartRun(config.GetString("db-path"))
+	lib.FinishRun(config.GetString("db-path"), id, "Crawl done", "Skipped URLs: 3 (path depth: 3)")
+
+	ts := httptest.NewServer(GetAPIEngine(config))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/crawls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r []lib.Run
+	err = json.Unmarshal([]byte(actual), &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(r))
+
+	resp, err = http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, _ = ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(actual), "Skipped URLs: 3 (path depth: 3)")
+}
+
+func TestCrawls(t *testing.T) {
+	os.Remove(config.GetString("db-path"))
+	lib.CreateDBIfNotExists(config.GetString("db-path"))
+	lib.SaveHostType(config.GetString("db-path"), "nambataxi.kg", "B")
+
+	ts := httptest.NewServer(GetAPIEngine(config))
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/api/crawls", "application/json", strings.NewReader(`{"sites": ["nambataxi.kg", "unknown.kg"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 400, resp.StatusCode)
+	actual, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(actual), "unknown.kg")
+
+	resp, err = http.Post(ts.URL+"/api/crawls", "application/json", strings.NewReader(`{"sites": ["nambataxi.kg"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 201, resp.StatusCode)
+	var run lib.Run
+	err = json.NewDecoder(resp.Body).Decode(&run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, lib.RunRequested, run.Status)
+	assert.Equal(t, []string{"nambataxi.kg"}, run.Sites)
+
+	resp, err = http.Post(ts.URL+"/api/crawls", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 409, resp.StatusCode)
+
+	resp, err = http.Get(ts.URL + "/api/crawls/" + strconv.FormatInt(run.ID, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.StatusCode)
+
+	request, _ := http.NewRequest("DELETE", ts.URL+"/api/crawls/"+strconv.FormatInt(run.ID, 10), nil)
+	resp, err = http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 202, resp.StatusCode)
+	err = json.NewDecoder(resp.Body).Decode(&run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, lib.RunCanceled, run.Status)
+
+	resp, err = http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 409, resp.StatusCode)
+
+	resp, err = http.Get(ts.URL + "/api/crawls/100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 404, resp.StatusCode)
+
+	resp, err = http.Post(ts.URL+"/api/crawls", "application/json", strings.NewReader(`{"sites": `))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestCrawlEvents(t *testing.T) {
+	os.Remove(config.GetString("db-path"))
+	lib.CreateDBIfNotExists(config.GetString("db-path"))
+
+	ts := httptest.NewServer(GetAPIEngine(config))
+	defer ts.Close()
+
+	id, _ := lib.StartRun(config.GetString("db-path"))
+	lib.SaveEvent(config.GetString("db-path"), lib.Event{RunID: id, Kind: lib.EventHostStarted, Host: "nambataxi.kg"})
+	lib.SaveEvent(config.GetString("db-path"), lib.Event{RunID: id, Kind: lib.EventProgress,
+		Progress: &lib.RunProgress{HostsTotal: 2, HostsDone: 1}, ETA: 10})
+	lib.SaveEvent(config.GetString("db-path"), lib.Event{RunID: id, Kind: lib.EventRunFinished, Message: lib.RunDone})
+	lib.FinishRun(config.GetString("db-path"), id, lib.RunDone, "")
+
+	resp, err := http.Get(ts.URL + "/api/crawls/" + strconv.FormatInt(id, 10) + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "id: 1\nevent: host_started\ndata: {")
+	assert.Contains(t, string(body), `"host":"nambataxi.kg"`)
+	assert.Contains(t, string(body), `"eta":10`)
+	assert.Contains(t, string(body), "event: run_finished")
+
+	request, _ := http.NewRequest("GET", ts.URL+"/api/crawls/"+strconv.FormatInt(id, 10)+"/events", nil)
+	request.Header.Set("Last-Event-ID", "2")
+	resp, err = http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.NotContains(t, string(body), "event: host_started")
+	assert.Contains(t, string(body), "event: run_finished")
+
+	resp, err = http.Get(ts.URL + "/api/crawls/100/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 404, resp.StatusCode)
+}
+
+func TestCrawlProxies(t *testing.T) {
+	os.Remove(config.GetString("db-path"))
+	lib.CreateDBIfNotExists(config.GetString("db-path"))
+
+	ts := httptest.NewServer(GetAPIEngine(config))
+	defer ts.Close()
+
+	id, _ := lib.StartRun(config.GetString("db-path"))
+	lib.SaveProxyStats(config.GetString("db-path"), id, []lib.ProxyStats{
+		{Scope: "global", Proxy: "http://10.0.0.1:3128", Requests: 10, Errors: 2},
+	})
+
+	resp, err := http.Get(ts.URL + "/api/crawls/" + strconv.FormatInt(id, 10) + "/proxies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.StatusCode)
+	var stats []lib.ProxyStats
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(stats))
+	assert.Equal(t, 2, stats[0].Errors)
+}
@@ -0,0 +1,345 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	AlertsFilepath        = "./alerts.yml"
+	AlertsDefaultFilepath = "./alerts.default.yml"
+)
+
+const (
+	AlertNewExternalHost = "new-external-host"
+	AlertWatchedDomain   = "watched-domain"
+	AlertCountChange     = "count-change"
+	AlertCrawlFailed     = "crawl-failed"
+	AlertNoResults       = "no-results"
+)
+
+// AlertsConfig is the rules checked after every crawl and the channels
+// the alerts are sent to, e.g.
+//
+//	channels:
+//	  ops: {type: slack, url: "${SLACK_WEBHOOK}"}
+//	rules:
+//	  - kind: watched-domain
+//	    domains: [competitor.kg]
+//	    channels: [ops]
+type AlertsConfig struct {
+	Channels map[string]ChannelConfig `yaml:"channels"`
+	Rules    []AlertRule              `yaml:"rules"`
+}
+
+// ChannelConfig is where the alerts go: the url of the webhook or the slack
+// webhook, the smtp server and the addresses of the email. ${NAME} in the
+// url, the username and the password is taken from the environment or from
+// the secrets file
+type ChannelConfig struct {
+	Type     string   `yaml:"type"`
+	URL      string   `yaml:"url"`
+	SMTP     string   `yaml:"smtp"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+}
+
+// AlertRule is the kind of the change to alert on. Sources limit the rule to
+// the source hosts, all of them if empty. Domains are the watched domains,
+// the subdomains are watched too. Percent is the least change of the count
+// of the links to the external host. The alerts go to all the channels if
+// Channels is empty
+type AlertRule struct {
+	Kind     string   `yaml:"kind"`
+	Sources  []string `yaml:"sources"`
+	Domains  []string `yaml:"domains"`
+	Percent  int      `yaml:"percent"`
+	Channels []string `yaml:"channels"`
+}
+
+// Alert is the change found by the rule
+type Alert struct {
+	Kind         string
+	SourceHost   string
+	ExternalHost string
+	Message      string
+	channels     []string
+}
+
+// AlertRun is what the crawl ended with: the status and the report of the run,
+// the number of the links saved, the diff of the crawled hosts with the run
+// before and the external hosts linked for the first time
+type AlertRun struct {
+	RunID      int64
+	Status     string
+	Report     string
+	LinksSaved int
+	Diff       LinkDiff
+	NewHosts   []NewExternalHost
+}
+
+// GetAlertsConfig reads the alert rules and channels. There are no alerts
+// if neither of the files exists
+func GetAlertsConfig(realFile string, defaultFile string) (AlertsConfig, error) {
+	var config AlertsConfig
+	data, err := ioutil.ReadFile(realFile)
+	if os.IsNotExist(err) {
+		data, err = ioutil.ReadFile(defaultFile)
+	}
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, err
+	}
+	for i, rule := range config.Rules {
+		switch rule.Kind {
+		case AlertNewExternalHost, AlertWatchedDomain, AlertCountChange, AlertCrawlFailed, AlertNoResults:
+		default:
+			return config, fmt.Errorf("rule %d: unknown kind %q", i+1, rule.Kind)
+		}
+		for _, channel := range rule.Channels {
+			if _, ok := config.Channels[channel]; !ok {
+				return config, fmt.Errorf("rule %d: unknown channel %q", i+1, channel)
+			}
+		}
+	}
+	return config, nil
+}
+
+// EvaluateAlerts checks the rules against the finished run. The changes of
+// the links are not checked on the first run, as all the links are new then
+func EvaluateAlerts(rules []AlertRule, run AlertRun) []Alert {
+	var alerts []Alert
+	for _, rule := range rules {
+		add := func(source string, external string, message string) {
+			alerts = append(alerts, Alert{Kind: rule.Kind, SourceHost: source, ExternalHost: external, Message: message, channels: rule.Channels})
+		}
+		switch rule.Kind {
+		case AlertCrawlFailed:
+			if run.Status == RunFailed {
+				add("", "", fmt.Sprintf("Crawl #%d: %v. %v", run.RunID, run.Status, run.Report))
+			}
+		case AlertNoResults:
+			if run.Status == RunDone && run.LinksSaved == 0 {
+				add("", "", fmt.Sprintf("Crawl #%d saved no links", run.RunID))
+			}
+		case AlertNewExternalHost:
+			for _, host := range run.NewHosts {
+				if ruleSource(rule, host.SourceHost) {
+					add(host.SourceHost, host.ExternalHost, fmt.Sprintf("%v links to %v for the first time (%d links)",
+						host.SourceHost, host.ExternalHost, host.Links))
+				}
+			}
+		}
+		if run.Diff.From == "" {
+			continue
+		}
+		for _, change := range run.Diff.Changed {
+			if !ruleSource(rule, change.SourceHost) {
+				continue
+			}
+			switch rule.Kind {
+			case AlertWatchedDomain:
+				if !ruleDomain(rule, change.ExternalHost) {
+					continue
+				}
+				if change.From == 0 {
+					add(change.SourceHost, change.ExternalHost, fmt.Sprintf("%v appeared on %v (%d links)", change.ExternalHost, change.SourceHost, change.To))
+				} else if change.To == 0 {
+					add(change.SourceHost, change.ExternalHost, fmt.Sprintf("%v disappeared from %v (%d links on %v)",
+						change.ExternalHost, change.SourceHost, change.From, run.Diff.From))
+				}
+			case AlertCountChange:
+				if change.From > 0 && change.To > 0 && abs(change.Delta)*100 >= change.From*rule.Percent {
+					add(change.SourceHost, change.ExternalHost, fmt.Sprintf("Links from %v to %v changed from %d to %d (%+d%%)",
+						change.SourceHost, change.ExternalHost, change.From, change.To, change.Delta*100/change.From))
+				}
+			}
+		}
+	}
+	return alerts
+}
+
+func ruleSource(rule AlertRule, host string) bool {
+	if len(rule.Sources) == 0 {
+		return true
+	}
+	for _, source := range rule.Sources {
+		if strings.EqualFold(source, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func ruleDomain(rule AlertRule, host string) bool {
+	host = strings.ToLower(host)
+	for _, domain := range rule.Domains {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// AlertChannel delivers the alerts
+type AlertChannel interface {
+	Send(alerts []Alert) error
+}
+
+// AlertChannelTypes makes the channels by the type, the new kinds of the
+// channels are added here
+var AlertChannelTypes = map[string]func(config ChannelConfig) (AlertChannel, error){
+	"webhook": func(config ChannelConfig) (AlertChannel, error) {
+		return &WebhookChannel{URL: config.URL}, nil
+	},
+	"slack": func(config ChannelConfig) (AlertChannel, error) {
+		return &SlackChannel{URL: config.URL}, nil
+	},
+	"email": func(config ChannelConfig) (AlertChannel, error) {
+		if config.SMTP == "" || len(config.To) == 0 {
+			return nil, fmt.Errorf("email channel needs smtp and to")
+		}
+		return &EmailChannel{Addr: config.SMTP, From: config.From, To: config.To, Username: config.Username, Password: config.Password}, nil
+	},
+}
+
+// NewAlertChannel makes the channel of the type with the secrets expanded
+func NewAlertChannel(config ChannelConfig, secrets map[string]string) (AlertChannel, error) {
+	var err error
+	for _, value := range []*string{&config.URL, &config.Username, &config.Password} {
+		if *value, err = ExpandSecrets(*value, secrets); err != nil {
+			return nil, err
+		}
+	}
+	newChannel, ok := AlertChannelTypes[config.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown channel type %q", config.Type)
+	}
+	return newChannel(config)
+}
+
+// SendAlerts sends every alert to the channels of its rule, the errors of
+// the channels do not stop the other channels
+func SendAlerts(config AlertsConfig, secrets map[string]string, alerts []Alert) error {
+	byChannel := make(map[string][]Alert)
+	for _, alert := range alerts {
+		channels := alert.channels
+		if len(channels) == 0 {
+			for name := range config.Channels {
+				channels = append(channels, name)
+			}
+		}
+		for _, name := range channels {
+			byChannel[name] = append(byChannel[name], alert)
+		}
+	}
+	var names []string
+	for name := range byChannel {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var failed []string
+	for _, name := range names {
+		channel, err := NewAlertChannel(config.Channels[name], secrets)
+		if err == nil {
+			err = channel.Send(byChannel[name])
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%v: %v", name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("error sending alerts to %v", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+var alertClient = &http.Client{Timeout: 30 * time.Second}
+
+// WebhookChannel posts the alerts as JSON: {"alerts": [{"Kind": ..., "Message": ...}]}
+type WebhookChannel struct {
+	URL string
+}
+
+func (c *WebhookChannel) Send(alerts []Alert) error {
+	return postJSON(c.URL, map[string][]Alert{"alerts": alerts})
+}
+
+// SlackChannel posts the alerts as the text of the message, the incoming
+// webhooks of Slack, Mattermost and Rocket.Chat take it
+type SlackChannel struct {
+	URL string
+}
+
+func (c *SlackChannel) Send(alerts []Alert) error {
+	return postJSON(c.URL, map[string]string{"text": alertsText(alerts)})
+}
+
+func postJSON(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := alertClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%v answered %v", url, resp.Status)
+	}
+	return nil
+}
+
+// EmailChannel sends the alerts in one email through the smtp server,
+// with the plain auth if the username is set
+type EmailChannel struct {
+	Addr     string
+	From     string
+	To       []string
+	Username string
+	Password string
+}
+
+func (c *EmailChannel) Send(alerts []Alert) error {
+	var auth smtp.Auth
+	if c.Username != "" {
+		host := strings.Split(c.Addr, ":")[0]
+		auth = smtp.PlainAuth("", c.Username, c.Password, host)
+	}
+	message := fmt.Sprintf("From: %v\r\nTo: %v\r\nSubject: Spiderwoman: %d alerts\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n\r\n%v\r\n",
+		c.From, strings.Join(c.To, ", "), len(alerts), strings.Replace(alertsText(alerts), "\n", "\r\n", -1))
+	return smtp.SendMail(c.Addr, auth, c.From, c.To, []byte(message))
+}
+
+func alertsText(alerts []Alert) string {
+	var lines []string
+	for _, alert := range alerts {
+		lines = append(lines, alert.Message)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FormatAlertsReport makes a line for the run report, e.g. "Alerts: 2"
+func FormatAlertsReport(alerts []Alert) string {
+	return fmt.Sprintf("Alerts: %d", len(alerts))
+}
@@ -0,0 +1,178 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAlertsConfig(t *testing.T) {
+	realFile := "/tmp/spiderwoman-alerts.yml"
+	ioutil.WriteFile(realFile, []byte(`
+channels:
+  ops: {type: slack, url: "http://localhost/hook"}
+rules:
+  - kind: watched-domain
+    domains: [competitor.kg]
+    channels: [ops]
+`), 0644)
+	defer os.Remove(realFile)
+
+	config, err := GetAlertsConfig(realFile, "/tmp/no-such-file.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, "slack", config.Channels["ops"].Type)
+	assert.Equal(t, []string{"competitor.kg"}, config.Rules[0].Domains)
+
+	ioutil.WriteFile(realFile, []byte("rules:\n  - kind: watched-domain\n    channels: [nobody]\n"), 0644)
+	_, err = GetAlertsConfig(realFile, "/tmp/no-such-file.yml")
+	assert.Error(t, err)
+
+	config, err = GetAlertsConfig("/tmp/no-such-file.yml", "../alerts.default.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(config.Rules))
+}
+
+func TestEvaluateAlerts(t *testing.T) {
+	run := AlertRun{RunID: 7, Status: RunDone, LinksSaved: 3, Diff: LinkDiff{From: "2017-01-01", To: "2017-01-02", Changed: []CountChange{
+		{SourceHost: "a.kg", ExternalHost: "ads.competitor.kg", From: 0, To: 2, Delta: 2},
+		{SourceHost: "a.kg", ExternalHost: "x.kg", From: 10, To: 4, Delta: -6},
+		{SourceHost: "b.kg", ExternalHost: "competitor.kg", From: 1, To: 0, Delta: -1},
+	}}, NewHosts: []NewExternalHost{
+		{SourceHost: "a.kg", ExternalHost: "ads.competitor.kg", Links: 2},
+		{SourceHost: "b.kg", ExternalHost: "new.kg", Links: 1},
+	}}
+	rules := []AlertRule{
+		{Kind: AlertNewExternalHost, Sources: []string{"a.kg"}},
+		{Kind: AlertWatchedDomain, Domains: []string{"competitor.kg"}},
+		{Kind: AlertCountChange, Percent: 50},
+		{Kind: AlertCrawlFailed},
+		{Kind: AlertNoResults},
+	}
+
+	alerts := EvaluateAlerts(rules, run)
+	var kinds []string
+	for _, alert := range alerts {
+		kinds = append(kinds, alert.Kind+" "+alert.SourceHost+" "+alert.ExternalHost)
+	}
+	assert.Equal(t, []string{
+		"new-external-host a.kg ads.competitor.kg",
+		"watched-domain a.kg ads.competitor.kg",
+		"watched-domain b.kg competitor.kg",
+		"count-change a.kg x.kg",
+	}, kinds)
+	assert.Equal(t, "a.kg links to ads.competitor.kg for the first time (2 links)", alerts[0].Message)
+	assert.Equal(t, "Links from a.kg to x.kg changed from 10 to 4 (-60%)", alerts[3].Message)
+
+	alerts = EvaluateAlerts(rules, AlertRun{RunID: 8, Status: RunFailed, Report: "Error opening or parsing sites config"})
+	assert.Equal(t, 1, len(alerts))
+	assert.Equal(t, AlertCrawlFailed, alerts[0].Kind)
+
+	// the run stopped on purpose is not a failure
+	assert.Equal(t, 0, len(EvaluateAlerts(rules, AlertRun{RunID: 8, Status: RunCanceled})))
+	assert.Equal(t, 0, len(EvaluateAlerts(rules, AlertRun{RunID: 8, Status: RunInterrupted})))
+
+	alerts = EvaluateAlerts(rules, AlertRun{RunID: 9, Status: RunDone})
+	assert.Equal(t, 1, len(alerts))
+	assert.Equal(t, AlertNoResults, alerts[0].Kind)
+}
+
+func TestSendAlertsWebhooks(t *testing.T) {
+	var webhook, slack map[string]interface{}
+	webhookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&webhook)
+	}))
+	defer webhookServer.Close()
+	slackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&slack)
+	}))
+	defer slackServer.Close()
+	failingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer failingServer.Close()
+
+	os.Setenv("SPIDERWOMAN_TEST_HOOK", slackServer.URL)
+	defer os.Unsetenv("SPIDERWOMAN_TEST_HOOK")
+	config := AlertsConfig{Channels: map[string]ChannelConfig{
+		"hooks": {Type: "webhook", URL: webhookServer.URL},
+		"ops":   {Type: "slack", URL: "${SPIDERWOMAN_TEST_HOOK}"},
+	}}
+	alerts := []Alert{{Kind: AlertNoResults, Message: "Crawl #1 saved no links"}}
+	assert.NoError(t, SendAlerts(config, nil, alerts))
+	assert.Equal(t, "Crawl #1 saved no links", webhook["alerts"].([]interface{})[0].(map[string]interface{})["Message"])
+	assert.Equal(t, "Crawl #1 saved no links", slack["text"])
+
+	config.Channels["broken"] = ChannelConfig{Type: "webhook", URL: failingServer.URL}
+	err := SendAlerts(config, nil, alerts)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "broken")
+}
+
+func TestSendAlertsEmail(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	received := make(chan string, 1)
+	go fakeSMTP(listener, received)
+
+	config := AlertsConfig{Channels: map[string]ChannelConfig{
+		"sales": {Type: "email", SMTP: listener.Addr().String(), From: "spiderwoman@localhost", To: []string{"sales@localhost"}},
+	}}
+	assert.NoError(t, SendAlerts(config, nil, []Alert{{Kind: AlertWatchedDomain, Message: "competitor.kg appeared on a.kg (2 links)"}}))
+	message := <-received
+	assert.Contains(t, message, "Subject: Spiderwoman: 1 alerts")
+	assert.Contains(t, message, "competitor.kg appeared on a.kg (2 links)")
+}
+
+// fakeSMTP answers one session of the smtp client and gives the message
+func fakeSMTP(listener net.Listener, received chan string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+	reply("220 localhost ESMTP")
+	var message []string
+	data := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if data {
+			if line == "." {
+				data = false
+				received <- strings.Join(message, "\n")
+				reply("250 OK")
+			} else {
+				message = append(message, line)
+			}
+			continue
+		}
+		switch strings.ToUpper(strings.SplitN(line, " ", 2)[0]) {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "DATA":
+			data = true
+			reply("354 Go ahead")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
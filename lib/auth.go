@@ -0,0 +1,206 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// BasicAuth is the HTTP basic auth of the site
+type BasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// SiteLogin is the form the crawler submits before the crawl of the site.
+// The login is successful if the answer has SuccessText and the session
+// has SuccessCookie, whichever of them are set
+type SiteLogin struct {
+	URL           string            `yaml:"url"`
+	Fields        map[string]string `yaml:"fields"`
+	SuccessText   string            `yaml:"success-text"`
+	SuccessCookie string            `yaml:"success-cookie"`
+}
+
+var secretPattern = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}`)
+
+// GetSecrets reads the secrets file, e.g. "NAMBA_PASSWORD: secret".
+// There are no secrets if the file does not exist
+func GetSecrets(filepath string) (map[string]string, error) {
+	secrets := make(map[string]string)
+	data, err := ioutil.ReadFile(filepath)
+	if os.IsNotExist(err) {
+		return secrets, nil
+	}
+	if err != nil {
+		return secrets, err
+	}
+	err = yaml.Unmarshal(data, &secrets)
+	return secrets, err
+}
+
+// ExpandSecrets replaces ${NAME} in the value with the environment variable
+// NAME or with the secret NAME from the secrets file
+func ExpandSecrets(value string, secrets map[string]string) (string, error) {
+	var missing []string
+	expanded := secretPattern.ReplaceAllStringFunc(value, func(match string) string {
+		name := secretPattern.FindStringSubmatch(match)[1]
+		if secret, ok := os.LookupEnv(name); ok {
+			return secret
+		}
+		if secret, ok := secrets[name]; ok {
+			return secret
+		}
+		missing = append(missing, name)
+		return ""
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("no environment variable or secret %v", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// SiteSession keeps the cookies, the headers and the basic auth of the site
+// for all the requests of the crawl
+type SiteSession struct {
+	Jar       http.CookieJar
+	Headers   map[string]string
+	BasicAuth *BasicAuth
+}
+
+// NewSiteSession sets the static cookies of the site and logs in with the
+// client, the cookies of the login are kept in the session. The session
+// without the login is returned with the error if the login has failed
+func NewSiteSession(ctx context.Context, host string, config SiteConfig, secrets map[string]string, client *http.Client) (*SiteSession, error) {
+	jar, _ := cookiejar.New(nil)
+	session := &SiteSession{Jar: jar, Headers: make(map[string]string)}
+
+	for name, value := range config.Headers {
+		expanded, err := ExpandSecrets(value, secrets)
+		if err != nil {
+			return session, fmt.Errorf("header %v: %v", name, err)
+		}
+		session.Headers[name] = expanded
+	}
+	if config.BasicAuth != nil {
+		username, err := ExpandSecrets(config.BasicAuth.Username, secrets)
+		if err != nil {
+			return session, fmt.Errorf("basic auth: %v", err)
+		}
+		password, err := ExpandSecrets(config.BasicAuth.Password, secrets)
+		if err != nil {
+			return session, fmt.Errorf("basic auth: %v", err)
+		}
+		session.BasicAuth = &BasicAuth{Username: username, Password: password}
+	}
+	var cookies []*http.Cookie
+	for name, value := range config.Cookies {
+		expanded, err := ExpandSecrets(value, secrets)
+		if err != nil {
+			return session, fmt.Errorf("cookie %v: %v", name, err)
+		}
+		// the cookies are sent to the subdomains of the site too
+		cookies = append(cookies, &http.Cookie{Name: name, Value: expanded, Path: "/", Domain: host})
+	}
+	for _, scheme := range []string{"http", "https"} {
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: "/"}, cookies)
+	}
+
+	if config.Login != nil {
+		if err := session.login(ctx, config.Login, secrets, client); err != nil {
+			return session, fmt.Errorf("login: %v", err)
+		}
+	}
+	return session, nil
+}
+
+func (s *SiteSession) login(ctx context.Context, login *SiteLogin, secrets map[string]string, client *http.Client) error {
+	form := make(url.Values)
+	for name, value := range login.Fields {
+		expanded, err := ExpandSecrets(value, secrets)
+		if err != nil {
+			return fmt.Errorf("field %v: %v", name, err)
+		}
+		form.Set(name, expanded)
+	}
+	request, err := http.NewRequest("POST", login.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.Apply(request)
+
+	response, err := s.Client(client).Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("%v", response.Status)
+	}
+	if login.SuccessText != "" && !strings.Contains(string(body), login.SuccessText) {
+		return fmt.Errorf("no %q on the page after the login", login.SuccessText)
+	}
+	if login.SuccessCookie != "" {
+		for _, cookie := range s.Jar.Cookies(response.Request.URL) {
+			if cookie.Name == login.SuccessCookie {
+				return nil
+			}
+		}
+		return fmt.Errorf("no cookie %v after the login", login.SuccessCookie)
+	}
+	return nil
+}
+
+// Apply sets the headers and the basic auth of the session on the request,
+// the cookies are set by the client with the session's Jar
+func (s *SiteSession) Apply(request *http.Request) {
+	for name, value := range s.Headers {
+		request.Header.Set(name, value)
+	}
+	if s.BasicAuth != nil {
+		request.SetBasicAuth(s.BasicAuth.Username, s.BasicAuth.Password)
+	}
+}
+
+// Client returns the copy of the client which keeps the cookies of the session.
+// The headers and the basic auth of the session are not sent on the redirect
+// to the other host
+func (s *SiteSession) Client(client *http.Client) *http.Client {
+	sessionClient := *client
+	sessionClient.Jar = s.Jar
+	checkRedirect := client.CheckRedirect
+	sessionClient.CheckRedirect = func(request *http.Request, via []*http.Request) error {
+		if !strings.EqualFold(request.URL.Host, via[0].URL.Host) {
+			for name := range s.Headers {
+				request.Header.Del(name)
+			}
+			if s.BasicAuth != nil {
+				request.Header.Del("Authorization")
+			}
+		}
+		if checkRedirect != nil {
+			return checkRedirect(request, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	return &sessionClient
+}
@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandSecrets(t *testing.T) {
+	os.Setenv("SPIDERWOMAN_TEST_LOGIN", "crawler")
+	defer os.Unsetenv("SPIDERWOMAN_TEST_LOGIN")
+	secrets := map[string]string{"SPIDERWOMAN_TEST_PASSWORD": "secret", "SPIDERWOMAN_TEST_LOGIN": "ignored"}
+
+	value, err := ExpandSecrets("${SPIDERWOMAN_TEST_LOGIN}:${SPIDERWOMAN_TEST_PASSWORD}", secrets)
+	assert.NoError(t, err)
+	assert.Equal(t, "crawler:secret", value)
+
+	value, err = ExpandSecrets("price $10", secrets)
+	assert.NoError(t, err)
+	assert.Equal(t, "price $10", value)
+
+	_, err = ExpandSecrets("${SPIDERWOMAN_TEST_NO_SUCH}", secrets)
+	assert.Error(t, err)
+}
+
+func TestGetSecrets(t *testing.T) {
+	secrets, err := GetSecrets("/tmp/no-such-secrets.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(secrets))
+
+	filepath := "/tmp/spiderwoman-secrets.yml"
+	defer os.Remove(filepath)
+	ioutil.WriteFile(filepath, []byte("NAMBATAXI_PASSWORD: secret\n"), 0600)
+	secrets, err = GetSecrets(filepath)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", secrets["NAMBATAXI_PASSWORD"])
+}
+
+func TestSiteSession(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			r.ParseForm()
+			if r.PostForm.Get("login") != "crawler" || r.PostForm.Get("password") != "secret" {
+				w.Write([]byte("Wrong password"))
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "42", Path: "/"})
+			http.Redirect(w, r, "/account", http.StatusFound)
+		case "/account":
+			w.Write([]byte("Logout"))
+		case "/partner":
+			username, password, _ := r.BasicAuth()
+			session, _ := r.Cookie("session")
+			age, _ := r.Cookie("age_confirmed")
+			if session == nil || age == nil || r.Header.Get("X-Partner") != "spiderwoman" || username != "crawler" || password != "basic" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`<a href="http://ads.kg/">ad</a>`))
+		}
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	host := u.Hostname()
+
+	config := SiteConfig{
+		Cookies:   map[string]string{"age_confirmed": "1"},
+		Headers:   map[string]string{"X-Partner": "spiderwoman"},
+		BasicAuth: &BasicAuth{Username: "crawler", Password: "${BASIC_PASSWORD}"},
+		Login: &SiteLogin{
+			URL:           ts.URL + "/login",
+			Fields:        map[string]string{"login": "crawler", "password": "${PASSWORD}"},
+			SuccessText:   "Logout",
+			SuccessCookie: "session",
+		},
+	}
+	assert.True(t, config.HasSession())
+	assert.False(t, SiteConfig{Proxies: []string{"http://10.0.0.1:3128"}}.HasSession())
+	secrets := map[string]string{"PASSWORD": "secret", "BASIC_PASSWORD": "basic"}
+
+	session, err := NewSiteSession(context.Background(), host, config, secrets, http.DefaultClient)
+	assert.NoError(t, err)
+	request, _ := http.NewRequest("GET", ts.URL+"/partner", nil)
+	session.Apply(request)
+	response, err := session.Client(http.DefaultClient).Do(request)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	response.Body.Close()
+
+	// the secret headers are not sent to the other host
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, hasAuth := r.BasicAuth()
+		w.Write([]byte(r.Header.Get("X-Partner") + fmt.Sprint(hasAuth)))
+	}))
+	defer other.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Partner") != "spiderwoman" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.Redirect(w, r, other.URL, http.StatusFound)
+	}))
+	defer redirect.Close()
+	request, _ = http.NewRequest("GET", redirect.URL, nil)
+	session.Apply(request)
+	response, err = session.Client(http.DefaultClient).Do(request)
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(response.Body)
+	assert.Equal(t, "false", string(body))
+	response.Body.Close()
+
+	secrets["PASSWORD"] = "wrong"
+	session, err = NewSiteSession(context.Background(), host, config, secrets, http.DefaultClient)
+	assert.EqualError(t, err, `login: no "Logout" on the page after the login`)
+	assert.NotNil(t, session)
+
+	delete(secrets, "PASSWORD")
+	_, err = NewSiteSession(context.Background(), host, config, secrets, http.DefaultClient)
+	assert.EqualError(t, err, "login: field password: no environment variable or secret PASSWORD")
+}
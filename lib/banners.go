@@ -0,0 +1,192 @@
+package lib
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Banner is the image inside the outbound anchor. Width and Height are the
+// declared ones, from the attributes or the inline style, 0 if unknown
+type Banner struct {
+	ImageURL string
+	Width    int
+	Height   int
+	Alt      string
+	Size     string `json:",omitempty"`
+}
+
+// IABSizes are the standard ad unit sizes by "WIDTHxHEIGHT"
+var IABSizes = map[string]string{
+	"728x90":  "Leaderboard",
+	"970x90":  "Large Leaderboard",
+	"970x250": "Billboard",
+	"468x60":  "Full Banner",
+	"234x60":  "Half Banner",
+	"320x50":  "Mobile Leaderboard",
+	"320x100": "Large Mobile Banner",
+	"300x50":  "Mobile Banner",
+	"300x250": "Medium Rectangle",
+	"336x280": "Large Rectangle",
+	"180x150": "Rectangle",
+	"300x600": "Half Page",
+	"160x600": "Wide Skyscraper",
+	"120x600": "Skyscraper",
+	"120x240": "Vertical Banner",
+	"250x250": "Square",
+	"200x200": "Small Square",
+	"125x125": "Button",
+}
+
+const BannerSizeCustom = "custom"
+
+// IABSize returns the name of the standard size, "custom" for the other
+// declared sizes and "" if the size is unknown
+func IABSize(width int, height int) string {
+	if width <= 0 || height <= 0 {
+		return ""
+	}
+	if name, ok := IABSizes[fmt.Sprintf("%dx%d", width, height)]; ok {
+		return name
+	}
+	return BannerSizeCustom
+}
+
+var (
+	backgroundImagePattern = regexp.MustCompile(`(?i)background(?:-image)?\s*:[^;]*url\(\s*['"]?([^'")]+)['"]?\s*\)`)
+	styleSizePattern       = regexp.MustCompile(`(?i)(?:^|;)\s*(width|height)\s*:\s*(\d+)\s*px`)
+)
+
+// AnchorBanner finds the banner in the anchor: the <img>, the <picture>
+// or the element with the background image, the image url is resolved
+// against the url of the page
+func AnchorBanner(s *goquery.Selection, pageURL *url.URL) (Banner, bool) {
+	var banner Banner
+	var sized *goquery.Selection
+	if img := s.Find("img").First(); img.Length() > 0 {
+		banner.ImageURL = imageSource(img)
+		banner.Alt, _ = img.Attr("alt")
+		sized = img
+	}
+	if banner.ImageURL == "" {
+		if source := s.Find("picture source").First(); source.Length() > 0 {
+			srcset, _ := source.Attr("srcset")
+			banner.ImageURL = firstSrcset(srcset)
+			sized = source
+		}
+	}
+	if banner.ImageURL == "" {
+		s.Find("*").AddSelection(s).EachWithBreak(func(i int, node *goquery.Selection) bool {
+			style, _ := node.Attr("style")
+			if match := backgroundImagePattern.FindStringSubmatch(style); match != nil {
+				banner.ImageURL = match[1]
+				sized = node
+				return false
+			}
+			return true
+		})
+	}
+	if banner.ImageURL == "" {
+		return banner, false
+	}
+	if u, err := pageURL.Parse(strings.TrimSpace(banner.ImageURL)); err == nil {
+		banner.ImageURL = u.String()
+	}
+	banner.Width, banner.Height = declaredSize(sized)
+	banner.Size = IABSize(banner.Width, banner.Height)
+	return banner, true
+}
+
+// imageSource is the src of the image, the lazy loaded images keep it
+// in the data attributes
+func imageSource(img *goquery.Selection) string {
+	for _, attr := range []string{"src", "data-src", "data-original", "data-lazy-src"} {
+		if src, ok := img.Attr(attr); ok && strings.TrimSpace(src) != "" && !strings.HasPrefix(src, "data:") {
+			return src
+		}
+	}
+	srcset, _ := img.Attr("srcset")
+	return firstSrcset(srcset)
+}
+
+func firstSrcset(srcset string) string {
+	fields := strings.Fields(strings.Split(srcset, ",")[0])
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// declaredSize takes the size from the width and height attributes
+// or from the inline style of the element
+func declaredSize(s *goquery.Selection) (int, int) {
+	width, _ := strconv.Atoi(strings.TrimSuffix(s.AttrOr("width", ""), "px"))
+	height, _ := strconv.Atoi(strings.TrimSuffix(s.AttrOr("height", ""), "px"))
+	for _, match := range styleSizePattern.FindAllStringSubmatch(s.AttrOr("style", ""), -1) {
+		value, _ := strconv.Atoi(match[2])
+		if strings.ToLower(match[1]) == "width" && width == 0 {
+			width = value
+		} else if strings.ToLower(match[1]) == "height" && height == 0 {
+			height = value
+		}
+	}
+	return width, height
+}
+
+// BannerInventory is the banners found on the source host by the size
+type BannerInventory struct {
+	SourceHost string
+	Banners    int
+	Sizes      map[string]int
+	Links      []Monitor
+}
+
+// GetBannerInventory returns the banners saved on the day by the source host,
+// the hosts with more banners first
+func GetBannerInventory(dbFilepath string, day string) ([]BannerInventory, error) {
+	monitors, err := GetAllDataFromMonitorByDay(dbFilepath, day)
+	if err != nil {
+		return nil, err
+	}
+	bySource := make(map[string]*BannerInventory)
+	var inventory []*BannerInventory
+	for _, m := range monitors {
+		if m.BannerImage == "" {
+			continue
+		}
+		source, ok := bySource[m.SourceHost]
+		if !ok {
+			source = &BannerInventory{SourceHost: m.SourceHost, Sizes: make(map[string]int)}
+			bySource[m.SourceHost] = source
+			inventory = append(inventory, source)
+		}
+		size := m.BannerSize
+		if size == "" {
+			size = "unknown"
+		}
+		source.Banners++
+		source.Sizes[size]++
+		source.Links = append(source.Links, m)
+	}
+	sort.SliceStable(inventory, func(i, j int) bool {
+		return inventory[i].Banners > inventory[j].Banners
+	})
+	result := []BannerInventory{}
+	for _, source := range inventory {
+		result = append(result, *source)
+	}
+	return result, nil
+}
+
+// bannerColumns are the values of the banner columns of the monitor
+func bannerColumns(banner *Banner) (string, int, int, string, string) {
+	if banner == nil {
+		return "", 0, 0, "", ""
+	}
+	return banner.ImageURL, banner.Width, banner.Height, banner.Alt, banner.Size
+}
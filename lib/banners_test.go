@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnchorBanner(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><body>
+<a id="img" href="http://a.kg/"><img src="/banners/728.gif" width="728" height="90" alt="Taxi"></a>
+<a id="lazy" href="http://b.kg/"><img src="data:image/gif;base64,R0l" data-src="//cdn.kg/b.png" style="width: 300px; height:250px"></a>
+<a id="picture" href="http://c.kg/"><picture><source srcset="c.webp 1x, c@2x.webp 2x" width="160" height="600"><img alt="C"></picture></a>
+<a id="background" href="http://d.kg/"><div style="background: #fff url('/d.jpg') no-repeat; width: 200px; height: 100px"></div></a>
+<a id="text" href="http://e.kg/">text</a>
+</body></html>`))
+	assert.NoError(t, err)
+	pageURL, _ := url.Parse("http://site.kg/news/1")
+	banner := func(id string) (Banner, bool) {
+		return AnchorBanner(doc.Find("#"+id), pageURL)
+	}
+
+	b, ok := banner("img")
+	assert.True(t, ok)
+	assert.Equal(t, Banner{ImageURL: "http://site.kg/banners/728.gif", Width: 728, Height: 90, Alt: "Taxi", Size: "Leaderboard"}, b)
+
+	b, _ = banner("lazy")
+	assert.Equal(t, Banner{ImageURL: "http://cdn.kg/b.png", Width: 300, Height: 250, Size: "Medium Rectangle"}, b)
+
+	b, _ = banner("picture")
+	assert.Equal(t, Banner{ImageURL: "http://site.kg/news/c.webp", Width: 160, Height: 600, Alt: "C", Size: "Wide Skyscraper"}, b)
+
+	b, _ = banner("background")
+	assert.Equal(t, Banner{ImageURL: "http://site.kg/d.jpg", Width: 200, Height: 100, Size: BannerSizeCustom}, b)
+
+	_, ok = banner("text")
+	assert.False(t, ok)
+}
+
+func TestIABSize(t *testing.T) {
+	assert.Equal(t, "Medium Rectangle", IABSize(300, 250))
+	assert.Equal(t, BannerSizeCustom, IABSize(301, 250))
+	assert.Equal(t, "", IABSize(0, 250))
+}
+
+func TestGetBannerInventory(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	leaderboard := &Banner{ImageURL: "http://a.kg/1.gif", Width: 728, Height: 90, Size: "Leaderboard"}
+	SaveRecordToMonitorWithInfo(DBFilepath, "a.kg", "http://x.kg/", 1, "x.kg", LinkInfo{Banner: leaderboard})
+	SaveRecordToMonitorWithInfo(DBFilepath, "b.kg", "http://x.kg/", 1, "x.kg", LinkInfo{Banner: leaderboard})
+	SaveRecordToMonitorWithInfo(DBFilepath, "b.kg", "http://y.kg/", 1, "y.kg", LinkInfo{Banner: &Banner{ImageURL: "http://b.kg/2.gif"}})
+	SaveRecordToMonitor(DBFilepath, "b.kg", "http://z.kg/", 1, "z.kg")
+
+	days, _ := GetAllDaysFromMonitor(DBFilepath)
+	inventory, err := GetBannerInventory(DBFilepath, days[0])
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(inventory))
+	assert.Equal(t, "b.kg", inventory[0].SourceHost)
+	assert.Equal(t, 2, inventory[0].Banners)
+	assert.Equal(t, map[string]int{"Leaderboard": 1, "unknown": 1}, inventory[0].Sizes)
+	assert.Equal(t, "http://b.kg/2.gif", inventory[0].Links[1].BannerImage)
+	assert.Equal(t, 728, inventory[1].Links[0].BannerWidth)
+}
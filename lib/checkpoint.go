@@ -0,0 +1,409 @@
+package lib
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+)
+
+const (
+	HostPending  = "pending"
+	HostCrawling = "crawling"
+	HostDone     = "done"
+
+	URLQueued  = "queued"
+	URLVisited = "visited"
+
+	ResolutionDone    = "done"
+	ResolutionSkipped = "skipped"
+
+	CounterNotModified = "not-modified"
+	CounterSameContent = "same-content"
+	counterSkipped     = "skipped: "
+)
+
+// the resolvers save their results in parallel, sqlite does not like it
+var checkpointMutex sync.Mutex
+
+// Resolution is the saved result of resolving one collected link, Reason
+// is the one of the mime filter for the skipped links it filtered out
+type Resolution struct {
+	URL         string
+	Times       int
+	Resolved    string
+	ContentType string
+	State       string
+	Reason      string
+}
+
+// Checkpoint is everything the run has done so far, enough to continue
+// the crawl after the process died. Counters are the unchanged pages by
+// CounterNotModified and CounterSameContent, Skipped are the urls skipped
+// by the trap guard by the reason
+type Checkpoint struct {
+	RunID       int64
+	Hosts       map[string]string
+	Queued      map[string][]string
+	Visited     map[string][]string
+	Links       map[string]map[string]int
+	LinksInfo   map[string]map[string]LinkInfo
+	Pages       map[string]int
+	Embeds      map[string]map[string]int
+	Resolutions map[string]map[string]Resolution
+	Counters    map[string]int
+	Skipped     map[string]int
+}
+
+func checkpointExec(dbFilepath string, query string, args ...interface{}) error {
+	checkpointMutex.Lock()
+	defer checkpointMutex.Unlock()
+
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		log.Printf("Error saving checkpoint: %v", err)
+	}
+	return err
+}
+
+func SaveCheckpointHost(dbFilepath string, runID int64, host string, state string) error {
+	return checkpointExec(dbFilepath, "insert or replace into checkpoint_hosts(run_id, host, state) values(?, ?, ?)",
+		runID, host, state)
+}
+
+func SaveCheckpointURL(dbFilepath string, runID int64, host string, url string, state string) error {
+	return checkpointExec(dbFilepath, "insert or replace into checkpoint_urls(run_id, host, url, state) values(?, ?, ?, ?)",
+		runID, host, url, state)
+}
+
+// SaveCheckpointLinks adds the links found on one page to the collected ones
+// and counts the page, the urls of the pages are kept to count every page once.
+// The region, the placement, the banner and the hiding of the link are
+// the ones it was found with first
+func SaveCheckpointLinks(dbFilepath string, runID int64, host string, links map[string]int, linksInfo map[string]LinkInfo) error {
+	checkpointMutex.Lock()
+	defer checkpointMutex.Unlock()
+
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	_, err = tx.Exec("insert or ignore into checkpoint_pages(run_id, host, pages) values(?, ?, 0)", runID, host)
+	if err == nil {
+		_, err = tx.Exec("UPDATE checkpoint_pages SET pages=pages+1 WHERE run_id=? AND host=?", runID, host)
+	}
+	if err != nil {
+		log.Printf("Error saving checkpoint: %v", err)
+		tx.Rollback()
+		return err
+	}
+	for href, count := range links {
+		info := linksInfo[href]
+		var banner []byte
+		if info.Banner != nil {
+			banner, _ = json.Marshal(info.Banner)
+		}
+		_, err = tx.Exec("insert or ignore into checkpoint_links(run_id, host, href, count, region, placement, pages, banner, hidden) values(?, ?, ?, 0, ?, ?, 0, ?, ?)",
+			runID, host, href, info.Region, info.Placement, string(banner), info.Hidden)
+		for page := range info.PageURLs {
+			if err == nil {
+				_, err = tx.Exec("insert or ignore into checkpoint_link_pages(run_id, host, href, page) values(?, ?, ?, ?)", runID, host, href, page)
+			}
+		}
+		if err == nil {
+			_, err = tx.Exec("UPDATE checkpoint_links SET count=count+?, pages=pages+?, "+
+				"banner=CASE WHEN coalesce(banner,'')='' THEN ? ELSE banner END, "+
+				"hidden=CASE WHEN coalesce(hidden,'')='' THEN ? ELSE hidden END WHERE run_id=? AND host=? AND href=?",
+				count, info.Pages, string(banner), info.Hidden, runID, host, href)
+		}
+		if err != nil {
+			log.Printf("Error saving checkpoint: %v", err)
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SaveCheckpointEmbeds counts one more page with the embedded third-party hosts
+func SaveCheckpointEmbeds(dbFilepath string, runID int64, host string, embeds []string) error {
+	checkpointMutex.Lock()
+	defer checkpointMutex.Unlock()
+
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	for _, embed := range embeds {
+		_, err = tx.Exec("insert or ignore into checkpoint_embeds(run_id, host, embed_host, pages) values(?, ?, ?, 0)", runID, host, embed)
+		if err == nil {
+			_, err = tx.Exec("UPDATE checkpoint_embeds SET pages=pages+1 WHERE run_id=? AND host=? AND embed_host=?", runID, host, embed)
+		}
+		if err != nil {
+			log.Printf("Error saving checkpoint: %v", err)
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func SaveCheckpointResolution(dbFilepath string, runID int64, host string, resolution Resolution) error {
+	return checkpointExec(dbFilepath, "insert or replace into checkpoint_resolutions(run_id, host, url, times, resolved, content_type, state, reason) "+
+		"values(?, ?, ?, ?, ?, ?, ?, ?)",
+		runID, host, resolution.URL, resolution.Times, resolution.Resolved, resolution.ContentType, resolution.State, resolution.Reason)
+}
+
+// SaveCheckpointCounters replaces the counters of the run report, the skipped
+// urls are counted by the reason
+func SaveCheckpointCounters(dbFilepath string, runID int64, counters map[string]int, skipped map[string]int) error {
+	checkpointMutex.Lock()
+	defer checkpointMutex.Unlock()
+
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	values := make(map[string]int)
+	for name, value := range counters {
+		values[name] = value
+	}
+	for reason, count := range skipped {
+		values[counterSkipped+reason] = count
+	}
+	for name, value := range values {
+		_, err = tx.Exec("insert or replace into checkpoint_counters(run_id, name, value) values(?, ?, ?)", runID, name, value)
+		if err != nil {
+			log.Printf("Error saving checkpoint: %v", err)
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func GetCheckpoint(dbFilepath string, runID int64) (Checkpoint, error) {
+	checkpoint := Checkpoint{
+		RunID:       runID,
+		Hosts:       make(map[string]string),
+		Queued:      make(map[string][]string),
+		Visited:     make(map[string][]string),
+		Links:       make(map[string]map[string]int),
+		LinksInfo:   make(map[string]map[string]LinkInfo),
+		Pages:       make(map[string]int),
+		Embeds:      make(map[string]map[string]int),
+		Resolutions: make(map[string]map[string]Resolution),
+		Counters:    make(map[string]int),
+		Skipped:     make(map[string]int),
+	}
+
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return checkpoint, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT host, state FROM checkpoint_hosts WHERE run_id=?", runID)
+	if err != nil {
+		log.Printf("Error getting checkpoint: %v", err)
+		return checkpoint, err
+	}
+	for rows.Next() {
+		var host, state string
+		if rows.Scan(&host, &state) == nil {
+			checkpoint.Hosts[host] = state
+		}
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT host, url, state FROM checkpoint_urls WHERE run_id=? ORDER BY id", runID)
+	if err != nil {
+		log.Printf("Error getting checkpoint: %v", err)
+		return checkpoint, err
+	}
+	for rows.Next() {
+		var host, url, state string
+		if rows.Scan(&host, &url, &state) != nil {
+			continue
+		}
+		if state == URLVisited {
+			checkpoint.Visited[host] = append(checkpoint.Visited[host], url)
+		} else {
+			checkpoint.Queued[host] = append(checkpoint.Queued[host], url)
+		}
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT host, href, count, coalesce(region,''), coalesce(placement,''), coalesce(pages,0), coalesce(banner,''), coalesce(hidden,'') FROM checkpoint_links WHERE run_id=?", runID)
+	if err != nil {
+		log.Printf("Error getting checkpoint: %v", err)
+		return checkpoint, err
+	}
+	for rows.Next() {
+		var host, href string
+		var count int
+		var info LinkInfo
+		var banner string
+		if rows.Scan(&host, &href, &count, &info.Region, &info.Placement, &info.Pages, &banner, &info.Hidden) != nil {
+			continue
+		}
+		if banner != "" {
+			json.Unmarshal([]byte(banner), &info.Banner)
+		}
+		if checkpoint.Links[host] == nil {
+			checkpoint.Links[host] = make(map[string]int)
+			checkpoint.LinksInfo[host] = make(map[string]LinkInfo)
+		}
+		checkpoint.Links[host][href] = count
+		checkpoint.LinksInfo[host][href] = info
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT host, href, page FROM checkpoint_link_pages WHERE run_id=?", runID)
+	if err != nil {
+		log.Printf("Error getting checkpoint: %v", err)
+		return checkpoint, err
+	}
+	for rows.Next() {
+		var host, href, page string
+		if rows.Scan(&host, &href, &page) != nil {
+			continue
+		}
+		info, ok := checkpoint.LinksInfo[host][href]
+		if !ok {
+			continue
+		}
+		if info.PageURLs == nil {
+			info.PageURLs = make(map[string]bool)
+		}
+		info.PageURLs[page] = true
+		checkpoint.LinksInfo[host][href] = info
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT host, embed_host, pages FROM checkpoint_embeds WHERE run_id=?", runID)
+	if err != nil {
+		log.Printf("Error getting checkpoint: %v", err)
+		return checkpoint, err
+	}
+	for rows.Next() {
+		var host, embed string
+		var pages int
+		if rows.Scan(&host, &embed, &pages) != nil {
+			continue
+		}
+		if checkpoint.Embeds[host] == nil {
+			checkpoint.Embeds[host] = make(map[string]int)
+		}
+		checkpoint.Embeds[host][embed] = pages
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT host, pages FROM checkpoint_pages WHERE run_id=?", runID)
+	if err != nil {
+		log.Printf("Error getting checkpoint: %v", err)
+		return checkpoint, err
+	}
+	for rows.Next() {
+		var host string
+		var pages int
+		if rows.Scan(&host, &pages) == nil {
+			checkpoint.Pages[host] = pages
+		}
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT host, url, times, resolved, content_type, state, coalesce(reason,'') FROM checkpoint_resolutions WHERE run_id=?", runID)
+	if err != nil {
+		log.Printf("Error getting checkpoint: %v", err)
+		return checkpoint, err
+	}
+	for rows.Next() {
+		var host string
+		var r Resolution
+		if rows.Scan(&host, &r.URL, &r.Times, &r.Resolved, &r.ContentType, &r.State, &r.Reason) != nil {
+			continue
+		}
+		if checkpoint.Resolutions[host] == nil {
+			checkpoint.Resolutions[host] = make(map[string]Resolution)
+		}
+		checkpoint.Resolutions[host][r.URL] = r
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT name, value FROM checkpoint_counters WHERE run_id=?", runID)
+	if err != nil {
+		log.Printf("Error getting checkpoint: %v", err)
+		return checkpoint, err
+	}
+	for rows.Next() {
+		var name string
+		var value int
+		if rows.Scan(&name, &value) != nil {
+			continue
+		}
+		if strings.HasPrefix(name, counterSkipped) {
+			checkpoint.Skipped[strings.TrimPrefix(name, counterSkipped)] = value
+		} else {
+			checkpoint.Counters[name] = value
+		}
+	}
+	rows.Close()
+
+	return checkpoint, nil
+}
+
+// DeleteCheckpoint is called when the run is finished and the results are saved
+func DeleteCheckpoint(dbFilepath string, runID int64) error {
+	checkpointMutex.Lock()
+	defer checkpointMutex.Unlock()
+
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer db.Close()
+
+	for _, table := range []string{"checkpoint_hosts", "checkpoint_urls", "checkpoint_links", "checkpoint_link_pages", "checkpoint_pages", "checkpoint_embeds", "checkpoint_resolutions", "checkpoint_counters"} {
+		_, err = db.Exec("DELETE FROM "+table+" WHERE run_id=?", runID)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoint(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+	runID, err := StartRun(DBFilepath)
+	assert.NoError(t, err)
+
+	assert.NoError(t, SaveCheckpointHost(DBFilepath, runID, "a.kg", HostDone))
+	assert.NoError(t, SaveCheckpointHost(DBFilepath, runID, "b.kg", HostCrawling))
+	assert.NoError(t, SaveCheckpointURL(DBFilepath, runID, "b.kg", "http://b.kg/", URLQueued))
+	assert.NoError(t, SaveCheckpointURL(DBFilepath, runID, "b.kg", "http://b.kg/news", URLQueued))
+	assert.NoError(t, SaveCheckpointURL(DBFilepath, runID, "b.kg", "http://b.kg/", URLVisited))
+	assert.NoError(t, SaveCheckpointLinks(DBFilepath, runID, "b.kg", map[string]int{"http://bit.ly/1": 2}, map[string]LinkInfo{"http://bit.ly/1": {Region: "article", Placement: PlacementContent, Pages: 1, PageURLs: map[string]bool{"http://b.kg/": true}}}))
+	assert.NoError(t, SaveCheckpointLinks(DBFilepath, runID, "b.kg", map[string]int{"http://bit.ly/1": 1, "http://c.kg/": 1}, map[string]LinkInfo{"http://bit.ly/1": {Region: ".sidebar", Placement: PlacementSidebar, Pages: 1, PageURLs: map[string]bool{"http://b.kg/about": true}, Hidden: HiddenFontSize}, "http://c.kg/": {Pages: 1, Banner: &Banner{ImageURL: "http://c.kg/1.gif"}}}))
+	assert.NoError(t, SaveCheckpointEmbeds(DBFilepath, runID, "b.kg", []string{"mc.yandex.ru", "an.yandex.ru"}))
+	assert.NoError(t, SaveCheckpointEmbeds(DBFilepath, runID, "b.kg", []string{"mc.yandex.ru"}))
+	assert.NoError(t, SaveCheckpointResolution(DBFilepath, runID, "b.kg", Resolution{
+		URL: "http://bit.ly/1", Times: 3, Resolved: "http://d.kg/", ContentType: "text/html", State: ResolutionDone,
+	}))
+	assert.NoError(t, SaveCheckpointResolution(DBFilepath, runID, "b.kg", Resolution{
+		URL: "http://bit.ly/2", Times: 1, Resolved: "http://d.kg/1.pdf", ContentType: "application/pdf", State: ResolutionSkipped, Reason: "denied application/pdf",
+	}))
+	assert.NoError(t, SaveCheckpointCounters(DBFilepath, runID, map[string]int{CounterNotModified: 1}, map[string]int{SkipPathDepth: 2}))
+	assert.NoError(t, SaveCheckpointCounters(DBFilepath, runID, map[string]int{CounterNotModified: 2, CounterSameContent: 1}, map[string]int{SkipPathDepth: 3}))
+
+	checkpoint, err := GetCheckpoint(DBFilepath, runID)
+	assert.NoError(t, err)
+	assert.Equal(t, runID, checkpoint.RunID)
+	assert.Equal(t, HostDone, checkpoint.Hosts["a.kg"])
+	assert.Equal(t, HostCrawling, checkpoint.Hosts["b.kg"])
+	assert.Equal(t, []string{"http://b.kg/news"}, checkpoint.Queued["b.kg"])
+	assert.Equal(t, []string{"http://b.kg/"}, checkpoint.Visited["b.kg"])
+	assert.Equal(t, 3, checkpoint.Links["b.kg"]["http://bit.ly/1"])
+	assert.Equal(t, 1, checkpoint.Links["b.kg"]["http://c.kg/"])
+	assert.Equal(t, LinkInfo{Region: "article", Placement: PlacementContent, Pages: 2, PageURLs: map[string]bool{"http://b.kg/": true, "http://b.kg/about": true}, Hidden: HiddenFontSize}, checkpoint.LinksInfo["b.kg"]["http://bit.ly/1"])
+	assert.Equal(t, LinkInfo{Pages: 1, Banner: &Banner{ImageURL: "http://c.kg/1.gif"}}, checkpoint.LinksInfo["b.kg"]["http://c.kg/"])
+	assert.Equal(t, 2, checkpoint.Pages["b.kg"])
+	assert.Equal(t, map[string]int{"mc.yandex.ru": 2, "an.yandex.ru": 1}, checkpoint.Embeds["b.kg"])
+	assert.Equal(t, "http://d.kg/", checkpoint.Resolutions["b.kg"]["http://bit.ly/1"].Resolved)
+	assert.Equal(t, "denied application/pdf", checkpoint.Resolutions["b.kg"]["http://bit.ly/2"].Reason)
+	assert.Equal(t, map[string]int{CounterNotModified: 2, CounterSameContent: 1}, checkpoint.Counters)
+	assert.Equal(t, map[string]int{SkipPathDepth: 3}, checkpoint.Skipped)
+
+	other, err := GetCheckpoint(DBFilepath, runID+1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(other.Hosts))
+
+	assert.NoError(t, DeleteCheckpoint(DBFilepath, runID))
+	checkpoint, err = GetCheckpoint(DBFilepath, runID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(checkpoint.Hosts))
+	assert.Equal(t, 0, len(checkpoint.Links))
+	assert.Equal(t, 0, len(checkpoint.Counters))
+}
@@ -0,0 +1,255 @@
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// LinkDiff is what changed in the links between the days From and To:
+// the links found only on To, the links found only on From and the changes
+// of the counts of the links by the source and the external host
+type LinkDiff struct {
+	From    string
+	To      string
+	Added   []Monitor
+	Removed []Monitor
+	Changed []CountChange
+}
+
+// CountChange is the change of the sum of the counts of the links from the
+// source host to the external host
+type CountChange struct {
+	SourceHost   string
+	ExternalHost string
+	From         int
+	To           int
+	Delta        int
+}
+
+// GetDiff compares the links saved on the days from and to. The latest day
+// is used if to is empty and the day before it if from is empty
+func GetDiff(dbFilepath string, from string, to string) (LinkDiff, error) {
+	if from == "" || to == "" {
+		days, err := GetAllDaysFromMonitor(dbFilepath)
+		if err != nil {
+			return LinkDiff{}, err
+		}
+		from, to = diffDays(days, from, to)
+	}
+	fromLinks, err := GetAllDataFromMonitorByDay(dbFilepath, from)
+	if err != nil {
+		return LinkDiff{}, err
+	}
+	toLinks, err := GetAllDataFromMonitorByDay(dbFilepath, to)
+	if err != nil {
+		return LinkDiff{}, err
+	}
+	diff := DiffMonitors(fromLinks, toLinks)
+	diff.From, diff.To = from, to
+	return diff, nil
+}
+
+// GetRunDiff compares the links saved by the run on the hosts with the links
+// saved on them by the run before. The hosts which were not crawled before are
+// left out. From is the latest day of the links before, it is empty if none
+// of the hosts was crawled before
+func GetRunDiff(dbFilepath string, runID int64, hosts []string) (LinkDiff, error) {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return LinkDiff{}, err
+	}
+	defer db.Close()
+
+	var from, to []Monitor
+	var fromDay, toDay string
+	for _, host := range hosts {
+		var previousRun int64
+		var previousDay string
+		err := db.QueryRow("SELECT run_id, strftime('%Y-%m-%d', created) FROM monitor WHERE source_host=? AND run_id<? "+
+			"ORDER BY run_id DESC, created DESC, id DESC LIMIT 1", host, runID).Scan(&previousRun, &previousDay)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			log.Printf("Error getting the links before the run: %v", err)
+			return LinkDiff{}, err
+		}
+		// the links saved before the runs were recorded are taken by the day
+		where, args := "m.source_host=? AND m.run_id=?", []interface{}{host, previousRun}
+		if previousRun == 0 {
+			where, args = where+" AND strftime('%Y-%m-%d', m.created)=?", append(args, previousDay)
+		}
+		hostFrom, err := queryMonitor(dbFilepath, where, args...)
+		if err != nil {
+			return LinkDiff{}, err
+		}
+		hostTo, err := queryMonitor(dbFilepath, "m.source_host=? AND m.run_id=?", host, runID)
+		if err != nil {
+			return LinkDiff{}, err
+		}
+		from = append(from, hostFrom...)
+		to = append(to, hostTo...)
+		if previousDay > fromDay {
+			fromDay = previousDay
+		}
+		for _, m := range hostTo {
+			if monitorDay(m) > toDay {
+				toDay = monitorDay(m)
+			}
+		}
+	}
+	diff := DiffMonitors(from, to)
+	diff.From, diff.To = fromDay, toDay
+	return diff, nil
+}
+
+// diffDays fills the empty days with the latest day and the day before it,
+// the days are sorted from the latest one
+func diffDays(days []string, from string, to string) (string, string) {
+	if to == "" && len(days) > 0 {
+		to = days[0]
+	}
+	if from == "" {
+		for _, day := range days {
+			if day < to {
+				from = day
+				break
+			}
+		}
+	}
+	return from, to
+}
+
+// DiffMonitors compares the links of two days. Only the links saved by the
+// latest run of the source host on the day are taken. The links of the source
+// hosts which were not crawled on the day to are not removed
+func DiffMonitors(from []Monitor, to []Monitor) LinkDiff {
+	fromLinks := latestLinks(from)
+	toLinks := latestLinks(to)
+	crawled := make(map[string]bool)
+	for _, m := range to {
+		crawled[m.SourceHost] = true
+	}
+	diff := LinkDiff{Added: []Monitor{}, Removed: []Monitor{}, Changed: []CountChange{}}
+	counts := make(map[[2]string]*CountChange)
+	count := func(m Monitor) *CountChange {
+		key := [2]string{m.SourceHost, m.ExternalHost}
+		if counts[key] == nil {
+			counts[key] = &CountChange{SourceHost: m.SourceHost, ExternalHost: m.ExternalHost}
+		}
+		return counts[key]
+	}
+	for key, m := range toLinks {
+		if _, ok := fromLinks[key]; !ok {
+			diff.Added = append(diff.Added, m)
+		}
+		count(m).To += m.Count
+	}
+	for key, m := range fromLinks {
+		if !crawled[m.SourceHost] {
+			continue
+		}
+		if _, ok := toLinks[key]; !ok {
+			diff.Removed = append(diff.Removed, m)
+		}
+		count(m).From += m.Count
+	}
+	for _, change := range counts {
+		change.Delta = change.To - change.From
+		if change.Delta != 0 {
+			diff.Changed = append(diff.Changed, *change)
+		}
+	}
+	sortMonitors(diff.Added)
+	sortMonitors(diff.Removed)
+	sort.Slice(diff.Changed, func(i, j int) bool {
+		a, b := diff.Changed[i], diff.Changed[j]
+		if abs(a.Delta) != abs(b.Delta) {
+			return abs(a.Delta) > abs(b.Delta)
+		}
+		if a.SourceHost != b.SourceHost {
+			return a.SourceHost < b.SourceHost
+		}
+		return a.ExternalHost < b.ExternalHost
+	})
+	return diff
+}
+
+// latestLegacyOfDay keeps the latest row of the link of the day for the links
+// of the monitor as m saved before the runs were recorded, with no run id
+const latestLegacyOfDay = "m.id = (SELECT l.id FROM monitor as l WHERE l.source_host=m.source_host AND l.external_link=m.external_link " +
+	"AND l.run_id=0 AND strftime('%Y-%m-%d', l.created)=strftime('%Y-%m-%d', m.created) ORDER BY l.created DESC, l.id DESC LIMIT 1)"
+
+// latestRunOfDay is the rule of latestLinks for the queries of the monitor as m:
+// only the links saved by the latest run of the source host on the day are kept
+const latestRunOfDay = "m.run_id = (SELECT max(l.run_id) FROM monitor as l WHERE l.source_host=m.source_host " +
+	"AND strftime('%Y-%m-%d', l.created)=strftime('%Y-%m-%d', m.created)) AND (m.run_id > 0 OR " + latestLegacyOfDay + ")"
+
+// latestLinks keeps the links saved by the latest run of every source host on
+// the day, the link a run saved twice is taken once. The links saved before
+// the runs were recorded are taken by the day, with the latest row of the link
+func latestLinks(monitors []Monitor) map[[2]string]Monitor {
+	latestRuns := make(map[[2]string]int64)
+	for _, m := range monitors {
+		key := [2]string{m.SourceHost, monitorDay(m)}
+		if m.RunID > latestRuns[key] {
+			latestRuns[key] = m.RunID
+		}
+	}
+	links := make(map[[2]string]Monitor)
+	for _, m := range monitors {
+		if m.RunID != latestRuns[[2]string{m.SourceHost, monitorDay(m)}] {
+			continue
+		}
+		key := [2]string{m.SourceHost, m.ExternalLink}
+		if seen, ok := links[key]; !ok || m.Created > seen.Created {
+			links[key] = m
+		}
+	}
+	return links
+}
+
+// monitorDay is the day the link was saved on
+func monitorDay(m Monitor) string {
+	if len(m.Created) < 10 {
+		return m.Created
+	}
+	return m.Created[:10]
+}
+
+func sortMonitors(monitors []Monitor) {
+	sort.Slice(monitors, func(i, j int) bool {
+		if monitors[i].SourceHost != monitors[j].SourceHost {
+			return monitors[i].SourceHost < monitors[j].SourceHost
+		}
+		return monitors[i].ExternalLink < monitors[j].ExternalLink
+	})
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// FormatDiff makes the text of the diff for the console
+func FormatDiff(diff LinkDiff) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Links from %v to %v: %d added, %d removed, %d counts changed\n",
+		diff.From, diff.To, len(diff.Added), len(diff.Removed), len(diff.Changed))
+	for _, m := range diff.Added {
+		fmt.Fprintf(&b, "+ %v %v (%d)\n", m.SourceHost, m.ExternalLink, m.Count)
+	}
+	for _, m := range diff.Removed {
+		fmt.Fprintf(&b, "- %v %v (%d)\n", m.SourceHost, m.ExternalLink, m.Count)
+	}
+	for _, c := range diff.Changed {
+		fmt.Fprintf(&b, "~ %v -> %v: %d -> %d (%+d)\n", c.SourceHost, c.ExternalHost, c.From, c.To, c.Delta)
+	}
+	return b.String()
+}
@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffMonitors(t *testing.T) {
+	from := []Monitor{
+		{SourceHost: "a.kg", ExternalLink: "http://x.kg/1", ExternalHost: "x.kg", Count: 2},
+		{SourceHost: "a.kg", ExternalLink: "http://x.kg/2", ExternalHost: "x.kg", Count: 1},
+		{SourceHost: "a.kg", ExternalLink: "http://y.kg/", ExternalHost: "y.kg", Count: 1},
+		// c.kg was not crawled on the day to
+		{SourceHost: "c.kg", ExternalLink: "http://y.kg/", ExternalHost: "y.kg", Count: 3},
+	}
+	to := []Monitor{
+		{SourceHost: "a.kg", ExternalLink: "http://x.kg/1", ExternalHost: "x.kg", Count: 5, Created: "2017-01-02T10:00:00Z"},
+		{SourceHost: "a.kg", ExternalLink: "http://x.kg/1", ExternalHost: "x.kg", Count: 4, Created: "2017-01-02T09:00:00Z"},
+		{SourceHost: "a.kg", ExternalLink: "http://y.kg/", ExternalHost: "y.kg", Count: 1},
+		{SourceHost: "b.kg", ExternalLink: "http://z.kg/", ExternalHost: "z.kg", Count: 1},
+	}
+
+	diff := DiffMonitors(from, to)
+	assert.Equal(t, 1, len(diff.Added))
+	assert.Equal(t, "http://z.kg/", diff.Added[0].ExternalLink)
+	assert.Equal(t, 1, len(diff.Removed))
+	assert.Equal(t, "http://x.kg/2", diff.Removed[0].ExternalLink)
+	assert.Equal(t, []CountChange{
+		{SourceHost: "a.kg", ExternalHost: "x.kg", From: 3, To: 5, Delta: 2},
+		{SourceHost: "b.kg", ExternalHost: "z.kg", From: 0, To: 1, Delta: 1},
+	}, diff.Changed)
+}
+
+func TestDiffDays(t *testing.T) {
+	days := []string{"2017-01-03", "2017-01-02", "2017-01-01"}
+	from, to := diffDays(days, "", "")
+	assert.Equal(t, "2017-01-02", from)
+	assert.Equal(t, "2017-01-03", to)
+	from, to = diffDays(days, "", "2017-01-02")
+	assert.Equal(t, "2017-01-01", from)
+	assert.Equal(t, "2017-01-02", to)
+	from, _ = diffDays(days, "", "2017-01-01")
+	assert.Equal(t, "", from)
+}
+
+func TestGetDiff(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+	db, _ := sql.Open("sqlite3", DBFilepath)
+	for _, row := range [][]string{
+		{"a.kg", "http://x.kg/", "2017-01-01 10:00:00"},
+		{"a.kg", "http://x.kg/", "2017-01-02 10:00:00"},
+		{"a.kg", "http://y.kg/", "2017-01-02 10:00:00"},
+	} {
+		db.Exec("insert into monitor(source_host, external_link, count, external_host, created) values(?, ?, 1, '', ?)", row[0], row[1], row[2])
+	}
+	db.Close()
+
+	diff, err := GetDiff(DBFilepath, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "2017-01-01", diff.From)
+	assert.Equal(t, "2017-01-02", diff.To)
+	assert.Equal(t, 1, len(diff.Added))
+	assert.Equal(t, 0, len(diff.Removed))
+	assert.Contains(t, FormatDiff(diff), "+ a.kg http://y.kg/ (1)")
+
+	excelFilePath := "/tmp/spiderwoman-diff.xls"
+	os.Remove(excelFilePath)
+	CreateEmptyExcel(excelFilePath)
+	assert.NoError(t, AppendDiffExcelFromDB(DBFilepath, excelFilePath, "2017-01-01", "2017-01-02"))
+}
+
+func TestGetDiffOfSameDayRuns(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+	db, _ := sql.Open("sqlite3", DBFilepath)
+	for _, row := range []struct {
+		runID         int64
+		link, created string
+	}{
+		{1, "http://x.kg/", "2017-01-01 10:00:00"},
+		{1, "http://y.kg/", "2017-01-01 10:00:00"},
+		{2, "http://x.kg/", "2017-01-02 09:00:00"},
+		{2, "http://y.kg/", "2017-01-02 09:00:00"},
+		// the second run of the day does not find y.kg any more
+		{3, "http://x.kg/", "2017-01-02 15:00:00"},
+	} {
+		db.Exec("insert into monitor(run_id, source_host, external_link, count, external_host, created) values(?, 'a.kg', ?, 1, '', ?)",
+			row.runID, row.link, row.created)
+	}
+	db.Close()
+
+	diff, err := GetDiff(DBFilepath, "2017-01-01", "2017-01-02")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(diff.Added))
+	assert.Equal(t, 1, len(diff.Removed))
+	assert.Equal(t, "http://y.kg/", diff.Removed[0].ExternalLink)
+
+	ranks, err := GetLeaderboard(DBFilepath, "2017-01-02", RankBySources, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(ranks))
+	assert.Equal(t, 1, ranks[0].Links)
+}
+
+func TestGetRunDiff(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+	SaveRecordToMonitor(DBFilepath, "a.kg", "http://x.kg/1", 1, "x.kg")
+	SaveRunRecordToMonitor(DBFilepath, 1, "a.kg", "http://x.kg/1", 2, "x.kg", LinkInfo{})
+	SaveRunRecordToMonitor(DBFilepath, 1, "a.kg", "http://y.kg/", 1, "y.kg", LinkInfo{})
+	SaveRunRecordToMonitor(DBFilepath, 1, "b.kg", "http://z.kg/", 1, "z.kg", LinkInfo{})
+	SaveRunRecordToMonitor(DBFilepath, 2, "a.kg", "http://x.kg/1", 2, "x.kg", LinkInfo{})
+	SaveRunRecordToMonitor(DBFilepath, 2, "c.kg", "http://w.kg/", 1, "w.kg", LinkInfo{})
+
+	// b.kg was not crawled by the run 2 and c.kg was not crawled before it
+	diff, err := GetRunDiff(DBFilepath, 2, []string{"a.kg", "c.kg"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, "", diff.From)
+	assert.Equal(t, 0, len(diff.Added))
+	assert.Equal(t, 1, len(diff.Removed))
+	assert.Equal(t, "http://y.kg/", diff.Removed[0].ExternalLink)
+	assert.Equal(t, []CountChange{{SourceHost: "a.kg", ExternalHost: "y.kg", From: 1, To: 0, Delta: -1}}, diff.Changed)
+
+	// the run 1 is compared with the links saved before the runs
+	diff, _ = GetRunDiff(DBFilepath, 1, []string{"a.kg", "b.kg"})
+	assert.Equal(t, 1, len(diff.Added))
+	assert.Equal(t, "http://y.kg/", diff.Added[0].ExternalLink)
+
+	diff, _ = GetRunDiff(DBFilepath, 1, []string{"b.kg"})
+	assert.Equal(t, "", diff.From)
+	assert.Equal(t, 0, len(diff.Changed))
+}
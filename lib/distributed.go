@@ -0,0 +1,244 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrJobLost = errors.New("the job was given to another worker or canceled")
+
+// CoordinatorTokenHeader carries the token shared by the coordinator and the workers
+const CoordinatorTokenHeader = "X-Spiderwoman-Token"
+
+// Coordinator hands the jobs of the run to the workers over HTTP, every
+// request must have the Token in the CoordinatorTokenHeader:
+//
+//	POST /jobs/lease           {"worker": "w1"}                  200 with the job, 204 if there is nothing to do
+//	POST /jobs/:id/heartbeat   {"worker": "w1"}                  200, 409 if the worker has lost the job
+//	POST /jobs/:id/result      {"worker": "w1", "result": {...}} 200, 409 if the worker has lost the job
+//	POST /jobs/:id/fail        {"worker": "w1", "error": "..."}  200, 409 if the worker has lost the job
+//	POST /jobs/:id/release     {"worker": "w1", "error": "..."}  200, 409 if the worker has lost the job
+type Coordinator struct {
+	DBFilepath  string
+	Token       string
+	Lease       time.Duration
+	MaxAttempts int
+}
+
+type jobRequest struct {
+	Worker string          `json:"worker"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get(CoordinatorTokenHeader)
+	if c.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var request jobRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Worker == "" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	if r.URL.Path == "/jobs/lease" {
+		job, found, err := LeaseJob(c.DBFilepath, request.Worker, c.Lease, c.MaxAttempts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		log.Printf("Job %v (%v %v) is leased by %v, attempt %d", job.ID, job.Kind, job.Host, job.Worker, job.Attempts)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "jobs" {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var ok bool
+	switch parts[2] {
+	case "heartbeat":
+		ok, err = HeartbeatJob(c.DBFilepath, id, request.Worker, c.Lease)
+	case "result":
+		ok, err = CompleteJob(c.DBFilepath, id, request.Worker, request.Result)
+	case "fail":
+		log.Printf("Job %v failed on %v: %v", id, request.Worker, request.Error)
+		ok, err = FailJob(c.DBFilepath, id, request.Worker, request.Error, c.MaxAttempts)
+	case "release":
+		log.Printf("Job %v is released by %v: %v", id, request.Worker, request.Error)
+		ok, err = ReleaseJob(c.DBFilepath, id, request.Worker, request.Error)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, ErrJobLost.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// JobHandler does the job on the worker and returns the result to upload
+type JobHandler func(ctx context.Context, job Job) (interface{}, error)
+
+// Worker takes the jobs from the coordinator one by one. The lease on the job
+// is extended every HeartbeatInterval while the handler works, the handler's
+// ctx is canceled if the worker has lost the job
+type Worker struct {
+	Name              string
+	CoordinatorURL    string
+	Token             string
+	Client            *http.Client
+	PollInterval      time.Duration
+	HeartbeatInterval time.Duration
+	Handlers          map[string]JobHandler
+}
+
+// Run does the jobs until the ctx is canceled
+func (w *Worker) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		worked, err := w.RunOnce(ctx)
+		if err != nil {
+			log.Printf("Worker %v: %v", w.Name, err)
+		}
+		if worked && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(w.PollInterval):
+		}
+	}
+}
+
+// RunOnce leases one job and does it. The job stopped by the cancel of the ctx,
+// e.g. on the shutdown of the worker, is released to the other workers without
+// using up the attempt. False is returned if there was nothing to do
+func (w *Worker) RunOnce(ctx context.Context) (bool, error) {
+	job, found, err := w.lease()
+	if err != nil || !found {
+		return false, err
+	}
+	log.Printf("Worker %v got the job %v (%v %v)", w.Name, job.ID, job.Kind, job.Host)
+
+	handler, ok := w.Handlers[job.Kind]
+	if !ok {
+		return true, w.post(job.ID, "fail", jobRequest{Error: "unknown job kind " + job.Kind})
+	}
+
+	jobCtx, cancelJob := context.WithCancel(ctx)
+	defer cancelJob()
+	heartbeatDone := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-heartbeatDone:
+				return
+			case <-time.After(w.HeartbeatInterval):
+				if err := w.post(job.ID, "heartbeat", jobRequest{}); err == ErrJobLost {
+					log.Printf("Worker %v has lost the job %v, stopping it", w.Name, job.ID)
+					cancelJob()
+					return
+				}
+			}
+		}
+	}()
+	result, err := handler(jobCtx, job)
+	close(heartbeatDone)
+	if ctx.Err() != nil {
+		return true, w.post(job.ID, "release", jobRequest{Error: "worker " + w.Name + " stopped"})
+	}
+	if jobCtx.Err() != nil {
+		return true, ErrJobLost
+	}
+	if err != nil {
+		return true, w.post(job.ID, "fail", jobRequest{Error: err.Error()})
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return true, w.post(job.ID, "fail", jobRequest{Error: err.Error()})
+	}
+	return true, w.post(job.ID, "result", jobRequest{Result: data})
+}
+
+func (w *Worker) lease() (Job, bool, error) {
+	var job Job
+	res, err := w.send("/jobs/lease", jobRequest{})
+	if err != nil {
+		return job, false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNoContent {
+		return job, false, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return job, false, fmt.Errorf("lease: %v", res.Status)
+	}
+	err = json.NewDecoder(res.Body).Decode(&job)
+	return job, err == nil, err
+}
+
+func (w *Worker) post(id int64, action string, request jobRequest) error {
+	res, err := w.send(fmt.Sprintf("/jobs/%d/%s", id, action), request)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusConflict {
+		return ErrJobLost
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v: %v", action, res.Status)
+	}
+	return nil
+}
+
+func (w *Worker) send(path string, request jobRequest) (*http.Response, error) {
+	request.Worker = w.Name
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(w.CoordinatorURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(CoordinatorTokenHeader, w.Token)
+	return client.Do(req)
+}
@@ -0,0 +1,279 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobs(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	id, err := EnqueueJob(DBFilepath, 1, JobCrawl, "a.kg", CrawlJob{Seeds: []string{"http://a.kg"}, MaxVisits: 10})
+	assert.NoError(t, err)
+
+	job, found, err := LeaseJob(DBFilepath, "w1", time.Minute, 2)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, id, job.ID)
+	assert.Equal(t, "a.kg", job.Host)
+	assert.Equal(t, 1, job.Attempts)
+	var payload CrawlJob
+	assert.NoError(t, json.Unmarshal(job.Payload, &payload))
+	assert.Equal(t, 10, payload.MaxVisits)
+
+	_, found, _ = LeaseJob(DBFilepath, "w2", time.Minute, 2)
+	assert.False(t, found)
+
+	ok, _ := HeartbeatJob(DBFilepath, id, "w2", time.Minute)
+	assert.False(t, ok)
+	ok, _ = HeartbeatJob(DBFilepath, id, "w1", time.Minute)
+	assert.True(t, ok)
+
+	ok, _ = FailJob(DBFilepath, id, "w1", "timeout", 2)
+	assert.True(t, ok)
+	job, found, _ = LeaseJob(DBFilepath, "w2", time.Millisecond, 2)
+	assert.True(t, found)
+	assert.Equal(t, 2, job.Attempts)
+
+	// the lease has expired and all the attempts are made
+	time.Sleep(5 * time.Millisecond)
+	_, found, _ = LeaseJob(DBFilepath, "w1", time.Minute, 2)
+	assert.False(t, found)
+	ok, _ = CompleteJob(DBFilepath, id, "w2", CrawlResult{})
+	assert.False(t, ok)
+
+	jobs, _ := GetJobs(DBFilepath, 1, JobCrawl)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, JobFailed, jobs[0].State)
+	assert.Equal(t, "lease expired", jobs[0].Error)
+	assert.True(t, JobFinished(jobs[0]))
+
+	id, _ = EnqueueJob(DBFilepath, 1, JobResolve, "a.kg", ResolveJob{Links: map[string]int{"http://bit.ly/1": 2}})
+	LeaseJob(DBFilepath, "w1", time.Minute, 2)
+	ok, _ = CompleteJob(DBFilepath, id, "w1", ResolveResult{Resolved: map[string]int{"http://b.kg": 2}})
+	assert.True(t, ok)
+	jobs, _ = GetJobs(DBFilepath, 1, JobResolve)
+	var result ResolveResult
+	assert.NoError(t, json.Unmarshal(jobs[0].Result, &result))
+	assert.Equal(t, 2, result.Resolved["http://b.kg"])
+
+	EnqueueJob(DBFilepath, 1, JobResolve, "b.kg", ResolveJob{})
+	assert.NoError(t, CancelJobs(DBFilepath, 1))
+	jobs, _ = GetJobs(DBFilepath, 1, JobResolve)
+	assert.Equal(t, JobCanceled, jobs[1].State)
+
+	assert.NoError(t, RequeueCanceledJobs(DBFilepath, 1))
+	jobs, _ = GetJobs(DBFilepath, 1, JobResolve)
+	assert.Equal(t, JobDone, jobs[0].State)
+	assert.Equal(t, JobPending, jobs[1].State)
+}
+
+func TestExpireJobs(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+	EnqueueJob(DBFilepath, 1, JobCrawl, "a.kg", CrawlJob{})
+
+	// the workers die, no one asks for the job after them
+	LeaseJob(DBFilepath, "w1", time.Millisecond, 2)
+	time.Sleep(5 * time.Millisecond)
+	assert.NoError(t, ExpireJobs(DBFilepath, 2))
+	jobs, _ := GetJobs(DBFilepath, 1, JobCrawl)
+	assert.Equal(t, JobPending, jobs[0].State)
+
+	LeaseJob(DBFilepath, "w2", time.Millisecond, 2)
+	time.Sleep(5 * time.Millisecond)
+	assert.NoError(t, ExpireJobs(DBFilepath, 2))
+	jobs, _ = GetJobs(DBFilepath, 1, JobCrawl)
+	assert.Equal(t, JobFailed, jobs[0].State)
+	assert.Equal(t, "lease expired", jobs[0].Error)
+}
+
+func TestCoordinatorAndWorkers(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	ts := httptest.NewServer(&Coordinator{DBFilepath: DBFilepath, Token: "secret", Lease: time.Minute, MaxAttempts: 3})
+	defer ts.Close()
+
+	hosts := []string{"a.kg", "b.kg", "c.kg", "d.kg", "e.kg"}
+	for _, host := range hosts {
+		EnqueueJob(DBFilepath, 1, JobCrawl, host, CrawlJob{})
+	}
+
+	var mutex sync.Mutex
+	failed := false
+	crawl := func(ctx context.Context, job Job) (interface{}, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if job.Host == "c.kg" && !failed {
+			failed = true
+			return nil, errors.New("connection refused")
+		}
+		return CrawlResult{Links: map[string]map[string]int{job.Host: {"http://" + job.Host + "/out": 1}}}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, name := range []string{"w1", "w2", "w3"} {
+		wg.Add(1)
+		worker := &Worker{
+			Name:              name,
+			CoordinatorURL:    ts.URL,
+			Token:             "secret",
+			PollInterval:      10 * time.Millisecond,
+			HeartbeatInterval: time.Second,
+			Handlers:          map[string]JobHandler{JobCrawl: crawl},
+		}
+		go func() {
+			worker.Run(ctx)
+			wg.Done()
+		}()
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		jobs, _ := GetJobs(DBFilepath, 1, JobCrawl)
+		finished := 0
+		for _, job := range jobs {
+			if JobFinished(job) {
+				finished++
+			}
+		}
+		if finished == len(hosts) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+
+	jobs, _ := GetJobs(DBFilepath, 1, JobCrawl)
+	for _, job := range jobs {
+		assert.Equal(t, JobDone, job.State, job.Host)
+		var result CrawlResult
+		assert.NoError(t, json.Unmarshal(job.Result, &result))
+		assert.Equal(t, 1, result.Links[job.Host]["http://"+job.Host+"/out"])
+		if job.Host == "c.kg" {
+			assert.Equal(t, 2, job.Attempts)
+		}
+	}
+}
+
+func TestWorkerLostJob(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	ts := httptest.NewServer(&Coordinator{DBFilepath: DBFilepath, Token: "secret", Lease: 20 * time.Millisecond, MaxAttempts: 3})
+	defer ts.Close()
+	id, _ := EnqueueJob(DBFilepath, 1, JobCrawl, "a.kg", CrawlJob{})
+
+	// the first worker hangs and misses its heartbeats, the lease expires
+	hanging := &Worker{
+		Name:              "w1",
+		CoordinatorURL:    ts.URL,
+		Token:             "secret",
+		HeartbeatInterval: 100 * time.Millisecond,
+		Handlers: map[string]JobHandler{JobCrawl: func(ctx context.Context, job Job) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}},
+	}
+	done := make(chan error)
+	go func() {
+		_, err := hanging.RunOnce(context.Background())
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	healthy := &Worker{
+		Name:              "w2",
+		CoordinatorURL:    ts.URL,
+		Token:             "secret",
+		HeartbeatInterval: time.Second,
+		Handlers: map[string]JobHandler{JobCrawl: func(ctx context.Context, job Job) (interface{}, error) {
+			return CrawlResult{Visited: 1}, nil
+		}},
+	}
+	worked, err := healthy.RunOnce(context.Background())
+	assert.True(t, worked)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrJobLost, <-done)
+
+	jobs, _ := GetJobs(DBFilepath, 1, JobCrawl)
+	assert.Equal(t, id, jobs[0].ID)
+	assert.Equal(t, JobDone, jobs[0].State)
+	assert.Equal(t, "w2", jobs[0].Worker)
+	assert.Equal(t, 2, jobs[0].Attempts)
+}
+
+func TestWorkerShutdown(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	ts := httptest.NewServer(&Coordinator{DBFilepath: DBFilepath, Token: "secret", Lease: time.Minute, MaxAttempts: 1})
+	defer ts.Close()
+	EnqueueJob(DBFilepath, 1, JobCrawl, "a.kg", CrawlJob{})
+
+	ctx, stop := context.WithCancel(context.Background())
+	worker := &Worker{
+		Name:              "w1",
+		CoordinatorURL:    ts.URL,
+		Token:             "secret",
+		HeartbeatInterval: time.Second,
+		Handlers: map[string]JobHandler{JobCrawl: func(ctx context.Context, job Job) (interface{}, error) {
+			stop()
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}},
+	}
+	worked, err := worker.RunOnce(ctx)
+	assert.True(t, worked)
+	assert.NoError(t, err)
+
+	// the job is given to the next worker with the attempt left
+	jobs, _ := GetJobs(DBFilepath, 1, JobCrawl)
+	assert.Equal(t, JobPending, jobs[0].State)
+	assert.Equal(t, 0, jobs[0].Attempts)
+	job, found, _ := LeaseJob(DBFilepath, "w2", time.Minute, 1)
+	assert.True(t, found)
+	assert.Equal(t, 1, job.Attempts)
+}
+
+func TestCoordinatorToken(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	ts := httptest.NewServer(&Coordinator{DBFilepath: DBFilepath, Token: "secret", Lease: time.Minute, MaxAttempts: 3})
+	defer ts.Close()
+	EnqueueJob(DBFilepath, 1, JobCrawl, "a.kg", CrawlJob{})
+
+	for _, token := range []string{"", "wrong"} {
+		worker := &Worker{Name: "w1", CoordinatorURL: ts.URL, Token: token}
+		_, err := worker.RunOnce(context.Background())
+		assert.Error(t, err)
+	}
+	jobs, _ := GetJobs(DBFilepath, 1, JobCrawl)
+	assert.Equal(t, JobPending, jobs[0].State)
+
+	res, err := http.Post(ts.URL+"/jobs/1/result", "application/json", strings.NewReader(`{"worker": "w1"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	// the coordinator without the token serves nobody
+	open := httptest.NewServer(&Coordinator{DBFilepath: DBFilepath, Lease: time.Minute, MaxAttempts: 3})
+	defer open.Close()
+	worker := &Worker{Name: "w1", CoordinatorURL: open.URL}
+	_, err = worker.RunOnce(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/net/idna"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// DecodeToUTF8 converts the page body to UTF-8. The charset is detected from
+// the BOM, the Content-Type header and the meta tags, in that order. The body
+// is left as UTF-8 if none of them declares the charset, the windows-1252 guess
+// of charset.DetermineEncoding garbles the UTF-8 pages with an ASCII head.
+// The name of the detected charset is returned too
+func DecodeToUTF8(body []byte, contentType string) ([]byte, string, error) {
+	encoding, name, certain := charset.DetermineEncoding(body, contentType)
+	if name == "utf-8" || (!certain && !declaresCharset(body)) {
+		return bytes.TrimPrefix(body, utf8BOM), "utf-8", nil
+	}
+	decoded, err := encoding.NewDecoder().Bytes(body)
+	if err != nil {
+		return body, name, err
+	}
+	return decoded, name, nil
+}
+
+// declaresCharset reports the meta tag with the charset in the first 1024 bytes,
+// the part of the page charset.DetermineEncoding looks at
+func declaresCharset(body []byte) bool {
+	if len(body) > 1024 {
+		body = body[:1024]
+	}
+	head := bytes.ToLower(body)
+	for {
+		i := bytes.Index(head, []byte("<meta"))
+		if i < 0 {
+			return false
+		}
+		head = head[i+len("<meta"):]
+		tag := head
+		if end := bytes.IndexByte(head, '>'); end >= 0 {
+			tag = head[:end]
+		}
+		if bytes.Contains(tag, []byte("charset")) {
+			return true
+		}
+	}
+}
+
+// NormalizeURL encodes the link the same way whatever form it had on the page:
+// internationalized hosts are converted to punycode, non-ASCII characters in
+// path, query and fragment are percent-encoded and escapes are upper-cased.
+// The link is returned as is if it can not be parsed
+func NormalizeURL(href string) string {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return href
+	}
+	if u.Host != "" {
+		host, err := idna.ToASCII(strings.ToLower(u.Hostname()))
+		if err == nil {
+			if u.Port() != "" {
+				host = host + ":" + u.Port()
+			}
+			u.Host = host
+		}
+	}
+	// keep the escapes of the original path, e.g. %2F is not the same as /
+	u.RawPath = escapeNonASCII(u.EscapedPath())
+	if path, err := url.PathUnescape(u.RawPath); err == nil {
+		u.Path = path
+	}
+	u.RawQuery = escapeNonASCII(u.RawQuery)
+	u.RawFragment = ""
+	return u.String()
+}
+
+func escapeNonASCII(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			buf.WriteByte('%')
+			buf.WriteString(strings.ToUpper(s[i+1 : i+3]))
+			i += 2
+		case c >= 0x80 || c == ' ':
+			buf.WriteString(fmt.Sprintf("%%%02X", c))
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
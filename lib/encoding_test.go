@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestDecodeToUTF8FromHeader(t *testing.T) {
+	body, _ := charmap.Windows1251.NewEncoder().Bytes([]byte("<html><body><a href=\"/новости\">Новости</a></body></html>"))
+
+	decoded, name, err := DecodeToUTF8(body, "text/html; charset=windows-1251")
+	assert.NoError(t, err)
+	assert.Equal(t, "windows-1251", name)
+	assert.Contains(t, string(decoded), "Новости")
+}
+
+func TestDecodeToUTF8FromMeta(t *testing.T) {
+	body, _ := charmap.KOI8R.NewEncoder().Bytes([]byte("<html><head><meta charset=\"koi8-r\"></head><body>Привет</body></html>"))
+
+	decoded, name, err := DecodeToUTF8(body, "text/html")
+	assert.NoError(t, err)
+	assert.Equal(t, "koi8-r", name)
+	assert.Contains(t, string(decoded), "Привет")
+}
+
+func TestDecodeToUTF8BOM(t *testing.T) {
+	body := append([]byte{0xEF, 0xBB, 0xBF}, []byte("<html>Привет</html>")...)
+
+	decoded, name, err := DecodeToUTF8(body, "text/html; charset=windows-1251")
+	assert.NoError(t, err)
+	assert.Equal(t, "utf-8", name)
+	assert.Equal(t, "<html>Привет</html>", string(decoded))
+}
+
+func TestDecodeToUTF8Undeclared(t *testing.T) {
+	body := []byte("<html><head><meta name=\"viewport\">" + strings.Repeat("<script src=\"/a.js\" charset=\"utf-8\"></script>", 60) + "</head><body>Привет</body></html>")
+
+	decoded, name, err := DecodeToUTF8(body, "text/html")
+	assert.NoError(t, err)
+	assert.Equal(t, "utf-8", name)
+	assert.Equal(t, string(body), string(decoded))
+}
+
+func TestNormalizeURL(t *testing.T) {
+	expected := "http://xn--d1acpjx3f.xn--p1ai/%D0%BD%D0%BE%D0%B2%D0%BE%D1%81%D1%82%D0%B8?q=%D0%B0"
+	assert.Equal(t, expected, NormalizeURL("http://Яндекс.рф/новости?q=а"))
+	assert.Equal(t, expected, NormalizeURL("http://xn--d1acpjx3f.xn--p1ai/%d0%bd%d0%be%d0%b2%d0%be%d1%81%d1%82%d0%b8?q=%d0%b0"))
+	assert.Equal(t, "http://a.kg/go.php?url=http://b.kg", NormalizeURL("http://a.kg/go.php?url=http://b.kg"))
+	assert.Equal(t, "http://a.kg:8080/", NormalizeURL("http://A.kg:8080/"))
+	assert.Equal(t, "http://a.kg/a%2Fb", NormalizeURL("http://a.kg/a%2fb"))
+	assert.NotEqual(t, NormalizeURL("http://a.kg/a/b"), NormalizeURL("http://a.kg/a%2Fb"))
+}
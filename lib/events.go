@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	EventHostStarted   = "host_started"
+	EventHostFinished  = "host_finished"
+	EventPageVisited   = "page_visited"
+	EventResolveFailed = "resolve_failed"
+	EventProgress      = "progress"
+	EventRunFinished   = "run_finished"
+)
+
+// the crawler emits events from many goroutines, sqlite does not like it
+var eventsMutex sync.Mutex
+
+// Event is one step of the run the API streams to the browser. Only the
+// fields which make sense for the Kind are set, e.g. Progress and ETA
+// (in seconds, 0 if it is unknown yet) for the progress events
+type Event struct {
+	ID       int64        `json:"id"`
+	RunID    int64        `json:"run_id"`
+	Kind     string       `json:"kind"`
+	Host     string       `json:"host,omitempty"`
+	URL      string       `json:"url,omitempty"`
+	Count    int          `json:"count,omitempty"`
+	Message  string       `json:"message,omitempty"`
+	Progress *RunProgress `json:"progress,omitempty"`
+	ETA      int          `json:"eta,omitempty"`
+	Created  string       `json:"created"`
+}
+
+func SaveEvent(dbFilepath string, event Event) error {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer db.Close()
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	_, err = db.Exec("insert into events(run_id, kind, data, created) values(?, ?, ?, DateTime('now'))",
+		event.RunID, event.Kind, string(data))
+	if err != nil {
+		log.Printf("Error saving event: %v", err)
+	}
+	return err
+}
+
+// GetEventsAfter returns the events of the run with id greater than afterID, oldest first
+func GetEventsAfter(dbFilepath string, runID int64, afterID int64) ([]Event, error) {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, data, created FROM events WHERE run_id=? AND id>? ORDER BY id", runID, afterID)
+	if err != nil {
+		log.Printf("Error getting events: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var event Event
+		var id int64
+		var data, created string
+		if err := rows.Scan(&id, &data, &created); err != nil {
+			log.Printf("Error getting events: %v", err)
+			continue
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			log.Printf("Error getting events: %v", err)
+			continue
+		}
+		event.ID = id
+		event.RunID = runID
+		event.Created = created
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// DeleteOldEvents keeps the events of the last keepRuns runs up to runID only
+func DeleteOldEvents(dbFilepath string, runID int64, keepRuns int64) error {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("DELETE FROM events WHERE run_id<=?", runID-keepRuns)
+	if err != nil {
+		log.Print(err)
+	}
+	return err
+}
+
+// EstimateETA expects the rest of the work to go at the same pace as the done part
+func EstimateETA(elapsed time.Duration, done int, total int) time.Duration {
+	if done <= 0 || total <= done {
+		return 0
+	}
+	return elapsed / time.Duration(done) * time.Duration(total-done)
+}
@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvents(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	assert.NoError(t, SaveEvent(DBFilepath, Event{RunID: 1, Kind: EventHostStarted, Host: "a.kg"}))
+	assert.NoError(t, SaveEvent(DBFilepath, Event{RunID: 2, Kind: EventHostStarted, Host: "b.kg"}))
+	assert.NoError(t, SaveEvent(DBFilepath, Event{RunID: 2, Kind: EventProgress,
+		Progress: &RunProgress{HostsTotal: 2, HostsDone: 1}, ETA: 30}))
+
+	events, err := GetEventsAfter(DBFilepath, 2, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, EventHostStarted, events[0].Kind)
+	assert.Equal(t, "b.kg", events[0].Host)
+	assert.NotEqual(t, "", events[0].Created)
+	assert.Equal(t, EventProgress, events[1].Kind)
+	assert.Equal(t, 1, events[1].Progress.HostsDone)
+	assert.Equal(t, 30, events[1].ETA)
+
+	events, err = GetEventsAfter(DBFilepath, 2, events[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, EventProgress, events[0].Kind)
+
+	assert.NoError(t, DeleteOldEvents(DBFilepath, 2, 1))
+	events, _ = GetEventsAfter(DBFilepath, 1, 0)
+	assert.Equal(t, 0, len(events))
+	events, _ = GetEventsAfter(DBFilepath, 2, 0)
+	assert.Equal(t, 2, len(events))
+}
+
+func TestEstimateETA(t *testing.T) {
+	assert.Equal(t, time.Duration(0), EstimateETA(time.Minute, 0, 10))
+	assert.Equal(t, time.Duration(0), EstimateETA(time.Minute, 10, 10))
+	assert.Equal(t, 3*time.Minute, EstimateETA(time.Minute, 1, 4))
+}
@@ -94,5 +94,160 @@ func fillTheSheet(sheet *xlsx.Sheet, monitors []Monitor) {
 
 		cell5 := row.AddCell()
 		cell5.Value = monitor.Created
+
+		cell6 := row.AddCell()
+		cell6.Value = monitor.Region
+
+		cell7 := row.AddCell()
+		cell7.Value = monitor.Placement
+
+		cell8 := row.AddCell()
+		cell8.Value = strconv.Itoa(monitor.Pages)
+
+		cell9 := row.AddCell()
+		cell9.Value = strconv.Itoa(monitor.PagesCrawled)
+
+		cell10 := row.AddCell()
+		cell10.Value = bannerCell(monitor)
+
+		cell11 := row.AddCell()
+		cell11.Value = monitor.Hidden
+
+		cell12 := row.AddCell()
+		cell12.Value = monitor.WatchLabel
+		if cell12.Value == "" {
+			cell12.Value = monitor.WatchDomain
+		}
+	}
+}
+
+// bannerCell describes the banner of the link, e.g. "Leaderboard 728x90 http://a.kg/b.png"
+func bannerCell(monitor Monitor) string {
+	if monitor.BannerImage == "" {
+		return ""
+	}
+	if monitor.BannerWidth == 0 || monitor.BannerHeight == 0 {
+		return monitor.BannerImage
+	}
+	return monitor.BannerSize + " " + strconv.Itoa(monitor.BannerWidth) + "x" + strconv.Itoa(monitor.BannerHeight) + " " + monitor.BannerImage
+}
+
+// AppendDiffExcelFromDB adds the sheet with the links added and removed
+// between the days and the changes of the counts by the hosts
+func AppendDiffExcelFromDB(dbFilepath string, excelFilePath string, from string, to string) error {
+	file, err := xlsx.OpenFile(excelFilePath)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	diff, err := GetDiff(dbFilepath, from, to)
+	if err != nil {
+		return err
+	}
+	sheet, err := file.AddSheet("diff " + diff.To)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	fillTheDiffSheet(sheet, diff)
+
+	err = file.Save(excelFilePath)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+func fillTheDiffSheet(sheet *xlsx.Sheet, diff LinkDiff) {
+	addSheetRow(sheet, "Change", "SourceHost", "ExternalHost", "ExternalLink", diff.From, diff.To)
+	for _, m := range diff.Added {
+		addSheetRow(sheet, "added", m.SourceHost, m.ExternalHost, m.ExternalLink, "", strconv.Itoa(m.Count))
+	}
+	for _, m := range diff.Removed {
+		addSheetRow(sheet, "removed", m.SourceHost, m.ExternalHost, m.ExternalLink, strconv.Itoa(m.Count), "")
+	}
+	for _, c := range diff.Changed {
+		addSheetRow(sheet, "count", c.SourceHost, c.ExternalHost, "", strconv.Itoa(c.From), strconv.Itoa(c.To))
+	}
+}
+
+// AppendReportsExcelFromDB adds the sheets with the top external hosts,
+// the share of voice by the host types and the movers since the day before
+func AppendReportsExcelFromDB(dbFilepath string, excelFilePath string, day string) error {
+	file, err := xlsx.OpenFile(excelFilePath)
+	if err != nil {
+		log.Print(err)
+		return err
 	}
-}
\ No newline at end of file
+	ranks, err := GetLeaderboard(dbFilepath, day, RankBySources, 0)
+	if err != nil {
+		return err
+	}
+	shares, err := GetShareOfVoice(dbFilepath, day)
+	if err != nil {
+		return err
+	}
+	movers, err := GetMovers(dbFilepath, "", day, 0)
+	if err != nil {
+		return err
+	}
+
+	for _, report := range []struct {
+		name string
+		fill func(sheet *xlsx.Sheet)
+	}{
+		{"top " + day, func(sheet *xlsx.Sheet) { fillTheLeaderboardSheet(sheet, ranks) }},
+		{"share " + day, func(sheet *xlsx.Sheet) { fillTheShareSheet(sheet, shares) }},
+		{"movers " + day, func(sheet *xlsx.Sheet) { fillTheMoversSheet(sheet, movers) }},
+	} {
+		sheet, err := file.AddSheet(report.name)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+		report.fill(sheet)
+	}
+
+	err = file.Save(excelFilePath)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+func addSheetRow(sheet *xlsx.Sheet, values ...string) {
+	row := sheet.AddRow()
+	for _, value := range values {
+		row.AddCell().Value = value
+	}
+}
+
+func fillTheLeaderboardSheet(sheet *xlsx.Sheet, ranks []HostRank) {
+	addSheetRow(sheet, "ExternalHost", "Type", "Sources", "Links", "Count")
+	for _, rank := range ranks {
+		addSheetRow(sheet, rank.ExternalHost, rank.ExternalHostType, strconv.Itoa(rank.Sources), strconv.Itoa(rank.Links), strconv.Itoa(rank.Count))
+	}
+}
+
+func fillTheShareSheet(sheet *xlsx.Sheet, shares []ShareOfVoice) {
+	addSheetRow(sheet, "SourceType", "ExternalType", "Links", "Count", "Share")
+	for _, share := range shares {
+		addSheetRow(sheet, share.SourceType, share.ExternalType, strconv.Itoa(share.Links), strconv.Itoa(share.Count),
+			strconv.FormatFloat(share.Share*100, 'f', 1, 64)+"%")
+	}
+}
+
+func fillTheMoversSheet(sheet *xlsx.Sheet, movers Movers) {
+	addSheetRow(sheet, "Change", "ExternalHost", movers.From, movers.To, "Delta", "Sources "+movers.From, "Sources "+movers.To)
+	for _, moves := range []struct {
+		change string
+		moves  []HostMove
+	}{{"gain", movers.Gains}, {"loss", movers.Losses}} {
+		for _, m := range moves.moves {
+			addSheetRow(sheet, moves.change, m.ExternalHost, strconv.Itoa(m.FromCount), strconv.Itoa(m.ToCount), strconv.Itoa(m.Delta),
+				strconv.Itoa(m.FromSources), strconv.Itoa(m.ToSources))
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+)
+
+// ExternalHostReport is everything known about the external host:
+// every source host which linked to it, the source hosts linking longer first
+type ExternalHostReport struct {
+	ExternalHost string
+	Sources      []ExternalHostSource
+}
+
+// ExternalHostSource is the source host linking to the external host: when
+// it was found first and last, the links and the occurrences of every run
+// and the lifetimes of the links themselves
+type ExternalHostSource struct {
+	SourceHost string
+	FirstSeen  string
+	LastSeen   string
+	Runs       []RunCount
+	Links      []LinkLifetime
+}
+
+// RunCount is the number of the links and the occurrences saved by the run
+// on the day, the links saved before the runs were recorded have no RunID
+// and are counted by the day
+type RunCount struct {
+	RunID int64
+	Day   string
+	Links int
+	Count int
+}
+
+// GetExternalHostReport finds the source hosts which linked to the host
+// on all the days in the monitor, with the links counted by every run
+func GetExternalHostReport(dbFilepath string, host string) (ExternalHostReport, error) {
+	host = strings.ToLower(host)
+	report := ExternalHostReport{ExternalHost: host, Sources: []ExternalHostSource{}}
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return report, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT source_host, min(created), max(created) FROM monitor WHERE lower(external_host)=? "+
+		"GROUP BY source_host ORDER BY min(created), source_host", host)
+	if err != nil {
+		log.Printf("Error getting external host: %v", err)
+		return report, err
+	}
+	bySource := make(map[string]int)
+	for rows.Next() {
+		source := ExternalHostSource{Runs: []RunCount{}, Links: []LinkLifetime{}}
+		if err := rows.Scan(&source.SourceHost, &source.FirstSeen, &source.LastSeen); err != nil {
+			log.Printf("Error getting external host: %v", err)
+			continue
+		}
+		bySource[source.SourceHost] = len(report.Sources)
+		report.Sources = append(report.Sources, source)
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT m.source_host, m.run_id, min(strftime('%Y-%m-%d', m.created)) as day, count(distinct m.external_link), sum(m.count) "+
+		"FROM monitor as m WHERE lower(m.external_host)=? AND (m.run_id > 0 OR "+latestLegacyOfDay+") "+
+		"GROUP BY m.source_host, m.run_id, CASE WHEN m.run_id > 0 THEN '' ELSE strftime('%Y-%m-%d', m.created) END ORDER BY day, m.run_id", host)
+	if err != nil {
+		log.Printf("Error getting external host: %v", err)
+		return report, err
+	}
+	for rows.Next() {
+		var sourceHost string
+		var run RunCount
+		if err := rows.Scan(&sourceHost, &run.RunID, &run.Day, &run.Links, &run.Count); err != nil {
+			log.Printf("Error getting external host: %v", err)
+			continue
+		}
+		if i, ok := bySource[sourceHost]; ok {
+			report.Sources[i].Runs = append(report.Sources[i].Runs, run)
+		}
+	}
+	rows.Close()
+
+	lifetimes, err := queryLinkLifetimes(dbFilepath, "lower(external_host)=?", []interface{}{host})
+	if err != nil {
+		return report, err
+	}
+	for _, lifetime := range lifetimes {
+		if i, ok := bySource[lifetime.SourceHost]; ok {
+			report.Sources[i].Links = append(report.Sources[i].Links, lifetime)
+		}
+	}
+	return report, nil
+}
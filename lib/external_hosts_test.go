@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetExternalHostReport(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+	db, _ := sql.Open("sqlite3", DBFilepath)
+	for _, row := range []struct {
+		runID                       int64
+		source, link, host, created string
+		count                       int
+	}{
+		// saved before the runs were recorded
+		{0, "a.kg", "http://x.kg/1", "x.kg", "2017-01-01 10:00:00", 2},
+		{0, "a.kg", "http://x.kg/1", "x.kg", "2017-01-01 09:00:00", 6},
+		// the two runs of the day
+		{2, "a.kg", "http://x.kg/1", "x.kg", "2017-01-02 09:00:00", 7},
+		{3, "a.kg", "http://x.kg/1", "x.kg", "2017-01-02 10:00:00", 3},
+		{3, "a.kg", "http://x.kg/2", "x.kg", "2017-01-02 10:00:00", 1},
+		{3, "b.kg", "http://x.kg/1", "x.kg", "2017-01-02 10:00:00", 1},
+		{3, "b.kg", "http://y.kg/", "y.kg", "2017-01-02 10:00:00", 5},
+	} {
+		db.Exec("insert into monitor(run_id, source_host, external_link, count, external_host, created) values(?, ?, ?, ?, ?, ?)",
+			row.runID, row.source, row.link, row.count, row.host, row.created)
+	}
+	db.Close()
+	// the lifetimes are filled from the monitor
+	CreateDBIfNotExists(DBFilepath)
+
+	report, err := GetExternalHostReport(DBFilepath, "X.kg")
+	assert.NoError(t, err)
+	assert.Equal(t, "x.kg", report.ExternalHost)
+	assert.Equal(t, 2, len(report.Sources))
+	a := report.Sources[0]
+	assert.Equal(t, "a.kg", a.SourceHost)
+	assert.Equal(t, "2017-01-01 09:00:00", a.FirstSeen)
+	assert.Equal(t, "2017-01-02 10:00:00", a.LastSeen)
+	assert.Equal(t, []RunCount{
+		{Day: "2017-01-01", Links: 1, Count: 2},
+		{RunID: 2, Day: "2017-01-02", Links: 1, Count: 7},
+		{RunID: 3, Day: "2017-01-02", Links: 2, Count: 4},
+	}, a.Runs)
+	assert.Equal(t, 2, len(a.Links))
+	assert.Equal(t, 2, a.Links[0].Runs)
+	assert.Equal(t, "b.kg", report.Sources[1].SourceHost)
+
+	report, err = GetExternalHostReport(DBFilepath, "nobody.kg")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(report.Sources))
+}
@@ -0,0 +1,309 @@
+package lib
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+)
+
+const (
+	GraphSource   = "source"
+	GraphExternal = "external"
+	GraphBoth     = "both"
+)
+
+// GraphNode is the host of the link graph. Role is source, external or both
+// if the crawled host is linked from the other crawled hosts
+type GraphNode struct {
+	Host     string
+	HostType string
+	Role     string
+}
+
+// GraphEdge is the links from the source host to the external host over the
+// days of the graph: the distinct links, the sum of the occurrences and the
+// days they were saved on. FirstSeen and LastSeen are the first and the last
+// days any of the links was seen, from their lifetimes
+type GraphEdge struct {
+	SourceHost   string
+	ExternalHost string
+	Links        int
+	Count        int
+	Days         int
+	FirstSeen    string
+	LastSeen     string
+}
+
+// LinkGraph is the graph of the source hosts linking to the external hosts
+// on the days from From to To inclusive, or of the links saved by one run
+type LinkGraph struct {
+	From  string
+	To    string
+	Nodes []GraphNode
+	Edges []GraphEdge
+}
+
+// GetLinkGraph makes the graph of the links saved on the days from and to
+// inclusive, by the latest run of every day. The latest day is used if both
+// of them are empty. With runID only the links saved by the run are taken,
+// from and to are the days of the run then
+func GetLinkGraph(dbFilepath string, from string, to string, runID int64) (LinkGraph, error) {
+	if from == "" && to == "" && runID == 0 {
+		days, err := GetAllDaysFromMonitor(dbFilepath)
+		if err != nil {
+			return LinkGraph{}, err
+		}
+		if len(days) > 0 {
+			from, to = days[0], days[0]
+		}
+	}
+	graph := LinkGraph{From: from, To: to, Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return graph, err
+	}
+	defer db.Close()
+
+	where, args := "m.run_id=?", []interface{}{runID}
+	if runID == 0 {
+		where, args = periodCondition("m.created", from, to)
+		where += " AND " + latestRunOfDay
+	}
+	rows, err := db.Query("SELECT m.source_host, m.external_host, coalesce(t1.hosttype,'H'), coalesce(t2.hosttype,'H'), "+
+		"count(distinct m.external_link), sum(m.count), count(distinct strftime('%Y-%m-%d', m.created)), "+
+		"coalesce(strftime('%Y-%m-%d', min(l.first_seen)), min(strftime('%Y-%m-%d', m.created))), "+
+		"coalesce(strftime('%Y-%m-%d', max(l.last_seen)), max(strftime('%Y-%m-%d', m.created))) "+
+		"FROM monitor as m "+
+		"LEFT OUTER JOIN types as t1 ON t1.hostname=m.source_host "+
+		"LEFT OUTER JOIN types as t2 ON t2.hostname=m.external_host "+
+		"LEFT OUTER JOIN link_lifetime as l ON l.source_host=m.source_host AND l.external_link=m.external_link "+
+		"WHERE "+where+" GROUP BY m.source_host, m.external_host ORDER BY m.source_host, m.external_host", args...)
+	if err != nil {
+		log.Printf("Error getting link graph: %v", err)
+		return graph, err
+	}
+	defer rows.Close()
+
+	nodes := make(map[string]*GraphNode)
+	addNode := func(host string, hostType string, role string) {
+		node, ok := nodes[host]
+		if !ok {
+			nodes[host] = &GraphNode{Host: host, HostType: hostType, Role: role}
+		} else if node.Role != role {
+			node.Role = GraphBoth
+		}
+	}
+	for rows.Next() {
+		var edge GraphEdge
+		var sourceType, externalType string
+		if err := rows.Scan(&edge.SourceHost, &edge.ExternalHost, &sourceType, &externalType,
+			&edge.Links, &edge.Count, &edge.Days, &edge.FirstSeen, &edge.LastSeen); err != nil {
+			log.Printf("Error getting link graph: %v", err)
+			continue
+		}
+		addNode(edge.SourceHost, sourceType, GraphSource)
+		addNode(edge.ExternalHost, externalType, GraphExternal)
+		graph.Edges = append(graph.Edges, edge)
+	}
+	for _, node := range nodes {
+		graph.Nodes = append(graph.Nodes, *node)
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		return graph.Nodes[i].Host < graph.Nodes[j].Host
+	})
+	return graph, nil
+}
+
+// GraphFormats writes the graph by the format, the new formats are added here
+var GraphFormats = map[string]func(w io.Writer, graph LinkGraph) error{
+	"graphml": WriteGraphML,
+	"gexf":    WriteGEXF,
+	"dot":     WriteDOT,
+}
+
+// GraphContentTypes are the content types of the formats for the API
+var GraphContentTypes = map[string]string{
+	"graphml": "application/graphml+xml",
+	"gexf":    "application/gexf+xml",
+	"dot":     "text/vnd.graphviz",
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+// WriteGraphML writes the graph for Gephi, yEd and networkx
+func WriteGraphML(w io.Writer, graph LinkGraph) error {
+	doc := graphML{XMLNS: "http://graphml.graphdrawing.org/xmlns", Keys: []graphMLKey{
+		{"type", "node", "type", "string"},
+		{"role", "node", "role", "string"},
+		{"links", "edge", "links", "int"},
+		{"count", "edge", "count", "int"},
+		{"weight", "edge", "weight", "double"},
+		{"days", "edge", "days", "int"},
+		{"first_seen", "edge", "first_seen", "string"},
+		{"last_seen", "edge", "last_seen", "string"},
+	}}
+	doc.Graph.ID = "spiderwoman"
+	doc.Graph.EdgeDefault = "directed"
+	for _, node := range graph.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: node.Host, Data: []graphMLData{
+			{"type", node.HostType},
+			{"role", node.Role},
+		}})
+	}
+	for i, edge := range graph.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{ID: fmt.Sprintf("e%d", i), Source: edge.SourceHost, Target: edge.ExternalHost, Data: []graphMLData{
+			{"links", fmt.Sprint(edge.Links)},
+			{"count", fmt.Sprint(edge.Count)},
+			{"weight", fmt.Sprint(edge.Count)},
+			{"days", fmt.Sprint(edge.Days)},
+			{"first_seen", edge.FirstSeen},
+			{"last_seen", edge.LastSeen},
+		}})
+	}
+	return writeXML(w, doc)
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type gexfNode struct {
+	ID     string      `xml:"id,attr"`
+	Label  string      `xml:"label,attr"`
+	Values []gexfValue `xml:"attvalues>attvalue"`
+}
+
+type gexfEdge struct {
+	ID     string      `xml:"id,attr"`
+	Source string      `xml:"source,attr"`
+	Target string      `xml:"target,attr"`
+	Weight int         `xml:"weight,attr"`
+	Values []gexfValue `xml:"attvalues>attvalue"`
+}
+
+type gexf struct {
+	XMLName xml.Name `xml:"gexf"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"meta>creator"`
+	Graph   struct {
+		Mode            string           `xml:"mode,attr"`
+		DefaultEdgeType string           `xml:"defaultedgetype,attr"`
+		Attributes      []gexfAttributes `xml:"attributes"`
+		Nodes           []gexfNode       `xml:"nodes>node"`
+		Edges           []gexfEdge       `xml:"edges>edge"`
+	} `xml:"graph"`
+}
+
+// WriteGEXF writes the graph in the native format of Gephi, the count
+// of the edge is its weight
+func WriteGEXF(w io.Writer, graph LinkGraph) error {
+	doc := gexf{XMLNS: "http://www.gexf.net/1.2draft", Version: "1.2", Creator: "spiderwoman"}
+	doc.Graph.Mode = "static"
+	doc.Graph.DefaultEdgeType = "directed"
+	doc.Graph.Attributes = []gexfAttributes{
+		{"node", []gexfAttribute{{"type", "type", "string"}, {"role", "role", "string"}}},
+		{"edge", []gexfAttribute{{"links", "links", "integer"}, {"count", "count", "integer"}, {"days", "days", "integer"},
+			{"first_seen", "first_seen", "string"}, {"last_seen", "last_seen", "string"}}},
+	}
+	for _, node := range graph.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gexfNode{ID: node.Host, Label: node.Host, Values: []gexfValue{
+			{"type", node.HostType},
+			{"role", node.Role},
+		}})
+	}
+	for i, edge := range graph.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{ID: fmt.Sprint(i), Source: edge.SourceHost, Target: edge.ExternalHost, Weight: edge.Count, Values: []gexfValue{
+			{"links", fmt.Sprint(edge.Links)},
+			{"count", fmt.Sprint(edge.Count)},
+			{"days", fmt.Sprint(edge.Days)},
+			{"first_seen", edge.FirstSeen},
+			{"last_seen", edge.LastSeen},
+		}})
+	}
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteDOT writes the graph for Graphviz
+func WriteDOT(w io.Writer, graph LinkGraph) error {
+	lines := []string{"digraph spiderwoman {"}
+	for _, node := range graph.Nodes {
+		lines = append(lines, fmt.Sprintf("  %s [type=%s, role=%s];", dotQuote(node.Host), dotQuote(node.HostType), dotQuote(node.Role)))
+	}
+	for _, edge := range graph.Edges {
+		lines = append(lines, fmt.Sprintf("  %s -> %s [weight=%d, links=%d, count=%d, days=%d, first_seen=%s, last_seen=%s];",
+			dotQuote(edge.SourceHost), dotQuote(edge.ExternalHost), edge.Count, edge.Links, edge.Count, edge.Days,
+			dotQuote(edge.FirstSeen), dotQuote(edge.LastSeen)))
+	}
+	lines = append(lines, "}")
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.Replace(strings.Replace(s, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+}
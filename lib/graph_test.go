@@ -0,0 +1,126 @@
+package lib
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/xml"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createGraphDB() {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+	SaveHostType(DBFilepath, "a.kg", "B")
+	SaveHostType(DBFilepath, "x.kg", "M")
+	db, _ := sql.Open("sqlite3", DBFilepath)
+	for _, row := range []struct {
+		source, link, host, created string
+		count                       int
+	}{
+		{"a.kg", "http://x.kg/1", "x.kg", "2017-01-01 10:00:00", 2},
+		{"a.kg", "http://x.kg/1", "x.kg", "2017-01-02 10:00:00", 3},
+		{"a.kg", "http://x.kg/2", "x.kg", "2017-01-02 10:00:00", 1},
+		{"a.kg", "http://b.kg/", "b.kg", "2017-01-02 10:00:00", 1},
+		{"b.kg", "http://y.kg/", "y.kg", "2017-01-03 10:00:00", 4},
+		// the earlier run of the day
+		{"a.kg", "http://x.kg/1", "x.kg", "2017-01-02 09:00:00", 7},
+	} {
+		db.Exec("insert into monitor(source_host, external_link, count, external_host, created) values(?, ?, ?, ?, ?)",
+			row.source, row.link, row.count, row.host, row.created)
+	}
+	db.Exec("insert into monitor(source_host, external_link, count, external_host, created, run_id) values(?, ?, ?, ?, ?, ?)",
+		"b.kg", "http://y.kg/", 2, "y.kg", "2017-01-03 11:00:00", 5)
+	db.Close()
+	// the lifetimes are filled from the monitor
+	CreateDBIfNotExists(DBFilepath)
+}
+
+func TestGetLinkGraph(t *testing.T) {
+	createGraphDB()
+
+	graph, err := GetLinkGraph(DBFilepath, "2017-01-01", "2017-01-02", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []GraphNode{
+		{Host: "a.kg", HostType: "B", Role: GraphSource},
+		{Host: "b.kg", HostType: "H", Role: GraphExternal},
+		{Host: "x.kg", HostType: "M", Role: GraphExternal},
+	}, graph.Nodes)
+	assert.Equal(t, []GraphEdge{
+		{SourceHost: "a.kg", ExternalHost: "b.kg", Links: 1, Count: 1, Days: 1, FirstSeen: "2017-01-02", LastSeen: "2017-01-02"},
+		{SourceHost: "a.kg", ExternalHost: "x.kg", Links: 2, Count: 6, Days: 2, FirstSeen: "2017-01-01", LastSeen: "2017-01-02"},
+	}, graph.Edges)
+
+	graph, err = GetLinkGraph(DBFilepath, "2017-01-02", "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(graph.Edges))
+	assert.Equal(t, GraphNode{Host: "b.kg", HostType: "H", Role: GraphBoth}, graph.Nodes[1])
+	// the links to x.kg were first seen before the days of the graph
+	assert.Equal(t, GraphEdge{SourceHost: "a.kg", ExternalHost: "x.kg", Links: 2, Count: 4, Days: 1, FirstSeen: "2017-01-01", LastSeen: "2017-01-02"}, graph.Edges[1])
+
+	// the latest run of the day
+	graph, err = GetLinkGraph(DBFilepath, "", "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "2017-01-03", graph.From)
+	assert.Equal(t, []GraphEdge{
+		{SourceHost: "b.kg", ExternalHost: "y.kg", Links: 1, Count: 2, Days: 1, FirstSeen: "2017-01-03", LastSeen: "2017-01-03"},
+	}, graph.Edges)
+
+	graph, err = GetLinkGraph(DBFilepath, "2017-01-03", "2017-01-03", 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(graph.Edges))
+	assert.Equal(t, 2, graph.Edges[0].Count)
+	graph, _ = GetLinkGraph(DBFilepath, "2017-01-03", "2017-01-03", 6)
+	assert.Equal(t, 0, len(graph.Edges))
+}
+
+var testGraph = LinkGraph{
+	Nodes: []GraphNode{{Host: "a.kg", HostType: "B", Role: GraphSource}, {Host: `x"y.kg`, HostType: "H", Role: GraphExternal}},
+	Edges: []GraphEdge{{SourceHost: "a.kg", ExternalHost: `x"y.kg`, Links: 2, Count: 6, Days: 2, FirstSeen: "2017-01-01", LastSeen: "2017-01-02"}},
+}
+
+func TestWriteGraphML(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WriteGraphML(&buf, testGraph))
+	var doc graphML
+	assert.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, "directed", doc.Graph.EdgeDefault)
+	assert.Equal(t, 2, len(doc.Graph.Nodes))
+	assert.Equal(t, []graphMLData{{"type", "B"}, {"role", "source"}}, doc.Graph.Nodes[0].Data)
+	assert.Equal(t, `x"y.kg`, doc.Graph.Edges[0].Target)
+	assert.Contains(t, doc.Graph.Edges[0].Data, graphMLData{"count", "6"})
+	assert.Contains(t, doc.Graph.Edges[0].Data, graphMLData{"first_seen", "2017-01-01"})
+}
+
+func TestWriteGEXF(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WriteGEXF(&buf, testGraph))
+	var doc gexf
+	assert.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, "1.2", doc.Version)
+	assert.Equal(t, "a.kg", doc.Graph.Nodes[0].Label)
+	assert.Equal(t, []gexfValue{{"type", "B"}, {"role", "source"}}, doc.Graph.Nodes[0].Values)
+	assert.Equal(t, 6, doc.Graph.Edges[0].Weight)
+	assert.Contains(t, doc.Graph.Edges[0].Values, gexfValue{"last_seen", "2017-01-02"})
+}
+
+func TestWriteDOT(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WriteDOT(&buf, testGraph))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, []string{
+		"digraph spiderwoman {",
+		`  "a.kg" [type="B", role="source"];`,
+		`  "x\"y.kg" [type="H", role="external"];`,
+		`  "a.kg" -> "x\"y.kg" [weight=6, links=2, count=6, days=2, first_seen="2017-01-01", last_seen="2017-01-02"];`,
+		"}",
+	}, lines)
+}
+
+func TestRunDay(t *testing.T) {
+	assert.Equal(t, "2017-01-02", RunDay(Run{Started: "2017-01-01T23:50:00Z", Finished: "2017-01-02 00:30:00"}))
+	assert.Equal(t, "2017-01-01", RunDay(Run{Started: "2017-01-01T23:50:00Z"}))
+}
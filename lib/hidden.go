@@ -0,0 +1,259 @@
+package lib
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+)
+
+const (
+	HiddenDisplayNone = "display:none"
+	HiddenVisibility  = "visibility:hidden"
+	HiddenOpacity     = "opacity:0"
+	HiddenFontSize    = "zero font size"
+	HiddenOffScreen   = "off-screen"
+	HiddenSameColour  = "same colour"
+	HiddenClass       = "hidden class"
+)
+
+// the classes of the css frameworks which hide the element
+var hiddenClasses = []string{"hidden", "hide", "d-none", "invisible"}
+
+var (
+	styleBlockPattern = regexp.MustCompile(`(?s)([^{}]+)\{([^{}]*)\}`)
+	cssCommentPattern = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssLengthPattern  = regexp.MustCompile(`^(-?\d*\.?\d+)(px|em|rem|pt|%)?$`)
+	offScreenLimit    = -500.0
+)
+
+type styleRule struct {
+	selector     cascadia.Selector
+	declarations map[string]string
+}
+
+// PageStyles has the rules of the <style> blocks of the page which may hide
+// the links. Only the simple rules are used: the @media blocks, the rules
+// with pseudo-classes and the external stylesheets are skipped
+type PageStyles struct {
+	rules []styleRule
+}
+
+// ParsePageStyles reads the <style> blocks of the page
+func ParsePageStyles(doc *goquery.Document) *PageStyles {
+	styles := &PageStyles{}
+	doc.Find("style").Each(func(i int, s *goquery.Selection) {
+		css := cssCommentPattern.ReplaceAllString(s.Text(), "")
+		for _, block := range styleBlockPattern.FindAllStringSubmatch(css, -1) {
+			declarations := parseDeclarations(block[2])
+			if !mayHide(declarations) {
+				continue
+			}
+			for _, selector := range strings.Split(block[1], ",") {
+				selector = strings.TrimSpace(selector)
+				if selector == "" || strings.ContainsAny(selector, ":@") {
+					continue
+				}
+				compiled, err := cascadia.Compile(selector)
+				if err != nil {
+					continue
+				}
+				styles.rules = append(styles.rules, styleRule{selector: compiled, declarations: declarations})
+			}
+		}
+	})
+	return styles
+}
+
+func parseDeclarations(style string) map[string]string {
+	declarations := make(map[string]string)
+	for _, declaration := range strings.Split(style, ";") {
+		parts := strings.SplitN(declaration, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.ToLower(strings.TrimSpace(strings.Replace(parts[1], "!important", "", -1)))
+		if name != "" {
+			declarations[name] = value
+		}
+	}
+	return declarations
+}
+
+// the rules which do not change any of these do not hide anything
+func mayHide(declarations map[string]string) bool {
+	for _, name := range []string{"display", "visibility", "opacity", "font-size", "left", "top", "right",
+		"text-indent", "color", "background", "background-color"} {
+		if _, ok := declarations[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// declarations are the ones of the rules of the page matching the node,
+// overridden by the inline style
+func (p *PageStyles) declarations(node *goquery.Selection) map[string]string {
+	declarations := make(map[string]string)
+	if p != nil && len(node.Nodes) > 0 {
+		for _, rule := range p.rules {
+			if rule.selector.Match(node.Nodes[0]) {
+				for name, value := range rule.declarations {
+					declarations[name] = value
+				}
+			}
+		}
+	}
+	for name, value := range parseDeclarations(node.AttrOr("style", "")) {
+		declarations[name] = value
+	}
+	return declarations
+}
+
+// HiddenReason tells why the anchor is not visible on the page,
+// "" if it is visible
+func (p *PageStyles) HiddenReason(s *goquery.Selection) string {
+	color := ""
+	for node := s; node.Length() > 0 && goquery.NodeName(node) != "#document"; node = node.Parent() {
+		declarations := p.declarations(node)
+		if reason := hiddenByDeclarations(declarations); reason != "" {
+			return reason
+		}
+		for _, class := range strings.Fields(strings.ToLower(node.AttrOr("class", ""))) {
+			for _, hidden := range hiddenClasses {
+				if class == hidden {
+					return HiddenClass
+				}
+			}
+		}
+		// the text has the colour of the nearest element which sets it
+		// and is on the background of the nearest element which sets it
+		if color == "" {
+			color = normalizeColour(declarations["color"])
+		}
+		background := declarations["background-color"]
+		if background == "" {
+			background = declarations["background"]
+		}
+		if background = normalizeColour(background); background != "" {
+			if color != "" && color == background {
+				return HiddenSameColour
+			}
+			break
+		}
+	}
+	return ""
+}
+
+func hiddenByDeclarations(declarations map[string]string) string {
+	if declarations["display"] == "none" {
+		return HiddenDisplayNone
+	}
+	if declarations["visibility"] == "hidden" || declarations["visibility"] == "collapse" {
+		return HiddenVisibility
+	}
+	if opacity, ok := cssNumber(declarations["opacity"]); ok && opacity == 0 {
+		return HiddenOpacity
+	}
+	if size, ok := cssNumber(declarations["font-size"]); ok && size == 0 {
+		return HiddenFontSize
+	}
+	if indent, ok := cssNumber(declarations["text-indent"]); ok && indent <= offScreenLimit {
+		return HiddenOffScreen
+	}
+	if position := declarations["position"]; position == "absolute" || position == "fixed" {
+		for _, side := range []string{"left", "top", "right"} {
+			if offset, ok := cssNumber(declarations[side]); ok && offset <= offScreenLimit {
+				return HiddenOffScreen
+			}
+		}
+	}
+	return ""
+}
+
+func cssNumber(value string) (float64, bool) {
+	match := cssLengthPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return 0, false
+	}
+	number, err := strconv.ParseFloat(match[1], 64)
+	return number, err == nil
+}
+
+var namedColours = map[string]string{
+	"white": "#ffffff", "black": "#000000", "red": "#ff0000", "green": "#008000",
+	"blue": "#0000ff", "yellow": "#ffff00", "gray": "#808080", "grey": "#808080", "silver": "#c0c0c0",
+}
+
+// normalizeColour makes the colours comparable: #fff, white and rgb(255,255,255)
+// are all "#ffffff". The background shorthand gives its colour
+func normalizeColour(value string) string {
+	for _, word := range strings.Fields(strings.Replace(value, ", ", ",", -1)) {
+		if hex, ok := namedColours[word]; ok {
+			return hex
+		}
+		if strings.HasPrefix(word, "#") {
+			if len(word) == 4 {
+				return "#" + strings.Repeat(word[1:2], 2) + strings.Repeat(word[2:3], 2) + strings.Repeat(word[3:4], 2)
+			}
+			return word
+		}
+		if strings.HasPrefix(word, "rgb(") && strings.HasSuffix(word, ")") {
+			hex := "#"
+			for _, part := range strings.Split(word[4:len(word)-1], ",") {
+				component, err := strconv.Atoi(strings.TrimSpace(part))
+				if err != nil {
+					return ""
+				}
+				hex += strconv.FormatInt(int64(256+component), 16)[1:]
+			}
+			return hex
+		}
+	}
+	return ""
+}
+
+// HiddenLinks is the hidden links found on the source host by the reason
+type HiddenLinks struct {
+	SourceHost string
+	Hidden     int
+	Reasons    map[string]int
+	Links      []Monitor
+}
+
+// GetHiddenLinks returns the hidden links saved on the day by the source host,
+// the hosts with more hidden links first
+func GetHiddenLinks(dbFilepath string, day string) ([]HiddenLinks, error) {
+	monitors, err := GetAllDataFromMonitorByDay(dbFilepath, day)
+	if err != nil {
+		return nil, err
+	}
+	bySource := make(map[string]*HiddenLinks)
+	var report []*HiddenLinks
+	for _, m := range monitors {
+		if m.Hidden == "" {
+			continue
+		}
+		source, ok := bySource[m.SourceHost]
+		if !ok {
+			source = &HiddenLinks{SourceHost: m.SourceHost, Reasons: make(map[string]int)}
+			bySource[m.SourceHost] = source
+			report = append(report, source)
+		}
+		source.Hidden++
+		source.Reasons[m.Hidden]++
+		source.Links = append(source.Links, m)
+	}
+	sort.SliceStable(report, func(i, j int) bool {
+		return report[i].Hidden > report[j].Hidden
+	})
+	result := []HiddenLinks{}
+	for _, source := range report {
+		result = append(result, *source)
+	}
+	return result, nil
+}
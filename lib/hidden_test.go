@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHiddenReason(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><head><style>
+/* paid links */
+.seo, #partners > p { display: none !important }
+.offer { position: absolute; left: -9999px }
+.ghost a:hover { color: red }
+</style></head><body style="background: #FFF">
+<a id="visible" href="http://a.kg/">a</a>
+<div style="display:none"><a id="inline" href="http://b.kg/">b</a></div>
+<a id="visibility" style="visibility: hidden" href="http://c.kg/">c</a>
+<a id="font" style="font-size:0px" href="http://d.kg/">d</a>
+<a id="indent" style="text-indent: -10000px" href="http://e.kg/">e</a>
+<a id="colour" style="color: white" href="http://f.kg/">f</a>
+<div style="background-color: rgb(0, 0, 0)"><a id="dark" style="color: white" href="http://g.kg/">g</a></div>
+<span class="seo"><a id="rule" href="http://h.kg/">h</a></span>
+<div id="partners"><p><a id="child" href="http://i.kg/">i</a></p></div>
+<a id="position" class="offer" href="http://j.kg/">j</a>
+<a id="class" class="link d-none" href="http://k.kg/">k</a>
+<div class="ghost"><a id="hover" href="http://l.kg/">l</a></div>
+</body></html>`))
+	assert.NoError(t, err)
+	styles := ParsePageStyles(doc)
+	reason := func(id string) string {
+		return styles.HiddenReason(doc.Find("#" + id))
+	}
+
+	assert.Equal(t, "", reason("visible"))
+	assert.Equal(t, HiddenDisplayNone, reason("inline"))
+	assert.Equal(t, HiddenVisibility, reason("visibility"))
+	assert.Equal(t, HiddenFontSize, reason("font"))
+	assert.Equal(t, HiddenOffScreen, reason("indent"))
+	assert.Equal(t, HiddenSameColour, reason("colour"))
+	assert.Equal(t, "", reason("dark"))
+	assert.Equal(t, HiddenDisplayNone, reason("rule"))
+	assert.Equal(t, HiddenDisplayNone, reason("child"))
+	assert.Equal(t, HiddenOffScreen, reason("position"))
+	assert.Equal(t, HiddenClass, reason("class"))
+	assert.Equal(t, "", reason("hover"))
+}
+
+func TestNormalizeColour(t *testing.T) {
+	assert.Equal(t, "#ffffff", normalizeColour("#fff"))
+	assert.Equal(t, "#ffffff", normalizeColour("white"))
+	assert.Equal(t, "#ffffff", normalizeColour("rgb(255, 255, 255)"))
+	assert.Equal(t, "#336699", normalizeColour("#336699 url('/bg.png') no-repeat"))
+	assert.Equal(t, "", normalizeColour("inherit"))
+}
+
+func TestGetHiddenLinks(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	SaveRecordToMonitorWithInfo(DBFilepath, "a.kg", "http://x.kg/", 1, "x.kg", LinkInfo{Hidden: HiddenDisplayNone})
+	SaveRecordToMonitorWithInfo(DBFilepath, "b.kg", "http://x.kg/", 1, "x.kg", LinkInfo{Hidden: HiddenDisplayNone})
+	SaveRecordToMonitorWithInfo(DBFilepath, "b.kg", "http://y.kg/", 1, "y.kg", LinkInfo{Hidden: HiddenSameColour})
+	SaveRecordToMonitor(DBFilepath, "b.kg", "http://z.kg/", 1, "z.kg")
+
+	days, _ := GetAllDaysFromMonitor(DBFilepath)
+	hidden, err := GetHiddenLinks(DBFilepath, days[0])
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(hidden))
+	assert.Equal(t, "b.kg", hidden[0].SourceHost)
+	assert.Equal(t, 2, hidden[0].Hidden)
+	assert.Equal(t, map[string]int{HiddenDisplayNone: 1, HiddenSameColour: 1}, hidden[0].Reasons)
+	assert.Equal(t, HiddenSameColour, hidden[0].Links[1].Hidden)
+}
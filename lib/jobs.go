@@ -0,0 +1,280 @@
+package lib
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	JobCrawl   = "crawl"
+	JobResolve = "resolve"
+
+	JobPending  = "pending"
+	JobLeased   = "leased"
+	JobDone     = "done"
+	JobFailed   = "failed"
+	JobCanceled = "canceled"
+)
+
+// the coordinator serves many workers at once, sqlite does not like it
+var jobsMutex sync.Mutex
+
+// Job is a piece of the run done by a worker: the crawl of one host or
+// the resolution of a batch of links. The worker holds the lease on the job
+// until LeaseUntil and extends it with heartbeats. The job which lease has
+// expired is given to the other worker, until MaxAttempts are made
+type Job struct {
+	ID         int64
+	RunID      int64
+	Kind       string
+	Host       string
+	Payload    json.RawMessage
+	State      string
+	Worker     string
+	LeaseUntil time.Time
+	Attempts   int
+	Result     json.RawMessage
+	Error      string
+}
+
+// CrawlJob is the payload of the crawl job, the known pages of the host
+// let the worker skip the pages which have not changed
+type CrawlJob struct {
+	Seeds      []string
+	MaxVisits  int
+	KnownPages []Page
+}
+
+// CrawlResult has the links by the source host, as the pages of the host
+// may be found on the other host, e.g. with www. prefix
+type CrawlResult struct {
+	Links       map[string]map[string]int
+	LinksInfo   map[string]map[string]LinkInfo
+	HostPages   map[string]int
+	Embeds      map[string]map[string]int
+	Pages       []Page
+	Skipped     map[string]int
+	Visited     int
+	NotModified int
+	SameContent int
+}
+
+// ResolveJob is the payload of the resolve job, the links of one host
+// with their info
+type ResolveJob struct {
+	Links     map[string]int
+	LinksInfo map[string]LinkInfo
+}
+
+type ResolveResult struct {
+	Resolved  map[string]int
+	LinksInfo map[string]LinkInfo
+	Filtered  []FilteredLink
+	Failed    int
+}
+
+func openJobsDB(dbFilepath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+	}
+	return db, err
+}
+
+func EnqueueJob(dbFilepath string, runID int64, kind string, host string, payload interface{}) (int64, error) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	db, err := openJobsDB(dbFilepath)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	res, err := db.Exec("insert into jobs(run_id, kind, host, payload, state, worker, lease_until, attempts, result, error) "+
+		"values(?, ?, ?, ?, ?, '', 0, 0, '', '')", runID, kind, host, string(data), JobPending)
+	if err != nil {
+		log.Printf("Error saving job: %v", err)
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// ExpireJobs gives the jobs which lease has expired to the workers again,
+// or fails them if all the attempts are made
+func ExpireJobs(dbFilepath string, maxAttempts int) error {
+	_, err := updateJobs(dbFilepath, expireJobsQuery, maxAttempts, JobFailed, JobPending, JobLeased, time.Now().UnixNano())
+	return err
+}
+
+const expireJobsQuery = "UPDATE jobs SET state=CASE WHEN attempts>=? THEN ? ELSE ? END, error='lease expired' WHERE state=? AND lease_until<?"
+
+// LeaseJob gives the oldest pending job to the worker, the jobs which lease
+// has expired are expired first. False is returned if there is nothing to do
+func LeaseJob(dbFilepath string, worker string, lease time.Duration, maxAttempts int) (Job, bool, error) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	db, err := openJobsDB(dbFilepath)
+	if err != nil {
+		return Job{}, false, err
+	}
+	defer db.Close()
+
+	now := time.Now()
+	_, err = db.Exec(expireJobsQuery, maxAttempts, JobFailed, JobPending, JobLeased, now.UnixNano())
+	if err != nil {
+		log.Printf("Error expiring jobs: %v", err)
+		return Job{}, false, err
+	}
+
+	rows, err := db.Query("SELECT "+jobColumns+" FROM jobs WHERE state=? ORDER BY id LIMIT 1", JobPending)
+	if err != nil {
+		log.Printf("Error leasing job: %v", err)
+		return Job{}, false, err
+	}
+	if !rows.Next() {
+		rows.Close()
+		return Job{}, false, nil
+	}
+	job, err := scanJob(rows)
+	rows.Close()
+	if err != nil {
+		log.Printf("Error leasing job: %v", err)
+		return Job{}, false, err
+	}
+
+	job.State = JobLeased
+	job.Worker = worker
+	job.LeaseUntil = now.Add(lease)
+	job.Attempts++
+	_, err = db.Exec("UPDATE jobs SET state=?, worker=?, lease_until=?, attempts=? WHERE id=?",
+		job.State, job.Worker, job.LeaseUntil.UnixNano(), job.Attempts, job.ID)
+	if err != nil {
+		log.Printf("Error leasing job: %v", err)
+		return Job{}, false, err
+	}
+	return job, true, nil
+}
+
+// HeartbeatJob extends the lease of the worker on the job. False is returned
+// if the worker has lost the job, e.g. the lease has expired or the run is canceled
+func HeartbeatJob(dbFilepath string, id int64, worker string, lease time.Duration) (bool, error) {
+	return updateJobs(dbFilepath, "UPDATE jobs SET lease_until=? WHERE id=? AND worker=? AND state=?",
+		time.Now().Add(lease).UnixNano(), id, worker, JobLeased)
+}
+
+// CompleteJob saves the result of the job uploaded by the worker
+func CompleteJob(dbFilepath string, id int64, worker string, result interface{}) (bool, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return false, err
+	}
+	return updateJobs(dbFilepath, "UPDATE jobs SET state=?, result=?, error='' WHERE id=? AND worker=? AND state=?",
+		JobDone, string(data), id, worker, JobLeased)
+}
+
+// ReleaseJob returns the job of the worker which is shutting down to the queue,
+// the attempt is not counted
+func ReleaseJob(dbFilepath string, id int64, worker string, reason string) (bool, error) {
+	return updateJobs(dbFilepath, "UPDATE jobs SET state=?, worker='', lease_until=0, attempts=max(attempts-1, 0), error=? "+
+		"WHERE id=? AND worker=? AND state=?", JobPending, reason, id, worker, JobLeased)
+}
+
+// FailJob returns the job to the queue to be retried by any worker,
+// the job fails for good when all the attempts are made
+func FailJob(dbFilepath string, id int64, worker string, reason string, maxAttempts int) (bool, error) {
+	return updateJobs(dbFilepath, "UPDATE jobs SET state=CASE WHEN attempts>=? THEN ? ELSE ? END, error=? "+
+		"WHERE id=? AND worker=? AND state=?", maxAttempts, JobFailed, JobPending, reason, id, worker, JobLeased)
+}
+
+// CancelJobs cancels the jobs of the run which are not finished yet
+func CancelJobs(dbFilepath string, runID int64) error {
+	_, err := updateJobs(dbFilepath, "UPDATE jobs SET state=? WHERE run_id=? AND state IN (?, ?)",
+		JobCanceled, runID, JobPending, JobLeased)
+	return err
+}
+
+// RequeueCanceledJobs gives the jobs canceled by the interruption of the run
+// to the workers again when the run is resumed
+func RequeueCanceledJobs(dbFilepath string, runID int64) error {
+	_, err := updateJobs(dbFilepath, "UPDATE jobs SET state=?, worker='', lease_until=0 WHERE run_id=? AND state=?",
+		JobPending, runID, JobCanceled)
+	return err
+}
+
+func updateJobs(dbFilepath string, query string, args ...interface{}) (bool, error) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	db, err := openJobsDB(dbFilepath)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		log.Printf("Error updating job: %v", err)
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// GetJobs returns the jobs of the given kind of the run, oldest first
+func GetJobs(dbFilepath string, runID int64, kind string) ([]Job, error) {
+	db, err := openJobsDB(dbFilepath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT "+jobColumns+" FROM jobs WHERE run_id=? AND kind=? ORDER BY id", runID, kind)
+	if err != nil {
+		log.Printf("Error getting jobs: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			log.Printf("Error getting jobs: %v", err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// JobFinished is true for the jobs which will not be given to the workers anymore
+func JobFinished(job Job) bool {
+	return job.State == JobDone || job.State == JobFailed || job.State == JobCanceled
+}
+
+const jobColumns = "id, run_id, kind, host, payload, state, worker, lease_until, attempts, result, error"
+
+func scanJob(rows *sql.Rows) (Job, error) {
+	var job Job
+	var payload, result string
+	var leaseUntil int64
+	err := rows.Scan(&job.ID, &job.RunID, &job.Kind, &job.Host, &payload, &job.State, &job.Worker,
+		&leaseUntil, &job.Attempts, &result, &job.Error)
+	job.Payload = json.RawMessage(payload)
+	if result != "" {
+		job.Result = json.RawMessage(result)
+	}
+	if leaseUntil > 0 {
+		job.LeaseUntil = time.Unix(0, leaseUntil)
+	}
+	return job, err
+}
@@ -0,0 +1,254 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	RankBySources = "sources"
+	RankByCount   = "count"
+)
+
+// HostRank is the external host with the number of the distinct source hosts
+// linking to it, the number of the links and the occurrences
+type HostRank struct {
+	ExternalHost     string
+	ExternalHostType string
+	Sources          int
+	Links            int
+	Count            int
+}
+
+// ShareOfVoice is the share of the occurrences of the links to the external
+// hosts of the type among all the links of the source hosts of the type
+type ShareOfVoice struct {
+	SourceType   string
+	ExternalType string
+	Links        int
+	Count        int
+	Share        float64
+}
+
+// HostMove is the change of the occurrences and the sources of the external
+// host between two days
+type HostMove struct {
+	ExternalHost string
+	FromCount    int
+	ToCount      int
+	Delta        int
+	FromSources  int
+	ToSources    int
+}
+
+// Movers are the external hosts which gained and lost the most occurrences
+// between the days From and To
+type Movers struct {
+	From   string
+	To     string
+	Gains  []HostMove
+	Losses []HostMove
+}
+
+// RankExternalHosts ranks the external hosts by the sources or by the count,
+// the other one breaks the ties. All the hosts are returned if limit is 0
+func RankExternalHosts(monitors []Monitor, by string, limit int) ([]HostRank, error) {
+	if by == "" {
+		by = RankBySources
+	}
+	if by != RankBySources && by != RankByCount {
+		return nil, fmt.Errorf("unknown rank %q, use sources or count", by)
+	}
+	byHost := make(map[string]*HostRank)
+	sources := make(map[[2]string]bool)
+	for _, m := range latestLinks(monitors) {
+		rank, ok := byHost[m.ExternalHost]
+		if !ok {
+			rank = &HostRank{ExternalHost: m.ExternalHost, ExternalHostType: m.ExternalHostType}
+			byHost[m.ExternalHost] = rank
+		}
+		if !sources[[2]string{m.ExternalHost, m.SourceHost}] {
+			sources[[2]string{m.ExternalHost, m.SourceHost}] = true
+			rank.Sources++
+		}
+		rank.Links++
+		rank.Count += m.Count
+	}
+	ranks := []HostRank{}
+	for _, rank := range byHost {
+		ranks = append(ranks, *rank)
+	}
+	keys := func(rank HostRank) (int, int) {
+		if by == RankByCount {
+			return rank.Count, rank.Sources
+		}
+		return rank.Sources, rank.Count
+	}
+	sort.Slice(ranks, func(i, j int) bool {
+		a1, a2 := keys(ranks[i])
+		b1, b2 := keys(ranks[j])
+		if a1 != b1 {
+			return a1 > b1
+		}
+		if a2 != b2 {
+			return a2 > b2
+		}
+		return ranks[i].ExternalHost < ranks[j].ExternalHost
+	})
+	if limit > 0 && len(ranks) > limit {
+		ranks = ranks[:limit]
+	}
+	return ranks, nil
+}
+
+// ShareOfVoiceByType splits the occurrences of every source type by the
+// external host types, the biggest shares first
+func ShareOfVoiceByType(monitors []Monitor) []ShareOfVoice {
+	byTypes := make(map[[2]string]*ShareOfVoice)
+	totals := make(map[string]int)
+	for _, m := range latestLinks(monitors) {
+		key := [2]string{m.SourceHostType, m.ExternalHostType}
+		share, ok := byTypes[key]
+		if !ok {
+			share = &ShareOfVoice{SourceType: m.SourceHostType, ExternalType: m.ExternalHostType}
+			byTypes[key] = share
+		}
+		share.Links++
+		share.Count += m.Count
+		totals[m.SourceHostType] += m.Count
+	}
+	shares := []ShareOfVoice{}
+	for _, share := range byTypes {
+		if total := totals[share.SourceType]; total > 0 {
+			share.Share = float64(share.Count) / float64(total)
+		}
+		shares = append(shares, *share)
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		if shares[i].SourceType != shares[j].SourceType {
+			return shares[i].SourceType < shares[j].SourceType
+		}
+		if shares[i].Count != shares[j].Count {
+			return shares[i].Count > shares[j].Count
+		}
+		return shares[i].ExternalType < shares[j].ExternalType
+	})
+	return shares
+}
+
+// HostMovers compares the occurrences of the external hosts on two days, only
+// the source hosts crawled on both days are taken, as the ones crawled on one
+// day would move the hosts they link to. The limit is for the gains and the
+// losses each, all if it is 0
+func HostMovers(from []Monitor, to []Monitor, limit int) ([]HostMove, []HostMove) {
+	from, to = crawledOnBoth(from, to)
+	moves := make(map[string]*HostMove)
+	move := func(host string) *HostMove {
+		if moves[host] == nil {
+			moves[host] = &HostMove{ExternalHost: host}
+		}
+		return moves[host]
+	}
+	fromRanks, _ := RankExternalHosts(from, RankByCount, 0)
+	for _, rank := range fromRanks {
+		move(rank.ExternalHost).FromCount = rank.Count
+		move(rank.ExternalHost).FromSources = rank.Sources
+	}
+	toRanks, _ := RankExternalHosts(to, RankByCount, 0)
+	for _, rank := range toRanks {
+		move(rank.ExternalHost).ToCount = rank.Count
+		move(rank.ExternalHost).ToSources = rank.Sources
+	}
+	gains, losses := []HostMove{}, []HostMove{}
+	for _, m := range moves {
+		m.Delta = m.ToCount - m.FromCount
+		if m.Delta > 0 {
+			gains = append(gains, *m)
+		} else if m.Delta < 0 {
+			losses = append(losses, *m)
+		}
+	}
+	sortMoves(gains)
+	sortMoves(losses)
+	if limit > 0 && len(gains) > limit {
+		gains = gains[:limit]
+	}
+	if limit > 0 && len(losses) > limit {
+		losses = losses[:limit]
+	}
+	return gains, losses
+}
+
+// crawledOnBoth keeps the links of the source hosts found on both sides
+func crawledOnBoth(from []Monitor, to []Monitor) ([]Monitor, []Monitor) {
+	fromSources := make(map[string]bool)
+	for _, m := range from {
+		fromSources[m.SourceHost] = true
+	}
+	toSources := make(map[string]bool)
+	for _, m := range to {
+		toSources[m.SourceHost] = true
+	}
+	keep := func(monitors []Monitor, sources map[string]bool) []Monitor {
+		var kept []Monitor
+		for _, m := range monitors {
+			if sources[m.SourceHost] {
+				kept = append(kept, m)
+			}
+		}
+		return kept
+	}
+	return keep(from, toSources), keep(to, fromSources)
+}
+
+// the biggest changes first
+func sortMoves(moves []HostMove) {
+	sort.Slice(moves, func(i, j int) bool {
+		if abs(moves[i].Delta) != abs(moves[j].Delta) {
+			return abs(moves[i].Delta) > abs(moves[j].Delta)
+		}
+		return moves[i].ExternalHost < moves[j].ExternalHost
+	})
+}
+
+// GetLeaderboard ranks the external hosts of the links saved on the day
+func GetLeaderboard(dbFilepath string, day string, by string, limit int) ([]HostRank, error) {
+	monitors, err := GetAllDataFromMonitorByDay(dbFilepath, day)
+	if err != nil {
+		return nil, err
+	}
+	return RankExternalHosts(monitors, by, limit)
+}
+
+// GetShareOfVoice splits the links saved on the day by the host types
+func GetShareOfVoice(dbFilepath string, day string) ([]ShareOfVoice, error) {
+	monitors, err := GetAllDataFromMonitorByDay(dbFilepath, day)
+	if err != nil {
+		return nil, err
+	}
+	return ShareOfVoiceByType(monitors), nil
+}
+
+// GetMovers compares the external hosts of the days from and to by the latest
+// runs of the source hosts crawled on both days. The latest day is used if to
+// is empty and the day before it if from is empty
+func GetMovers(dbFilepath string, from string, to string, limit int) (Movers, error) {
+	if from == "" || to == "" {
+		days, err := GetAllDaysFromMonitor(dbFilepath)
+		if err != nil {
+			return Movers{}, err
+		}
+		from, to = diffDays(days, from, to)
+	}
+	fromLinks, err := GetAllDataFromMonitorByDay(dbFilepath, from)
+	if err != nil {
+		return Movers{}, err
+	}
+	toLinks, err := GetAllDataFromMonitorByDay(dbFilepath, to)
+	if err != nil {
+		return Movers{}, err
+	}
+	movers := Movers{From: from, To: to}
+	movers.Gains, movers.Losses = HostMovers(fromLinks, toLinks, limit)
+	return movers, nil
+}
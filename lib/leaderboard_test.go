@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var leaderboardMonitors = []Monitor{
+	{SourceHost: "a.kg", SourceHostType: "B", ExternalHost: "x.kg", ExternalHostType: "H", ExternalLink: "http://x.kg/1", Count: 10},
+	{SourceHost: "a.kg", SourceHostType: "B", ExternalHost: "x.kg", ExternalHostType: "H", ExternalLink: "http://x.kg/2", Count: 5},
+	{SourceHost: "a.kg", SourceHostType: "B", ExternalHost: "y.kg", ExternalHostType: "M", ExternalLink: "http://y.kg/", Count: 5},
+	{SourceHost: "b.kg", SourceHostType: "M", ExternalHost: "y.kg", ExternalHostType: "M", ExternalLink: "http://y.kg/", Count: 1},
+	{SourceHost: "b.kg", SourceHostType: "M", ExternalHost: "z.kg", ExternalHostType: "H", ExternalLink: "http://z.kg/", Count: 3},
+}
+
+func TestRankExternalHosts(t *testing.T) {
+	ranks, err := RankExternalHosts(leaderboardMonitors, RankBySources, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []HostRank{
+		{ExternalHost: "y.kg", ExternalHostType: "M", Sources: 2, Links: 2, Count: 6},
+		{ExternalHost: "x.kg", ExternalHostType: "H", Sources: 1, Links: 2, Count: 15},
+		{ExternalHost: "z.kg", ExternalHostType: "H", Sources: 1, Links: 1, Count: 3},
+	}, ranks)
+
+	ranks, _ = RankExternalHosts(leaderboardMonitors, RankByCount, 2)
+	assert.Equal(t, 2, len(ranks))
+	assert.Equal(t, "x.kg", ranks[0].ExternalHost)
+	assert.Equal(t, "y.kg", ranks[1].ExternalHost)
+
+	_, err = RankExternalHosts(leaderboardMonitors, "links", 0)
+	assert.Error(t, err)
+}
+
+func TestShareOfVoiceByType(t *testing.T) {
+	assert.Equal(t, []ShareOfVoice{
+		{SourceType: "B", ExternalType: "H", Links: 2, Count: 15, Share: 0.75},
+		{SourceType: "B", ExternalType: "M", Links: 1, Count: 5, Share: 0.25},
+		{SourceType: "M", ExternalType: "H", Links: 1, Count: 3, Share: 0.75},
+		{SourceType: "M", ExternalType: "M", Links: 1, Count: 1, Share: 0.25},
+	}, ShareOfVoiceByType(leaderboardMonitors))
+}
+
+func TestHostMovers(t *testing.T) {
+	to := []Monitor{
+		{SourceHost: "a.kg", ExternalHost: "x.kg", ExternalLink: "http://x.kg/1", Count: 4},
+		{SourceHost: "a.kg", ExternalHost: "y.kg", ExternalLink: "http://y.kg/", Count: 9},
+		{SourceHost: "b.kg", ExternalHost: "z.kg", ExternalLink: "http://z.kg/", Count: 1},
+		// c.kg was not crawled on the day from
+		{SourceHost: "c.kg", ExternalHost: "w.kg", ExternalLink: "http://w.kg/", Count: 2},
+	}
+	gains, losses := HostMovers(leaderboardMonitors, to, 0)
+	assert.Equal(t, []HostMove{
+		{ExternalHost: "y.kg", FromCount: 6, ToCount: 9, Delta: 3, FromSources: 2, ToSources: 1},
+	}, gains)
+	assert.Equal(t, []HostMove{
+		{ExternalHost: "x.kg", FromCount: 15, ToCount: 4, Delta: -11, FromSources: 1, ToSources: 1},
+		{ExternalHost: "z.kg", FromCount: 3, ToCount: 1, Delta: -2, FromSources: 1, ToSources: 1},
+	}, losses)
+
+	gains, losses = HostMovers(leaderboardMonitors, to, 1)
+	assert.Equal(t, 1, len(gains))
+	assert.Equal(t, 1, len(losses))
+
+	// b.kg was not crawled on the day to
+	gains, losses = HostMovers(leaderboardMonitors, to[:2], 0)
+	assert.Equal(t, 1, len(gains))
+	assert.Equal(t, []HostMove{{ExternalHost: "x.kg", FromCount: 15, ToCount: 4, Delta: -11, FromSources: 1, ToSources: 1}}, losses)
+}
+
+func TestGetMovers(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+	db, _ := sql.Open("sqlite3", DBFilepath)
+	for _, row := range []struct {
+		link, host, created string
+		count               int
+	}{
+		{"http://x.kg/", "x.kg", "2017-01-01 10:00:00", 2},
+		{"http://x.kg/", "x.kg", "2017-01-02 10:00:00", 5},
+	} {
+		db.Exec("insert into monitor(source_host, external_link, count, external_host, created) values('a.kg', ?, ?, ?, ?)",
+			row.link, row.count, row.host, row.created)
+	}
+	db.Close()
+
+	movers, err := GetMovers(DBFilepath, "", "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "2017-01-01", movers.From)
+	assert.Equal(t, "2017-01-02", movers.To)
+	assert.Equal(t, 3, movers.Gains[0].Delta)
+	assert.Equal(t, 0, len(movers.Losses))
+
+	ranks, err := GetLeaderboard(DBFilepath, "2017-01-02", "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, ranks[0].Count)
+
+	excelFilePath := "/tmp/spiderwoman-reports.xls"
+	os.Remove(excelFilePath)
+	CreateEmptyExcel(excelFilePath)
+	assert.NoError(t, AppendReportsExcelFromDB(DBFilepath, excelFilePath, "2017-01-02"))
+}
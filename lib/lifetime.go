@@ -0,0 +1,221 @@
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+)
+
+const (
+	LinkActive = "active"
+	LinkGone   = "gone"
+)
+
+// LinkLifetime is the history of the outbound link of the source host over
+// the runs: when it was found first and last, on how many runs and whether
+// it is still there. Gone is when the run found it was removed
+type LinkLifetime struct {
+	SourceHost   string
+	ExternalLink string
+	ExternalHost string
+	FirstSeen    string
+	LastSeen     string
+	Gone         string
+	Runs         int
+	State        string
+}
+
+// UpdateLinkLifetimes records the links found by the run. The links of the
+// crawled hosts which were not found any more become gone, the hosts which
+// were not crawled keep their links as they were. Returns the numbers of
+// the new and the gone links
+func UpdateLinkLifetimes(dbFilepath string, runID int64, links map[string]map[string]int, crawledHosts []string) (int, int, error) {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return 0, 0, err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Print(err)
+		return 0, 0, err
+	}
+	added, gone := 0, 0
+	for sourceHost, externalLinks := range links {
+		for externalLink := range externalLinks {
+			externalHost := externalLink
+			if u, err := url.Parse(externalLink); err == nil {
+				externalHost = u.Host
+			}
+			result, err := tx.Exec("insert or ignore into link_lifetime(source_host, external_link, external_host, first_seen, last_seen, "+
+				"first_run_id, last_run_id, runs, state) values(?, ?, ?, DateTime('now'), DateTime('now'), ?, 0, 0, ?)",
+				sourceHost, externalLink, externalHost, runID, LinkActive)
+			if err == nil {
+				if n, _ := result.RowsAffected(); n > 0 {
+					added++
+				}
+				// the resumed run is counted once
+				_, err = tx.Exec("UPDATE link_lifetime SET last_seen=DateTime('now'), runs=runs+CASE WHEN last_run_id=? THEN 0 ELSE 1 END, "+
+					"last_run_id=?, state=?, gone=NULL WHERE source_host=? AND external_link=?", runID, runID, LinkActive, sourceHost, externalLink)
+			}
+			if err != nil {
+				log.Printf("Error saving link lifetime: %v", err)
+				tx.Rollback()
+				return 0, 0, err
+			}
+		}
+	}
+	for _, host := range crawledHosts {
+		result, err := tx.Exec("UPDATE link_lifetime SET state=?, gone=DateTime('now') WHERE source_host=? AND state=? AND last_run_id!=?",
+			LinkGone, host, LinkActive, runID)
+		if err != nil {
+			log.Printf("Error saving link lifetime: %v", err)
+			tx.Rollback()
+			return 0, 0, err
+		}
+		n, _ := result.RowsAffected()
+		gone += int(n)
+	}
+	return added, gone, tx.Commit()
+}
+
+// NewExternalHost is the external host the source host linked to for the
+// first time on the run
+type NewExternalHost struct {
+	SourceHost   string
+	ExternalHost string
+	Links        int
+}
+
+// GetNewExternalHosts returns the external hosts the source hosts linked to
+// for the first time on the run. The sources found first by the run are left
+// out, all of their hosts are new
+func GetNewExternalHosts(dbFilepath string, runID int64) ([]NewExternalHost, error) {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT l.source_host, coalesce(l.external_host,''), count(*) FROM link_lifetime as l WHERE l.first_run_id=? "+
+		"AND NOT EXISTS (SELECT 1 FROM link_lifetime as p WHERE p.source_host=l.source_host AND p.external_host=l.external_host AND p.first_run_id!=?) "+
+		"AND EXISTS (SELECT 1 FROM link_lifetime as p WHERE p.source_host=l.source_host AND p.first_run_id!=?) "+
+		"GROUP BY l.source_host, l.external_host ORDER BY l.source_host, l.external_host", runID, runID, runID)
+	if err != nil {
+		log.Printf("Error getting new external hosts: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hosts []NewExternalHost
+	for rows.Next() {
+		var h NewExternalHost
+		if err := rows.Scan(&h.SourceHost, &h.ExternalHost, &h.Links); err != nil {
+			log.Printf("Error getting new external hosts: %v", err)
+			continue
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// backfillLinkLifetimes fills the empty lifetimes from the links saved in the
+// monitor by the runs before the lifetimes were kept, every day with the link
+// counts as a run
+func backfillLinkLifetimes(db *sql.DB) {
+	var lifetimes int
+	if err := db.QueryRow("SELECT count(*) FROM link_lifetime").Scan(&lifetimes); err != nil || lifetimes > 0 {
+		return
+	}
+	_, err := db.Exec("insert or ignore into link_lifetime(source_host, external_link, external_host, first_seen, last_seen, " +
+		"first_run_id, last_run_id, runs, state) " +
+		"SELECT source_host, external_link, max(external_host), min(created), max(created), 0, 0, count(distinct date(created)), 'active' " +
+		"FROM monitor GROUP BY source_host, external_link")
+	if err == nil {
+		_, err = db.Exec("UPDATE link_lifetime SET state='gone', " +
+			"gone=(SELECT min(m.created) FROM monitor as m WHERE m.source_host=link_lifetime.source_host AND date(m.created) > date(link_lifetime.last_seen)) " +
+			"WHERE date(last_seen) < (SELECT max(date(m.created)) FROM monitor as m WHERE m.source_host=link_lifetime.source_host)")
+	}
+	if err != nil {
+		log.Printf("Error filling link lifetimes: %v", err)
+	}
+}
+
+// GetLinkLifetimes returns the lifetimes of the links of the source host,
+// of all the hosts if it is empty
+func GetLinkLifetimes(dbFilepath string, sourceHost string) ([]LinkLifetime, error) {
+	where := "1=1"
+	var args []interface{}
+	if sourceHost != "" {
+		where = "source_host=?"
+		args = append(args, sourceHost)
+	}
+	return queryLinkLifetimes(dbFilepath, where, args)
+}
+
+// GetNewLinks returns the links found first on the days from and to inclusive,
+// any of them may be empty
+func GetNewLinks(dbFilepath string, from string, to string) ([]LinkLifetime, error) {
+	where, args := periodCondition("first_seen", from, to)
+	return queryLinkLifetimes(dbFilepath, where, args)
+}
+
+// GetRemovedLinks returns the links which are gone since the days from and to
+// inclusive, any of them may be empty
+func GetRemovedLinks(dbFilepath string, from string, to string) ([]LinkLifetime, error) {
+	where, args := periodCondition("gone", from, to)
+	return queryLinkLifetimes(dbFilepath, fmt.Sprintf("state='%s' AND %s", LinkGone, where), args)
+}
+
+func periodCondition(column string, from string, to string) (string, []interface{}) {
+	where := "1=1"
+	var args []interface{}
+	if from != "" {
+		where += fmt.Sprintf(" AND %s >= ?", column)
+		args = append(args, from)
+	}
+	if to != "" {
+		where += fmt.Sprintf(" AND %s < date(?, '+1 day')", column)
+		args = append(args, to)
+	}
+	return where, args
+}
+
+func queryLinkLifetimes(dbFilepath string, where string, args []interface{}) ([]LinkLifetime, error) {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT source_host, external_link, coalesce(external_host,''), first_seen, last_seen, gone, runs, state "+
+		"FROM link_lifetime WHERE "+where+" ORDER BY source_host, external_link", args...)
+	if err != nil {
+		log.Printf("Error getting link lifetimes: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	lifetimes := []LinkLifetime{}
+	for rows.Next() {
+		var l LinkLifetime
+		var gone sql.NullString
+		if err := rows.Scan(&l.SourceHost, &l.ExternalLink, &l.ExternalHost, &l.FirstSeen, &l.LastSeen, &gone, &l.Runs, &l.State); err != nil {
+			log.Printf("Error getting link lifetimes: %v", err)
+			continue
+		}
+		l.Gone = gone.String
+		lifetimes = append(lifetimes, l)
+	}
+	return lifetimes, nil
+}
+
+// FormatLifetimeReport makes a line for the run report, e.g. "Links: 3 new, 1 removed"
+func FormatLifetimeReport(added int, gone int) string {
+	return fmt.Sprintf("Links: %d new, %d removed", added, gone)
+}
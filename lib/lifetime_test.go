@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateLinkLifetimes(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	added, gone, err := UpdateLinkLifetimes(DBFilepath, 1, map[string]map[string]int{
+		"a.kg": {"http://x.kg/1": 2, "http://y.kg/": 1},
+		"b.kg": {"http://x.kg/1": 1},
+	}, []string{"a.kg", "b.kg"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, added)
+	assert.Equal(t, 0, gone)
+
+	// b.kg was not crawled by the second run, so its link is not gone
+	added, gone, err = UpdateLinkLifetimes(DBFilepath, 2, map[string]map[string]int{
+		"a.kg": {"http://x.kg/1": 1, "http://z.kg/": 1},
+	}, []string{"a.kg"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, 1, gone)
+
+	lifetimes, err := GetLinkLifetimes(DBFilepath, "a.kg")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(lifetimes))
+	assert.Equal(t, "http://x.kg/1", lifetimes[0].ExternalLink)
+	assert.Equal(t, "x.kg", lifetimes[0].ExternalHost)
+	assert.Equal(t, 2, lifetimes[0].Runs)
+	assert.Equal(t, LinkActive, lifetimes[0].State)
+	assert.Equal(t, LinkGone, lifetimes[1].State)
+	assert.NotEmpty(t, lifetimes[1].Gone)
+
+	lifetimes, _ = GetLinkLifetimes(DBFilepath, "b.kg")
+	assert.Equal(t, LinkActive, lifetimes[0].State)
+
+	removed, err := GetRemovedLinks(DBFilepath, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(removed))
+	assert.Equal(t, "http://y.kg/", removed[0].ExternalLink)
+
+	added, gone, _ = UpdateLinkLifetimes(DBFilepath, 3, map[string]map[string]int{
+		"a.kg": {"http://x.kg/1": 1, "http://y.kg/": 1, "http://z.kg/": 1},
+	}, []string{"a.kg"})
+	assert.Equal(t, 0, added)
+	assert.Equal(t, 0, gone)
+	removed, _ = GetRemovedLinks(DBFilepath, "", "")
+	assert.Equal(t, 0, len(removed))
+
+	created, err := GetNewLinks(DBFilepath, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(created))
+	created, _ = GetNewLinks(DBFilepath, "", "2000-01-01")
+	assert.Equal(t, 0, len(created))
+
+	// the resumed run saves its links again
+	UpdateLinkLifetimes(DBFilepath, 4, map[string]map[string]int{"c.kg": {"http://x.kg/1": 1}}, []string{"c.kg"})
+	UpdateLinkLifetimes(DBFilepath, 4, map[string]map[string]int{"c.kg": {"http://x.kg/1": 1}}, []string{"c.kg"})
+	lifetimes, _ = GetLinkLifetimes(DBFilepath, "c.kg")
+	assert.Equal(t, 1, lifetimes[0].Runs)
+}
+
+func TestGetNewExternalHosts(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	UpdateLinkLifetimes(DBFilepath, 1, map[string]map[string]int{"a.kg": {"http://x.kg/1": 1}}, []string{"a.kg"})
+	UpdateLinkLifetimes(DBFilepath, 2, map[string]map[string]int{
+		"a.kg": {"http://x.kg/1": 1, "http://x.kg/2": 1, "http://y.kg/1": 1, "http://y.kg/2": 1},
+		"b.kg": {"http://x.kg/1": 1},
+	}, []string{"a.kg", "b.kg"})
+
+	// b.kg is crawled first by the run 2, x.kg is not new on a.kg
+	hosts, err := GetNewExternalHosts(DBFilepath, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []NewExternalHost{{SourceHost: "a.kg", ExternalHost: "y.kg", Links: 2}}, hosts)
+
+	// the later run of the same day finds nothing new
+	UpdateLinkLifetimes(DBFilepath, 3, map[string]map[string]int{"a.kg": {"http://y.kg/1": 1}}, []string{"a.kg"})
+	hosts, _ = GetNewExternalHosts(DBFilepath, 3)
+	assert.Equal(t, 0, len(hosts))
+}
+
+func TestBackfillLinkLifetimes(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	db, _ := sql.Open("sqlite3", DBFilepath)
+	for _, row := range [][]string{
+		{"a.kg", "http://x.kg/", "2017-01-01 10:00:00"},
+		{"a.kg", "http://x.kg/", "2017-01-02 10:00:00"},
+		{"a.kg", "http://y.kg/", "2017-01-01 10:00:00"},
+	} {
+		db.Exec("insert into monitor(source_host, external_link, count, external_host, created) values(?, ?, 1, '', ?)", row[0], row[1], row[2])
+	}
+	db.Close()
+	CreateDBIfNotExists(DBFilepath)
+
+	lifetimes, err := GetLinkLifetimes(DBFilepath, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(lifetimes))
+	assert.Equal(t, LinkLifetime{SourceHost: "a.kg", ExternalLink: "http://x.kg/", FirstSeen: "2017-01-01T10:00:00Z",
+		LastSeen: "2017-01-02T10:00:00Z", Runs: 2, State: LinkActive}, lifetimes[0])
+	assert.Equal(t, LinkGone, lifetimes[1].State)
+	assert.Equal(t, "2017-01-02T10:00:00Z", lifetimes[1].Gone)
+}
+
+func TestFormatLifetimeReport(t *testing.T) {
+	assert.Equal(t, "Links: 3 new, 1 removed", FormatLifetimeReport(3, 1))
+}
@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	PlacementSitewide = "sitewide"
+	PlacementHeader   = "header"
+	PlacementFooter   = "footer"
+	PlacementSidebar  = "sidebar"
+	PlacementContent  = "content"
+	PlacementOther    = "other"
+)
+
+// LinkInfo is what is known about the outbound link of the source host
+// besides the number of times it was found: the include selector and
+// the part of the page it was found in first, the number of distinct pages
+// it is on with their urls, the number of pages crawled on the source host,
+// the banner of the link
+// and the reason the link is hidden from the visitors. The watched domain
+// of the link is set when the links are saved
+type LinkInfo struct {
+	Region       string          `json:",omitempty"`
+	Placement    string          `json:",omitempty"`
+	Pages        int             `json:",omitempty"`
+	PageURLs     map[string]bool `json:",omitempty"`
+	PagesCrawled int             `json:",omitempty"`
+	Banner       *Banner         `json:",omitempty"`
+	Hidden       string          `json:",omitempty"`
+	Watched      *WatchedDomain  `json:",omitempty"`
+}
+
+// Merge adds the info of the link found on more pages, the page found with
+// both links, e.g. with the two links resolved to the same url, is counted once
+func (i LinkInfo) Merge(other LinkInfo) LinkInfo {
+	if i.Region == "" {
+		i.Region = other.Region
+	}
+	if i.Placement == "" {
+		i.Placement = other.Placement
+	}
+	if i.Banner == nil {
+		i.Banner = other.Banner
+	}
+	if i.Hidden == "" {
+		i.Hidden = other.Hidden
+	}
+	i.Pages += other.Pages
+	for page := range other.PageURLs {
+		if i.PageURLs[page] {
+			i.Pages--
+			continue
+		}
+		if i.PageURLs == nil {
+			i.PageURLs = make(map[string]bool)
+		}
+		i.PageURLs[page] = true
+	}
+	return i
+}
+
+// the tags and the words in the ids and classes of the parts of the page,
+// the footer is checked first, as it is often inside the content wrapper
+var placementHints = []struct {
+	placement string
+	tags      []string
+	words     []string
+}{
+	{PlacementFooter, []string{"footer"}, []string{"footer", "copyright", "bottom"}},
+	{PlacementHeader, []string{"header", "nav"}, []string{"header", "nav", "menu", "topbar"}},
+	{PlacementSidebar, []string{"aside"}, []string{"sidebar", "aside", "widget"}},
+	{PlacementContent, []string{"article", "main"}, []string{"content", "article", "post", "entry", "story"}},
+}
+
+// AnchorPlacement guesses the part of the page the anchor is in by the tags,
+// ids and classes of the nearest parent which looks like a header, a footer,
+// a sidebar or the content
+func AnchorPlacement(s *goquery.Selection) string {
+	for node := s.Parent(); node.Length() > 0; node = node.Parent() {
+		name := goquery.NodeName(node)
+		id, _ := node.Attr("id")
+		class, _ := node.Attr("class")
+		attrs := strings.ToLower(id + " " + class)
+		for _, hint := range placementHints {
+			for _, tag := range hint.tags {
+				if name == tag {
+					return hint.placement
+				}
+			}
+			for _, word := range hint.words {
+				if strings.Contains(attrs, word) {
+					return hint.placement
+				}
+			}
+		}
+	}
+	return PlacementOther
+}
+
+// ClassifyPlacement labels the link which is on sitewidePercent of the crawled
+// pages or more as sitewide, the other links keep the part of the page they
+// were found in. At least minPages pages of the host should be crawled to tell
+// the sitewide links from the ones on the only page
+func ClassifyPlacement(info LinkInfo, sitewidePercent int, minPages int) string {
+	if info.PagesCrawled >= minPages && info.PagesCrawled > 0 && info.Pages*100 >= info.PagesCrawled*sitewidePercent {
+		return PlacementSitewide
+	}
+	if info.Placement == "" {
+		return PlacementOther
+	}
+	return info.Placement
+}
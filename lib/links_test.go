@@ -0,0 +1,52 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnchorPlacement(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><body>
+<div id="top-menu"><a href="http://menu.kg/">menu</a></div>
+<div class="wrapper">
+	<div class="post-body"><p><a href="http://article.kg/">article</a></p></div>
+	<div class="post-body"><div class="footer-links"><a href="http://partner.kg/">partner</a></div></div>
+	<div class="right-column"><div class="Widget"><a href="http://banner.kg/">banner</a></div></div>
+	<a href="http://other.kg/">other</a>
+</div>
+<nav><a href="http://nav.kg/">nav</a></nav>
+</body></html>`))
+	assert.NoError(t, err)
+
+	placements := make(map[string]string)
+	doc.Find("a").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		placements[href] = AnchorPlacement(s)
+	})
+	assert.Equal(t, map[string]string{
+		"http://menu.kg/":    PlacementHeader,
+		"http://article.kg/": PlacementContent,
+		"http://partner.kg/": PlacementFooter,
+		"http://banner.kg/":  PlacementSidebar,
+		"http://other.kg/":   PlacementOther,
+		"http://nav.kg/":     PlacementHeader,
+	}, placements)
+}
+
+func TestLinkInfoMerge(t *testing.T) {
+	info := LinkInfo{}.Merge(LinkInfo{Placement: PlacementFooter, Pages: 1})
+	info = info.Merge(LinkInfo{Region: "article", Placement: PlacementContent, Pages: 1})
+	assert.Equal(t, LinkInfo{Region: "article", Placement: PlacementFooter, Pages: 2}, info)
+}
+
+func TestClassifyPlacement(t *testing.T) {
+	assert.Equal(t, PlacementSitewide, ClassifyPlacement(LinkInfo{Placement: PlacementFooter, Pages: 8, PagesCrawled: 10}, 80, 3))
+	assert.Equal(t, PlacementFooter, ClassifyPlacement(LinkInfo{Placement: PlacementFooter, Pages: 7, PagesCrawled: 10}, 80, 3))
+	// one or two pages are not enough to tell
+	assert.Equal(t, PlacementContent, ClassifyPlacement(LinkInfo{Placement: PlacementContent, Pages: 2, PagesCrawled: 2}, 80, 3))
+	assert.Equal(t, PlacementOther, ClassifyPlacement(LinkInfo{Pages: 1, PagesCrawled: 10}, 80, 3))
+	assert.Equal(t, PlacementOther, ClassifyPlacement(LinkInfo{}, 80, 0))
+}
@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"mime"
+	"strings"
+)
+
+// MimeFilter keeps or drops a resolved link by the Content-Type it answers with.
+// Patterns are full media types (application/pdf) or wildcards (image/*)
+type MimeFilter struct {
+	Allow []string
+	Deny  []string
+}
+
+// NewMimeFilter builds the filter from comma separated lists, as they are
+// written in config.yml
+func NewMimeFilter(allow string, deny string) MimeFilter {
+	return MimeFilter{
+		Allow: splitMimeList(allow),
+		Deny:  splitMimeList(deny),
+	}
+}
+
+// Check returns false and the reason if the link with such content type
+// should not be saved. Links with unknown content type are always kept
+func (f MimeFilter) Check(contentType string) (bool, string) {
+	mediaType := ParseMediaType(contentType)
+	if mediaType == "" {
+		return true, ""
+	}
+	for _, pattern := range f.Deny {
+		if matchMediaType(mediaType, pattern) {
+			return false, "content type " + mediaType + " is denied by " + pattern
+		}
+	}
+	if len(f.Allow) == 0 {
+		return true, ""
+	}
+	for _, pattern := range f.Allow {
+		if matchMediaType(mediaType, pattern) {
+			return true, ""
+		}
+	}
+	return false, "content type " + mediaType + " is not in the allow list"
+}
+
+// ParseMediaType strips parameters like charset from the Content-Type header
+func ParseMediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.Split(contentType, ";")[0]
+	}
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+func matchMediaType(mediaType string, pattern string) bool {
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+	}
+	return mediaType == pattern
+}
+
+func splitMimeList(list string) []string {
+	var result []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
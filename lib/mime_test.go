@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMimeFilterDeny(t *testing.T) {
+	f := NewMimeFilter("", "image/*, application/pdf")
+
+	ok, reason := f.Check("image/PNG")
+	assert.False(t, ok)
+	assert.Contains(t, reason, "image/*")
+
+	ok, _ = f.Check("application/pdf; charset=binary")
+	assert.False(t, ok)
+
+	ok, reason = f.Check("text/html; charset=utf-8")
+	assert.True(t, ok)
+	assert.Equal(t, "", reason)
+}
+
+func TestMimeFilterAllow(t *testing.T) {
+	f := NewMimeFilter("text/html,application/xhtml+xml", "")
+
+	ok, _ := f.Check("text/html")
+	assert.True(t, ok)
+
+	ok, reason := f.Check("application/zip")
+	assert.False(t, ok)
+	assert.Contains(t, reason, "allow list")
+}
+
+func TestMimeFilterUnknownContentType(t *testing.T) {
+	f := NewMimeFilter("text/html", "image/*")
+	ok, _ := f.Check("")
+	assert.True(t, ok)
+}
+
+func TestParseMediaType(t *testing.T) {
+	assert.Equal(t, "text/html", ParseMediaType("Text/HTML; charset=windows-1251"))
+	assert.Equal(t, "", ParseMediaType(""))
+}
@@ -0,0 +1,262 @@
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	NetworksFilepath        = "./networks.yml"
+	NetworksDefaultFilepath = "./networks.default.yml"
+)
+
+// Network is the ad network, the counter or the tag manager known by the hosts
+// its scripts, frames and pixels are loaded from, e.g.
+//
+//	Google AdSense:
+//	  category: ads
+//	  hosts: [pagead2.googlesyndication.com, googleads.g.doubleclick.net]
+type Network struct {
+	Name     string   `yaml:"-"`
+	Category string   `yaml:"category"`
+	Hosts    []string `yaml:"hosts"`
+}
+
+// NetworkPresence is the network embedded on the pages of the source host
+// during the run. Pages is the number of the pages with the most used host
+// of the network out of PagesCrawled
+type NetworkPresence struct {
+	RunID        int64
+	SourceHost   string
+	Network      string
+	Category     string
+	Hosts        []string
+	Pages        int
+	PagesCrawled int
+	Created      string
+}
+
+// GetNetworks reads the signatures of the known networks sorted by the name.
+// There are no networks if neither of the files exists
+func GetNetworks(realFile string, defaultFile string) ([]Network, error) {
+	data, err := ioutil.ReadFile(realFile)
+	if os.IsNotExist(err) {
+		data, err = ioutil.ReadFile(defaultFile)
+	}
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var parsed map[string]Network
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		return nil, err
+	}
+	var networks []Network
+	for name, network := range parsed {
+		network.Name = name
+		for i, host := range network.Hosts {
+			network.Hosts[i] = strings.ToLower(strings.TrimSpace(host))
+		}
+		networks = append(networks, network)
+	}
+	sort.Slice(networks, func(i, j int) bool {
+		return networks[i].Name < networks[j].Name
+	})
+	return networks, nil
+}
+
+// MatchNetwork finds the network of the host, the subdomains of the network
+// hosts belong to the network too
+func MatchNetwork(networks []Network, host string) (Network, bool) {
+	host = strings.ToLower(host)
+	for _, network := range networks {
+		for _, networkHost := range network.Hosts {
+			if host == networkHost || strings.HasSuffix(host, "."+networkHost) {
+				return network, true
+			}
+		}
+	}
+	return Network{}, false
+}
+
+// PageEmbeds returns the third-party hosts of the scripts, frames and pixels
+// of the page
+func PageEmbeds(doc *goquery.Document, pageURL *url.URL) []string {
+	seen := make(map[string]bool)
+	var hosts []string
+	add := func(src string) {
+		u, err := pageURL.Parse(strings.TrimSpace(src))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return
+		}
+		host := strings.ToLower(u.Hostname())
+		if host == "" || sameSite(host, pageURL.Hostname()) || seen[host] {
+			return
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+	doc.Find("script[src], iframe[src]").Each(func(i int, s *goquery.Selection) {
+		add(s.AttrOr("src", ""))
+	})
+	doc.Find("img[src]").Each(func(i int, s *goquery.Selection) {
+		if isPixel(s) {
+			add(s.AttrOr("src", ""))
+		}
+	})
+	return hosts
+}
+
+// the host of the page, its www. version and its subdomains are not third-party
+func sameSite(host string, pageHost string) bool {
+	site := strings.TrimPrefix(strings.ToLower(pageHost), "www.")
+	return host == site || strings.HasSuffix(host, "."+site)
+}
+
+// isPixel is true for the tracking images of 1x1 or 0x0 pixels: the width
+// or the height is declared by the attributes or the style and none of the
+// declared sizes is over 1
+func isPixel(s *goquery.Selection) bool {
+	var sizes []string
+	for _, name := range []string{"width", "height"} {
+		if value, ok := s.Attr(name); ok {
+			sizes = append(sizes, strings.TrimSuffix(strings.TrimSpace(value), "px"))
+		}
+	}
+	for _, match := range styleSizePattern.FindAllStringSubmatch(s.AttrOr("style", ""), -1) {
+		sizes = append(sizes, match[2])
+	}
+	if len(sizes) == 0 {
+		return false
+	}
+	for _, size := range sizes {
+		value, err := strconv.Atoi(size)
+		if err != nil || value > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// NetworkPresences matches the embedded hosts of every source host with the
+// networks, pages are the numbers of the pages with the embedded host
+func NetworkPresences(networks []Network, embeds map[string]map[string]int, pagesCrawled map[string]int) []NetworkPresence {
+	var presences []NetworkPresence
+	var sources []string
+	for source := range embeds {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		byNetwork := make(map[string]*NetworkPresence)
+		var names []string
+		for host, pages := range embeds[source] {
+			network, ok := MatchNetwork(networks, host)
+			if !ok {
+				continue
+			}
+			presence, ok := byNetwork[network.Name]
+			if !ok {
+				presence = &NetworkPresence{SourceHost: source, Network: network.Name, Category: network.Category, PagesCrawled: pagesCrawled[source]}
+				byNetwork[network.Name] = presence
+				names = append(names, network.Name)
+			}
+			presence.Hosts = append(presence.Hosts, host)
+			if pages > presence.Pages {
+				presence.Pages = pages
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sort.Strings(byNetwork[name].Hosts)
+			presences = append(presences, *byNetwork[name])
+		}
+	}
+	return presences
+}
+
+func SaveNetworkPresences(dbFilepath string, runID int64, presences []NetworkPresence) error {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer db.Close()
+
+	for _, p := range presences {
+		_, err = db.Exec("insert or replace into networks(run_id, source_host, network, category, hosts, pages, pages_crawled, created) "+
+			"values(?, ?, ?, ?, ?, ?, ?, DateTime('now'))",
+			runID, p.SourceHost, p.Network, p.Category, strings.Join(p.Hosts, ","), p.Pages, p.PagesCrawled)
+		if err != nil {
+			log.Printf("Error saving networks: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// GetNetworkPresences returns the networks found by the runs on the days
+// from and to inclusive, any of them may be empty
+func GetNetworkPresences(dbFilepath string, from string, to string) ([]NetworkPresence, error) {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	defer db.Close()
+
+	query := "SELECT run_id, source_host, network, category, hosts, pages, pages_crawled, created FROM networks WHERE 1=1"
+	var args []interface{}
+	if from != "" {
+		query += " AND created >= ?"
+		args = append(args, from)
+	}
+	if to != "" {
+		query += " AND created < date(?, '+1 day')"
+		args = append(args, to)
+	}
+	rows, err := db.Query(query+" ORDER BY created, source_host, network", args...)
+	if err != nil {
+		log.Printf("Error getting networks: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	presences := []NetworkPresence{}
+	for rows.Next() {
+		var p NetworkPresence
+		var hosts string
+		if err := rows.Scan(&p.RunID, &p.SourceHost, &p.Network, &p.Category, &hosts, &p.Pages, &p.PagesCrawled, &p.Created); err != nil {
+			log.Printf("Error getting networks: %v", err)
+			continue
+		}
+		if hosts != "" {
+			p.Hosts = strings.Split(hosts, ",")
+		}
+		presences = append(presences, p)
+	}
+	return presences, nil
+}
+
+// FormatNetworksReport makes a line for the run report, e.g. "Networks: 5 on 3 sites"
+func FormatNetworksReport(presences []NetworkPresence) string {
+	networks := make(map[string]bool)
+	sources := make(map[string]bool)
+	for _, p := range presences {
+		networks[p.Network] = true
+		sources[p.SourceHost] = true
+	}
+	return fmt.Sprintf("Networks: %d on %d sites", len(networks), len(sources))
+}
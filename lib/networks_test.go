@@ -0,0 +1,110 @@
+package lib
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetNetworks(t *testing.T) {
+	networks, err := GetNetworks("/tmp/no-such-networks.yml", "../networks.default.yml")
+	assert.NoError(t, err)
+	assert.True(t, len(networks) > 0)
+	network, ok := MatchNetwork(networks, "PAGEAD2.googlesyndication.com")
+	assert.True(t, ok)
+	assert.Equal(t, "Google AdSense", network.Name)
+
+	networks, err = GetNetworks("/tmp/no-such-networks.yml", "/tmp/no-such-networks.default.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(networks))
+
+	filepath := "/tmp/spiderwoman-networks.yml"
+	defer os.Remove(filepath)
+	ioutil.WriteFile(filepath, []byte(`
+Yandex.Metrica:
+  category: counter
+  hosts: [mc.yandex.ru]
+AdRiver:
+  category: ads
+  hosts: [adriver.ru]
+`), 0644)
+	networks, err = GetNetworks(filepath, "../networks.default.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(networks))
+	assert.Equal(t, "AdRiver", networks[0].Name)
+
+	network, ok = MatchNetwork(networks, "content.adriver.ru")
+	assert.True(t, ok)
+	assert.Equal(t, "ads", network.Category)
+	_, ok = MatchNetwork(networks, "notadriver.ru")
+	assert.False(t, ok)
+}
+
+func TestPageEmbeds(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><head>
+<script src="/js/site.js"></script>
+<script src="https://static.site.kg/app.js"></script>
+<script async src="//pagead2.googlesyndication.com/pagead/js/adsbygoogle.js"></script>
+<script src="https://mc.yandex.ru/metrika/tag.js"></script>
+<script>var inline = true;</script>
+</head><body>
+<iframe src="https://an.yandex.ru/frame"></iframe>
+<iframe src="about:blank"></iframe>
+<img src="https://mc.yandex.ru/watch/1" style="position:absolute; left:-9999px; width: 1px; height: 1px">
+<img src="//counter.yadro.ru/hit" width="1" height="1">
+<img src="https://cdn.kg/photo.jpg" width="300" height="200">
+<img src="https://cdn.kg/logo.png">
+<img src="https://img.cdn.kg/styled.png" style="margin: 5px; border: 1px solid">
+<img src="https://wide.cdn.kg/line.png" width="1" height="300">
+<img src="https://fluid.cdn.kg/full.png" width="100%" height="1">
+</body></html>`))
+	assert.NoError(t, err)
+	pageURL, _ := url.Parse("http://www.site.kg/news")
+	assert.Equal(t, []string{
+		"pagead2.googlesyndication.com",
+		"mc.yandex.ru",
+		"an.yandex.ru",
+		"counter.yadro.ru",
+	}, PageEmbeds(doc, pageURL))
+}
+
+func TestNetworkPresences(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	networks := []Network{
+		{Name: "Google AdSense", Category: "ads", Hosts: []string{"googlesyndication.com", "doubleclick.net"}},
+		{Name: "Yandex.Metrica", Category: "counter", Hosts: []string{"mc.yandex.ru"}},
+	}
+	embeds := map[string]map[string]int{
+		"a.kg": {"pagead2.googlesyndication.com": 3, "googleads.g.doubleclick.net": 5, "unknown.kg": 10},
+		"b.kg": {"mc.yandex.ru": 1},
+		"c.kg": {"unknown.kg": 1},
+	}
+	presences := NetworkPresences(networks, embeds, map[string]int{"a.kg": 10, "b.kg": 2})
+	assert.Equal(t, []NetworkPresence{
+		{SourceHost: "a.kg", Network: "Google AdSense", Category: "ads",
+			Hosts: []string{"googleads.g.doubleclick.net", "pagead2.googlesyndication.com"}, Pages: 5, PagesCrawled: 10},
+		{SourceHost: "b.kg", Network: "Yandex.Metrica", Category: "counter", Hosts: []string{"mc.yandex.ru"}, Pages: 1, PagesCrawled: 2},
+	}, presences)
+	assert.Equal(t, "Networks: 2 on 2 sites", FormatNetworksReport(presences))
+
+	assert.NoError(t, SaveNetworkPresences(DBFilepath, 1, presences))
+	saved, err := GetNetworkPresences(DBFilepath, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(saved))
+	assert.Equal(t, int64(1), saved[0].RunID)
+	assert.Equal(t, presences[0].Hosts, saved[0].Hosts)
+	assert.Equal(t, 5, saved[0].Pages)
+
+	day := saved[0].Created[:10]
+	saved, _ = GetNetworkPresences(DBFilepath, day, day)
+	assert.Equal(t, 2, len(saved))
+	saved, _ = GetNetworkPresences(DBFilepath, "2000-01-01", "2000-01-31")
+	assert.Equal(t, 0, len(saved))
+}
@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+)
+
+// Page is what we remember about a crawled page to skip it on the next run
+// if it has not changed: the validators for the conditional request, the hash
+// of the content, the outbound links found on it with their info, the
+// third-party hosts embedded on it and the internal links the crawler
+// needs to go further
+type Page struct {
+	URL           string
+	SourceHost    string
+	ETag          string
+	LastModified  string
+	ContentHash   string
+	Links         map[string]int
+	LinksInfo     map[string]LinkInfo
+	Embeds        []string
+	InternalLinks []string
+}
+
+// SavePages replaces the stored pages with the new versions in one transaction
+func SavePages(dbFilepath string, pages []Page) error {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	stmt, err := tx.Prepare("insert or replace into pages(url, source_host, etag, last_modified, content_hash, links, links_info, embeds, internal_links, updated) " +
+		"values(?, ?, ?, ?, ?, ?, ?, ?, ?, DateTime('now'))")
+	if err != nil {
+		log.Print(err)
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, page := range pages {
+		links, _ := json.Marshal(page.Links)
+		linksInfo, _ := json.Marshal(page.LinksInfo)
+		embeds, _ := json.Marshal(page.Embeds)
+		internalLinks, _ := json.Marshal(page.InternalLinks)
+		_, err = stmt.Exec(page.URL, page.SourceHost, page.ETag, page.LastModified, page.ContentHash, string(links), string(linksInfo), string(embeds), string(internalLinks))
+		if err != nil {
+			log.Print(err)
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetPages returns all the stored pages by url
+func GetPages(dbFilepath string) (map[string]Page, error) {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT url, source_host, etag, last_modified, content_hash, links, coalesce(links_info,''), coalesce(embeds,''), internal_links FROM pages")
+	if err != nil {
+		log.Printf("Error getting pages: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	pages := make(map[string]Page)
+	for rows.Next() {
+		var page Page
+		var links, linksInfo, embeds, internalLinks string
+		err = rows.Scan(&page.URL, &page.SourceHost, &page.ETag, &page.LastModified, &page.ContentHash, &links, &linksInfo, &embeds, &internalLinks)
+		if err != nil {
+			log.Printf("Error getting pages: %v", err)
+			continue
+		}
+		json.Unmarshal([]byte(links), &page.Links)
+		json.Unmarshal([]byte(linksInfo), &page.LinksInfo)
+		json.Unmarshal([]byte(embeds), &page.Embeds)
+		json.Unmarshal([]byte(internalLinks), &page.InternalLinks)
+		pages[page.URL] = page
+	}
+	return pages, nil
+}
@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveAndGetPages(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	pages, err := GetPages(DBFilepath)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(pages))
+
+	err = SavePages(DBFilepath, []Page{
+		{
+			URL:           "http://a.kg/",
+			SourceHost:    "a.kg",
+			ETag:          `"abc"`,
+			LastModified:  "Mon, 02 Jan 2017 15:04:05 GMT",
+			ContentHash:   ContentHash([]byte("page")),
+			Links:         map[string]int{"http://b.kg/": 2},
+			LinksInfo:     map[string]LinkInfo{"http://b.kg/": {Region: "article", Placement: PlacementContent, Pages: 1}},
+			Embeds:        []string{"mc.yandex.ru"},
+			InternalLinks: []string{"http://a.kg/news"},
+		},
+		{URL: "http://a.kg/news", SourceHost: "a.kg"},
+	})
+	assert.NoError(t, err)
+
+	pages, err = GetPages(DBFilepath)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(pages))
+	assert.Equal(t, `"abc"`, pages["http://a.kg/"].ETag)
+	assert.Equal(t, 2, pages["http://a.kg/"].Links["http://b.kg/"])
+	assert.Equal(t, LinkInfo{Region: "article", Placement: PlacementContent, Pages: 1}, pages["http://a.kg/"].LinksInfo["http://b.kg/"])
+	assert.Equal(t, []string{"mc.yandex.ru"}, pages["http://a.kg/"].Embeds)
+	assert.Equal(t, []string{"http://a.kg/news"}, pages["http://a.kg/"].InternalLinks)
+	assert.Equal(t, 0, len(pages["http://a.kg/news"].Links))
+
+	err = SavePages(DBFilepath, []Page{{URL: "http://a.kg/", SourceHost: "a.kg", ETag: `"def"`}})
+	assert.NoError(t, err)
+
+	pages, err = GetPages(DBFilepath)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(pages))
+	assert.Equal(t, `"def"`, pages["http://a.kg/"].ETag)
+}
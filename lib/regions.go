@@ -0,0 +1,43 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+)
+
+// EachRegionAnchor calls fn for every anchor of the page inside one of the
+// include selectors (the whole page if there are none) and outside all the
+// exclude selectors. The region is the include selector the anchor was found
+// in, the first one if there are several, and empty for the whole page
+func EachRegionAnchor(doc *goquery.Document, include []string, exclude []string, fn func(region string, s *goquery.Selection)) {
+	excluded := strings.Join(exclude, ", ")
+	doc.Find("a").Each(func(i int, s *goquery.Selection) {
+		if excluded != "" && s.Closest(excluded).Length() > 0 {
+			return
+		}
+		if len(include) == 0 {
+			fn("", s)
+			return
+		}
+		for _, selector := range include {
+			if s.Closest(selector).Length() > 0 {
+				fn(selector, s)
+				return
+			}
+		}
+	})
+}
+
+// checkSelectors returns the error for the first selector which can not be parsed,
+// goquery would silently find nothing with it
+func checkSelectors(selectors []string) error {
+	for _, selector := range selectors {
+		if _, err := cascadia.Compile(selector); err != nil {
+			return fmt.Errorf("bad selector %q: %v", selector, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+)
+
+const regionsPage = `<html><body>
+<div class="menu"><a href="http://menu.kg/">menu</a></div>
+<article>
+	<div class="content">
+		<a href="http://partner.kg/">partner</a>
+		<div class="menu"><a href="http://inner-menu.kg/">menu</a></div>
+	</div>
+	<a href="http://article.kg/">article</a>
+</article>
+<aside><a href="http://banner.kg/">banner</a></aside>
+<footer><a href="http://footer.kg/">footer</a></footer>
+</body></html>`
+
+func regionAnchors(t *testing.T, include []string, exclude []string) map[string]string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(regionsPage))
+	assert.NoError(t, err)
+	anchors := make(map[string]string)
+	EachRegionAnchor(doc, include, exclude, func(region string, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		anchors[href] = region
+	})
+	return anchors
+}
+
+func TestEachRegionAnchor(t *testing.T) {
+	anchors := regionAnchors(t, nil, nil)
+	assert.Equal(t, 6, len(anchors))
+	assert.Equal(t, "", anchors["http://footer.kg/"])
+
+	anchors = regionAnchors(t, nil, []string{"footer, .menu"})
+	assert.Equal(t, map[string]string{"http://partner.kg/": "", "http://article.kg/": "", "http://banner.kg/": ""}, anchors)
+
+	anchors = regionAnchors(t, []string{"article .content", "article", "aside"}, []string{"footer", ".menu"})
+	assert.Equal(t, map[string]string{
+		"http://partner.kg/": "article .content",
+		"http://article.kg/": "article",
+		"http://banner.kg/":  "aside",
+	}, anchors)
+}
+
+func TestCheckSelectors(t *testing.T) {
+	assert.NoError(t, checkSelectors([]string{"article .content", "footer, .menu"}))
+	assert.Error(t, checkSelectors([]string{"article", "div["}))
+}
@@ -0,0 +1,309 @@
+package lib
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+)
+
+const (
+	RunRequested   = "Crawl requested"
+	RunStarted     = "Crawl started and crawling"
+	RunResumed     = "Crawl resumed and crawling"
+	RunDone        = "Crawl done"
+	RunInterrupted = "Crawl interrupted, partial results saved"
+	RunCanceled    = "Crawl canceled, partial results saved"
+	RunAbandoned   = "Crawl abandoned"
+	RunFailed      = "Crawl failed"
+)
+
+// Run is one crawl of all the hosts (or the requested Sites only),
+// from the start to saving the results
+type Run struct {
+	ID              int64
+	Started         string
+	Finished        string
+	Status          string
+	Report          string
+	Resumed         int
+	Sites           []string
+	CancelRequested bool
+	RunProgress
+}
+
+// RunProgress is saved by the crawler while the run goes, so the API can show it
+type RunProgress struct {
+	HostsTotal    int
+	HostsDone     int
+	PagesVisited  int
+	LinksFound    int
+	LinksResolved int
+	LinksFailed   int
+}
+
+const runColumns = "id, started, coalesce(finished, ''), status, report, resumed, sites, cancel_requested, " +
+	"hosts_total, hosts_done, pages_visited, links_found, links_resolved, links_failed"
+
+func scanRun(rows *sql.Rows) (Run, error) {
+	r := Run{}
+	var sites string
+	err := rows.Scan(&r.ID, &r.Started, &r.Finished, &r.Status, &r.Report, &r.Resumed, &sites, &r.CancelRequested,
+		&r.HostsTotal, &r.HostsDone, &r.PagesVisited, &r.LinksFound, &r.LinksResolved, &r.LinksFailed)
+	if sites != "" {
+		r.Sites = strings.Split(sites, ",")
+	}
+	return r, err
+}
+
+func StartRun(dbFilepath string) (int64, error) {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return 0, err
+	}
+	defer db.Close()
+
+	res, err := db.Exec("insert into runs(started, status, report) values(DateTime('now'), ?, '')", RunStarted)
+	if err != nil {
+		log.Print(err)
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// RequestRun asks the crawler process to start a run, optionally for some
+// of the sites only. The crawler picks it up and calls StartRequestedRun
+func RequestRun(dbFilepath string, sites []string) (int64, error) {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return 0, err
+	}
+	defer db.Close()
+
+	res, err := db.Exec("insert into runs(started, status, report, sites) values(DateTime('now'), ?, '', ?)",
+		RunRequested, strings.Join(sites, ","))
+	if err != nil {
+		log.Print(err)
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// UnknownSites returns the requested sites which are not in the sites list
+// of the crawler, the one saved in the types table
+func UnknownSites(dbFilepath string, sites []string) ([]string, error) {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	defer db.Close()
+
+	var unknown []string
+	for _, site := range sites {
+		var count int
+		err = db.QueryRow("SELECT count(*) FROM types WHERE lower(hostname)=lower(?)", strings.TrimSpace(site)).Scan(&count)
+		if err != nil {
+			log.Printf("Error getting the sites: %v", err)
+			return nil, err
+		}
+		if count == 0 {
+			unknown = append(unknown, site)
+		}
+	}
+	return unknown, nil
+}
+
+func StartRequestedRun(dbFilepath string, id int64) error {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("UPDATE runs SET started=DateTime('now'), status=? WHERE id=?", RunStarted, id)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+// RequestRunCancel asks the crawler to stop the run. The run which was not
+// started yet is canceled at once. False is returned if there is no such
+// unfinished run
+func RequestRunCancel(dbFilepath string, id int64) (bool, error) {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return false, err
+	}
+	defer db.Close()
+
+	res, err := db.Exec("UPDATE runs SET cancel_requested=1 WHERE id=? AND finished IS NULL", id)
+	if err != nil {
+		log.Print(err)
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil || affected == 0 {
+		return false, err
+	}
+
+	_, err = db.Exec("UPDATE runs SET finished=DateTime('now'), status=? WHERE id=? AND status=?", RunCanceled, id, RunRequested)
+	if err != nil {
+		log.Print(err)
+		return false, err
+	}
+	return true, nil
+}
+
+func SaveRunProgress(dbFilepath string, id int64, progress RunProgress) error {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("UPDATE runs SET hosts_total=?, hosts_done=?, pages_visited=?, links_found=?, links_resolved=?, links_failed=? WHERE id=?",
+		progress.HostsTotal, progress.HostsDone, progress.PagesVisited, progress.LinksFound, progress.LinksResolved, progress.LinksFailed, id)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+func FinishRun(dbFilepath string, id int64, status string, report string) error {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("UPDATE runs SET finished=DateTime('now'), status=?, report=? WHERE id=?", status, report, id)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+// ResumeRun marks the run as resumed, the run keeps its id and its checkpoint
+func ResumeRun(dbFilepath string, id int64) error {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("UPDATE runs SET resumed=resumed+1, status=?, finished=NULL WHERE id=?", RunResumed, id)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+func SetRunStatus(dbFilepath string, id int64, status string) error {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("UPDATE runs SET status=? WHERE id=?", status, id)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+// GetUnfinishedRun returns the latest run which was started, but never finished,
+// e.g. the process died or was stopped in the middle of the crawl, or the run
+// requested through the API. The run which failed to start, e.g. because of
+// the broken config, is tried again with its checkpoint
+func GetUnfinishedRun(dbFilepath string) (Run, bool, error) {
+	runs, err := GetLastRuns(dbFilepath, 1)
+	if err != nil {
+		return Run{}, false, err
+	}
+	if len(runs) == 0 || (runs[0].Finished != "" && runs[0].Status != RunInterrupted && runs[0].Status != RunFailed) {
+		return Run{}, false, nil
+	}
+	return runs[0], true, nil
+}
+
+func GetRun(dbFilepath string, id int64) (Run, bool, error) {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return Run{}, false, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT "+runColumns+" FROM runs WHERE id=?", id)
+	if err != nil {
+		log.Printf("Error getting the run: %v", err)
+		return Run{}, false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return Run{}, false, nil
+	}
+	r, err := scanRun(rows)
+	if err != nil {
+		log.Printf("Error getting the run: %v", err)
+		return Run{}, false, err
+	}
+	return r, true, nil
+}
+
+// GetLastRuns returns the latest runs first
+func GetLastRuns(dbFilepath string, limit int) ([]Run, error) {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT "+runColumns+" FROM runs ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		log.Printf("Error getting runs: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		r, err := scanRun(rows)
+		if err != nil {
+			log.Printf("Error getting runs: %v", err)
+			continue
+		}
+		runs = append(runs, r)
+	}
+	return runs, nil
+}
+
+// RunDay is the day the links of the run were saved on, the day it started
+// if it is not finished
+func RunDay(run Run) string {
+	day := run.Finished
+	if day == "" {
+		day = run.Started
+	}
+	if len(day) > 10 {
+		day = day[:10]
+	}
+	return day
+}
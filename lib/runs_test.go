@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuns(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	first, err := StartRun(DBFilepath)
+	assert.NoError(t, err)
+	err = FinishRun(DBFilepath, first, "Crawl done", "Skipped URLs: 0")
+	assert.NoError(t, err)
+
+	second, err := StartRun(DBFilepath)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	runs, err := GetLastRuns(DBFilepath, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(runs))
+	assert.Equal(t, second, runs[0].ID)
+	assert.Equal(t, "", runs[0].Finished)
+	assert.Equal(t, "Crawl started and crawling", runs[0].Status)
+	assert.Equal(t, "Crawl done", runs[1].Status)
+	assert.Equal(t, "Skipped URLs: 0", runs[1].Report)
+	assert.NotEqual(t, "", runs[1].Finished)
+}
+
+func TestResumeRun(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	_, unfinished, err := GetUnfinishedRun(DBFilepath)
+	assert.NoError(t, err)
+	assert.False(t, unfinished)
+
+	id, _ := StartRun(DBFilepath)
+	run, unfinished, err := GetUnfinishedRun(DBFilepath)
+	assert.NoError(t, err)
+	assert.True(t, unfinished)
+	assert.Equal(t, id, run.ID)
+
+	assert.NoError(t, ResumeRun(DBFilepath, id))
+	run, _, _ = GetUnfinishedRun(DBFilepath)
+	assert.Equal(t, 1, run.Resumed)
+	assert.Equal(t, "Crawl resumed and crawling", run.Status)
+
+	FinishRun(DBFilepath, id, "Crawl done", "")
+	_, unfinished, _ = GetUnfinishedRun(DBFilepath)
+	assert.False(t, unfinished)
+
+	// the run stopped by a signal is resumed too
+	id, _ = StartRun(DBFilepath)
+	FinishRun(DBFilepath, id, RunInterrupted, "")
+	run, unfinished, _ = GetUnfinishedRun(DBFilepath)
+	assert.True(t, unfinished)
+	assert.Equal(t, id, run.ID)
+	ResumeRun(DBFilepath, id)
+	run, _, _ = GetUnfinishedRun(DBFilepath)
+	assert.Equal(t, "", run.Finished)
+
+	// and the run which failed on the broken config
+	FinishRun(DBFilepath, id, RunFailed, "Error opening or parsing sites config")
+	run, unfinished, _ = GetUnfinishedRun(DBFilepath)
+	assert.True(t, unfinished)
+	assert.Equal(t, id, run.ID)
+}
+
+func TestRequestAndCancelRun(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	id, err := RequestRun(DBFilepath, []string{"a.kg", "b.kg"})
+	assert.NoError(t, err)
+	run, found, err := GetRun(DBFilepath, id)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, RunRequested, run.Status)
+	assert.Equal(t, []string{"a.kg", "b.kg"}, run.Sites)
+
+	canceled, err := RequestRunCancel(DBFilepath, id)
+	assert.NoError(t, err)
+	assert.True(t, canceled)
+	run, _, _ = GetRun(DBFilepath, id)
+	assert.Equal(t, RunCanceled, run.Status)
+	assert.NotEqual(t, "", run.Finished)
+
+	canceled, err = RequestRunCancel(DBFilepath, id)
+	assert.NoError(t, err)
+	assert.False(t, canceled)
+
+	_, found, err = GetRun(DBFilepath, id+100)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestStartedRunCancelAndProgress(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	id, _ := RequestRun(DBFilepath, nil)
+	assert.NoError(t, StartRequestedRun(DBFilepath, id))
+	assert.NoError(t, SaveRunProgress(DBFilepath, id, RunProgress{HostsTotal: 2, HostsDone: 1, PagesVisited: 10, LinksFound: 5}))
+
+	canceled, err := RequestRunCancel(DBFilepath, id)
+	assert.NoError(t, err)
+	assert.True(t, canceled)
+
+	run, _, _ := GetRun(DBFilepath, id)
+	assert.Equal(t, RunStarted, run.Status)
+	assert.Equal(t, "", run.Finished)
+	assert.True(t, run.CancelRequested)
+	assert.Equal(t, 0, len(run.Sites))
+	assert.Equal(t, 2, run.HostsTotal)
+	assert.Equal(t, 10, run.PagesVisited)
+}
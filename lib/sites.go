@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	SitesConfigFilepath        = "./sites.yml"
+	SitesConfigDefaultFilepath = "./sites.default.yml"
+)
+
+// SiteConfig is the crawl settings of one site from sites.yml, e.g.
+//
+//	nambataxi.kg:
+//	  proxies: [socks5://10.0.0.2:1080]
+//	  proxy-mode: sticky
+//	  headers:
+//	    X-Partner: spiderwoman
+//	  login:
+//	    url: http://nambataxi.kg/login
+//	    fields: {login: crawler, password: "${NAMBATAXI_PASSWORD}"}
+//	    success-cookie: session
+//	  include-selectors: [article .content]
+//	  exclude-selectors: [footer, .menu]
+//
+// The credentials are not written in the file, ${NAME} in the cookies,
+// headers, basic auth and login fields is taken from the environment
+// or from the secrets file. Only the outbound links inside the include
+// selectors and outside the exclude ones are collected, the crawler still
+// follows all the internal links of the page
+type SiteConfig struct {
+	Proxies   []string          `yaml:"proxies"`
+	ProxyMode string            `yaml:"proxy-mode"`
+	Cookies   map[string]string `yaml:"cookies"`
+	Headers   map[string]string `yaml:"headers"`
+	BasicAuth *BasicAuth        `yaml:"basic-auth"`
+	Login     *SiteLogin        `yaml:"login"`
+	Include   []string          `yaml:"include-selectors"`
+	Exclude   []string          `yaml:"exclude-selectors"`
+}
+
+// HasSession is true if the requests to the site need the cookies,
+// the headers or the login
+func (c SiteConfig) HasSession() bool {
+	return len(c.Cookies) > 0 || len(c.Headers) > 0 || c.BasicAuth != nil || c.Login != nil
+}
+
+// GetSitesConfig reads the settings of the sites by the host. There are no
+// settings if neither of the files exists
+func GetSitesConfig(realFile string, defaultFile string) (map[string]SiteConfig, error) {
+	sites := make(map[string]SiteConfig)
+	data, err := ioutil.ReadFile(realFile)
+	if os.IsNotExist(err) {
+		data, err = ioutil.ReadFile(defaultFile)
+	}
+	if os.IsNotExist(err) {
+		return sites, nil
+	}
+	if err != nil {
+		return sites, err
+	}
+	var parsed map[string]SiteConfig
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		return sites, err
+	}
+	for host, config := range parsed {
+		if err := checkSelectors(config.Include); err != nil {
+			return sites, fmt.Errorf("%v: %v", host, err)
+		}
+		if err := checkSelectors(config.Exclude); err != nil {
+			return sites, fmt.Errorf("%v: %v", host, err)
+		}
+		sites[strings.ToLower(host)] = config
+	}
+	return sites, nil
+}
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSitesConfig(t *testing.T) {
+	sites, err := GetSitesConfig("/tmp/no-such-sites.yml", "../sites.default.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(sites))
+
+	sites, err = GetSitesConfig("/tmp/no-such-sites.yml", "/tmp/no-such-sites.default.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(sites))
+
+	filepath := "/tmp/spiderwoman-sites.yml"
+	defer os.Remove(filepath)
+	ioutil.WriteFile(filepath, []byte(`
+Nambataxi.kg:
+  proxies:
+    - http://10.0.0.1:3128
+    - socks5://10.0.0.2:1080
+  proxy-mode: sticky
+  include-selectors: [article .content]
+  exclude-selectors: ["footer, .menu"]
+nambafood.kg: {}
+`), 0644)
+	sites, err = GetSitesConfig(filepath, "../sites.default.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(sites))
+	assert.Equal(t, []string{"http://10.0.0.1:3128", "socks5://10.0.0.2:1080"}, sites["nambataxi.kg"].Proxies)
+	assert.Equal(t, ProxySticky, sites["nambataxi.kg"].ProxyMode)
+	assert.Equal(t, []string{"article .content"}, sites["nambataxi.kg"].Include)
+	assert.Equal(t, []string{"footer, .menu"}, sites["nambataxi.kg"].Exclude)
+
+	ioutil.WriteFile(filepath, []byte("nambataxi.kg:\n  exclude-selectors: [\"div[\"]\n"), 0644)
+	_, err = GetSitesConfig(filepath, "../sites.default.yml")
+	assert.Error(t, err)
+
+	ioutil.WriteFile(filepath, []byte("nambataxi.kg: [\n"), 0644)
+	_, err = GetSitesConfig(filepath, "../sites.default.yml")
+	assert.Error(t, err)
+}
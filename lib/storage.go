@@ -9,7 +9,14 @@ import (
 	"fmt"
 )
 
+// Monitor is the link of the source host saved by the run RunID, it is 0 for
+// the links saved before the runs were recorded. Count is the number
+// of the anchors with the link on the crawled pages, Pages is the number of
+// distinct pages with the link out of PagesCrawled. Hidden is the reason
+// the link is not visible on the page, empty for the visible links. WatchDomain
+// is the domain of the watchlist the external host belongs to
 type Monitor struct {
+	RunID int64
 	SourceHost string
 	ExternalLink string
 	Count int
@@ -17,6 +24,28 @@ type Monitor struct {
 	Created string
 	SourceHostType string
 	ExternalHostType string
+	Region string
+	Placement string
+	Pages int
+	PagesCrawled int
+	PagesRatio float64
+	BannerImage string
+	BannerWidth int
+	BannerHeight int
+	BannerAlt string
+	BannerSize string
+	Hidden string
+	WatchDomain string
+	WatchLabel string
+}
+
+type FilteredLink struct {
+	SourceHost string
+	Link string
+	Reason string
+	ContentType string
+	Count int
+	Created string
 }
 
 func CreateDBIfNotExists(dbFilepath string) {
@@ -33,8 +62,22 @@ func CreateDBIfNotExists(dbFilepath string) {
 		external_link text,
 		count int,
 		external_host text,
-		created date
+		created date,
+		region text default '',
+		placement text default '',
+		pages int default 0,
+		pages_crawled int default 0,
+		banner_image text default '',
+		banner_width int default 0,
+		banner_height int default 0,
+		banner_alt text default '',
+		banner_size text default '',
+		hidden text default '',
+		watch_domain text default '',
+		watch_label text default '',
+		run_id integer default 0
 	);
+	create index if not exists monitor_link_created on monitor(source_host, external_link, created);
 	create table if not exists status (
 		id integer not null primary key,
 		status_key text,
@@ -47,15 +90,323 @@ func CreateDBIfNotExists(dbFilepath string) {
 		hosttype text,
 		CONSTRAINT hostname_uniq UNIQUE (hostname)
 	);
+	create table if not exists runs (
+		id integer not null primary key,
+		started date,
+		finished date,
+		status text,
+		report text,
+		resumed int default 0,
+		sites text default '',
+		cancel_requested int default 0,
+		hosts_total int default 0,
+		hosts_done int default 0,
+		pages_visited int default 0,
+		links_found int default 0,
+		links_resolved int default 0,
+		links_failed int default 0
+	);
+	create table if not exists checkpoint_hosts (
+		id integer not null primary key,
+		run_id integer,
+		host text,
+		state text,
+		CONSTRAINT checkpoint_host_uniq UNIQUE (run_id, host)
+	);
+	create table if not exists checkpoint_urls (
+		id integer not null primary key,
+		run_id integer,
+		host text,
+		url text,
+		state text,
+		CONSTRAINT checkpoint_url_uniq UNIQUE (run_id, url)
+	);
+	create table if not exists checkpoint_links (
+		id integer not null primary key,
+		run_id integer,
+		host text,
+		href text,
+		count int,
+		region text default '',
+		placement text default '',
+		pages int default 0,
+		banner text default '',
+		hidden text default '',
+		CONSTRAINT checkpoint_link_uniq UNIQUE (run_id, host, href)
+	);
+	create table if not exists checkpoint_link_pages (
+		id integer not null primary key,
+		run_id integer,
+		host text,
+		href text,
+		page text,
+		CONSTRAINT checkpoint_link_page_uniq UNIQUE (run_id, host, href, page)
+	);
+	create table if not exists checkpoint_pages (
+		id integer not null primary key,
+		run_id integer,
+		host text,
+		pages int,
+		CONSTRAINT checkpoint_pages_uniq UNIQUE (run_id, host)
+	);
+	create table if not exists checkpoint_embeds (
+		id integer not null primary key,
+		run_id integer,
+		host text,
+		embed_host text,
+		pages int,
+		CONSTRAINT checkpoint_embed_uniq UNIQUE (run_id, host, embed_host)
+	);
+	create table if not exists checkpoint_resolutions (
+		id integer not null primary key,
+		run_id integer,
+		host text,
+		url text,
+		times int,
+		resolved text,
+		content_type text,
+		state text,
+		reason text default '',
+		CONSTRAINT checkpoint_resolution_uniq UNIQUE (run_id, host, url)
+	);
+	create table if not exists checkpoint_counters (
+		id integer not null primary key,
+		run_id integer,
+		name text,
+		value int,
+		CONSTRAINT checkpoint_counter_uniq UNIQUE (run_id, name)
+	);
+	create table if not exists pages (
+		id integer not null primary key,
+		url text,
+		source_host text,
+		etag text,
+		last_modified text,
+		content_hash text,
+		links text,
+		internal_links text,
+		links_info text default '',
+		embeds text default '',
+		updated date,
+		CONSTRAINT url_uniq UNIQUE (url)
+	);
+	create table if not exists filtered (
+		id integer not null primary key,
+		run_id integer default 0,
+		source_host text,
+		link text,
+		reason text,
+		content_type text,
+		count int,
+		created date
+	);
+	create table if not exists jobs (
+		id integer not null primary key,
+		run_id integer,
+		kind text,
+		host text,
+		payload text,
+		state text,
+		worker text,
+		lease_until integer,
+		attempts int,
+		result text,
+		error text
+	);
+	create table if not exists networks (
+		id integer not null primary key,
+		run_id integer,
+		source_host text,
+		network text,
+		category text,
+		hosts text,
+		pages int,
+		pages_crawled int,
+		created date,
+		CONSTRAINT networks_uniq UNIQUE (run_id, source_host, network)
+	);
+	create table if not exists link_lifetime (
+		id integer not null primary key,
+		source_host text,
+		external_link text,
+		external_host text,
+		first_seen date,
+		last_seen date,
+		gone date,
+		first_run_id integer,
+		last_run_id integer,
+		runs int default 0,
+		state text,
+		CONSTRAINT link_lifetime_uniq UNIQUE (source_host, external_link)
+	);
+	create table if not exists proxy_stats (
+		id integer not null primary key,
+		run_id integer,
+		scope text,
+		proxy text,
+		requests int,
+		errors int,
+		evictions int,
+		out_of_rotation int,
+		CONSTRAINT proxy_stats_uniq UNIQUE (run_id, scope, proxy)
+	);
+	create table if not exists events (
+		id integer not null primary key,
+		run_id integer,
+		kind text,
+		data text,
+		created date
+	);
 	`
 	_, err = db.Exec(sqlStmt)
 	if err != nil {
 		log.Printf("%q: %s\n", err, sqlStmt)
 		return
 	}
+	migrateDB(db)
+	backfillLinkLifetimes(db)
+}
+
+// columns added after the tables were created, the old databases get them on start
+var migrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"runs", "resumed", "int default 0"},
+	{"runs", "sites", "text default ''"},
+	{"runs", "cancel_requested", "int default 0"},
+	{"runs", "hosts_total", "int default 0"},
+	{"runs", "hosts_done", "int default 0"},
+	{"runs", "pages_visited", "int default 0"},
+	{"runs", "links_found", "int default 0"},
+	{"runs", "links_resolved", "int default 0"},
+	{"runs", "links_failed", "int default 0"},
+	{"monitor", "region", "text default ''"},
+	{"monitor", "placement", "text default ''"},
+	{"monitor", "pages", "int default 0"},
+	{"monitor", "pages_crawled", "int default 0"},
+	{"monitor", "banner_image", "text default ''"},
+	{"monitor", "banner_width", "int default 0"},
+	{"monitor", "banner_height", "int default 0"},
+	{"monitor", "banner_alt", "text default ''"},
+	{"monitor", "banner_size", "text default ''"},
+	{"monitor", "hidden", "text default ''"},
+	{"monitor", "watch_domain", "text default ''"},
+	{"monitor", "watch_label", "text default ''"},
+	{"monitor", "run_id", "integer default 0"},
+	{"filtered", "run_id", "integer default 0"},
+	{"checkpoint_links", "region", "text default ''"},
+	{"checkpoint_links", "placement", "text default ''"},
+	{"checkpoint_links", "pages", "int default 0"},
+	{"checkpoint_links", "banner", "text default ''"},
+	{"checkpoint_links", "hidden", "text default ''"},
+	{"checkpoint_resolutions", "reason", "text default ''"},
+	{"pages", "links_info", "text default ''"},
+	{"pages", "embeds", "text default ''"},
+}
+
+func migrateDB(db *sql.DB) {
+	for _, m := range migrations {
+		rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s);", m.table))
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		exists := false
+		for rows.Next() {
+			var cid, notNull, pk int
+			var name, columnType string
+			var defaultValue sql.NullString
+			if rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk) == nil && name == m.column {
+				exists = true
+			}
+		}
+		rows.Close()
+		if exists {
+			continue
+		}
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", m.table, m.column, m.definition))
+		if err != nil {
+			log.Printf("Error adding column %s to %s: %v", m.column, m.table, err)
+		}
+	}
 }
 
 func SaveRecordToMonitor(dbFilepath string, source_host string, external_link string, count int, external_host string) bool {
+	return SaveRecordToMonitorWithInfo(dbFilepath, source_host, external_link, count, external_host, LinkInfo{})
+}
+
+// SaveRecordToMonitorWithInfo works like SaveRecordToMonitor, but also saves
+// where on the pages of the source host the link was found
+func SaveRecordToMonitorWithInfo(dbFilepath string, source_host string, external_link string, count int, external_host string, info LinkInfo) bool {
+	return SaveRunRecordToMonitor(dbFilepath, 0, source_host, external_link, count, external_host, info)
+}
+
+// SaveRunRecordToMonitor works like SaveRecordToMonitorWithInfo for the link
+// found by the run
+func SaveRunRecordToMonitor(dbFilepath string, runID int64, source_host string, external_link string, count int, external_host string, info LinkInfo) bool {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Fatal(err)
+		return false
+	}
+	defer db.Close()
+
+	stmt, err := db.Prepare("insert into monitor(source_host, external_link, count, external_host, created, region, placement, pages, pages_crawled, " +
+		"banner_image, banner_width, banner_height, banner_alt, banner_size, hidden, watch_domain, watch_label, run_id) " +
+		"values(?, ?, ?, ?, DateTime('now'), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	bannerImage, bannerWidth, bannerHeight, bannerAlt, bannerSize := bannerColumns(info.Banner)
+	watchDomain, watchLabel := watchColumns(info.Watched)
+	_, err = stmt.Exec(source_host, external_link, count, external_host, info.Region, info.Placement, info.Pages, info.PagesCrawled,
+		bannerImage, bannerWidth, bannerHeight, bannerAlt, bannerSize, info.Hidden, watchDomain, watchLabel, runID)
+	if err != nil {
+		log.Fatal(err)
+		return false
+	}
+	return true
+
+}
+
+// DeleteRunFromMonitor deletes the links saved by the run
+func DeleteRunFromMonitor(dbFilepath string, runID int64) bool {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	defer db.Close()
+
+	_, err = db.Exec("DELETE FROM monitor WHERE run_id=?", runID)
+	if err != nil {
+		log.Printf("Error deleting the links of the run: %v", err)
+		return false
+	}
+	return true
+}
+
+// DeleteRunFromFiltered deletes the links filtered out by the run
+func DeleteRunFromFiltered(dbFilepath string, runID int64) bool {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	defer db.Close()
+
+	_, err = db.Exec("DELETE FROM filtered WHERE run_id=?", runID)
+	if err != nil {
+		log.Printf("Error deleting the filtered links of the run: %v", err)
+		return false
+	}
+	return true
+}
+
+func SaveFilteredLink(dbFilepath string, runID int64, link FilteredLink) bool {
 	db, err := sql.Open("sqlite3", dbFilepath)
 	if err != nil {
 		log.Fatal(err)
@@ -63,18 +414,43 @@ func SaveRecordToMonitor(dbFilepath string, source_host string, external_link st
 	}
 	defer db.Close()
 
-	stmt, err := db.Prepare("insert into monitor(source_host, external_link, count, external_host, created) values(?, ?, ?, ?, DateTime('now'))")
+	stmt, err := db.Prepare("insert into filtered(run_id, source_host, link, reason, content_type, count, created) values(?, ?, ?, ?, ?, ?, DateTime('now'))")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	_, err = stmt.Exec(source_host, external_link, count, external_host)
+	_, err = stmt.Exec(runID, link.SourceHost, link.Link, link.Reason, link.ContentType, link.Count)
 	if err != nil {
 		log.Fatal(err)
 		return false
 	}
 	return true
+}
 
+func GetFilteredLinksByDay(dbFilepath string, day string) ([]FilteredLink, error) {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Fatal(err)
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT source_host, link, reason, content_type, count, created FROM filtered " +
+		"WHERE created >= ? AND created <= date(?, '+1 day');", day, day)
+	if err != nil {
+		log.Printf("Error getting data from filtered: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var data []FilteredLink
+	for rows.Next() {
+		f := FilteredLink{}
+		err = rows.Scan(&f.SourceHost, &f.Link, &f.Reason, &f.ContentType, &f.Count, &f.Created)
+		data = append(data, f)
+	}
+
+	return data, nil
 }
 
 func GetAllDataFromMonitor(dbFilepath string, count int) ([]Monitor, error) {
@@ -84,9 +460,12 @@ func GetAllDataFromMonitor(dbFilepath string, count int) ([]Monitor, error) {
 		return nil, err
 	}
 	defer db.Close()
-	rows, err := db.Query(fmt.Sprintf("SELECT m.source_host, m.external_link, m.count, m.external_host, m.created, " +
+	rows, err := db.Query(fmt.Sprintf("SELECT coalesce(m.run_id,0), m.source_host, m.external_link, m.count, m.external_host, m.created, " +
 		"coalesce(t1.hosttype,'H') as 'source_host_type', " +
-		"coalesce(t2.hosttype,'H') as 'external_host_type' " +
+		"coalesce(t2.hosttype,'H') as 'external_host_type', " +
+		"coalesce(m.region,''), coalesce(m.placement,''), coalesce(m.pages,0), coalesce(m.pages_crawled,0), " +
+		"coalesce(m.banner_image,''), coalesce(m.banner_width,0), coalesce(m.banner_height,0), coalesce(m.banner_alt,''), coalesce(m.banner_size,''), coalesce(m.hidden,''), " +
+		"coalesce(m.watch_domain,''), coalesce(m.watch_label,'') " +
 		"FROM monitor as m " +
 		"LEFT OUTER JOIN types as t1 ON t1.hostname=m.source_host " +
 		"LEFT OUTER JOIN types as t2 ON t2.hostname=m.external_host " +
@@ -100,7 +479,10 @@ func GetAllDataFromMonitor(dbFilepath string, count int) ([]Monitor, error) {
 	var data []Monitor
 	for rows.Next() {
 		m := Monitor{}
-		err = rows.Scan(&m.SourceHost, &m.ExternalLink, &m.Count, &m.ExternalHost, &m.Created, &m.SourceHostType, &m.ExternalHostType)
+		err = rows.Scan(&m.RunID, &m.SourceHost, &m.ExternalLink, &m.Count, &m.ExternalHost, &m.Created, &m.SourceHostType, &m.ExternalHostType, &m.Region,
+			&m.Placement, &m.Pages, &m.PagesCrawled, &m.BannerImage, &m.BannerWidth, &m.BannerHeight, &m.BannerAlt, &m.BannerSize, &m.Hidden,
+			&m.WatchDomain, &m.WatchLabel)
+		m.PagesRatio = pagesRatio(m.Pages, m.PagesCrawled)
 		data = append(data, m)
 	}
 
@@ -108,22 +490,30 @@ func GetAllDataFromMonitor(dbFilepath string, count int) ([]Monitor, error) {
 }
 
 func GetAllDataFromMonitorByDay(dbFilepath string, day string) ([]Monitor, error) {
-	db, err := sql.Open("sqlite3", dbFilepath) // TODO: need to remove duplicates
+	return queryMonitor(dbFilepath, "m.created >= ? AND m.created <= date(?, '+1 day')", day, day)
+}
+
+// queryMonitor returns the links of the monitor as m with the types of the hosts
+func queryMonitor(dbFilepath string, where string, args ...interface{}) ([]Monitor, error) {
+	db, err := sql.Open("sqlite3", dbFilepath)
 	if err != nil {
-		log.Fatal(err)
+		log.Print(err)
 		return nil, err
 	}
 	defer db.Close()
 
-	query := fmt.Sprintf("SELECT m.source_host, m.external_link, m.count, m.external_host, m.created, " +
+	query := "SELECT coalesce(m.run_id,0), m.source_host, m.external_link, m.count, m.external_host, m.created, " +
 		"coalesce(t1.hosttype,'H') as 'source_host_type', " +
-		"coalesce(t2.hosttype,'H') as 'external_host_type' " +
+		"coalesce(t2.hosttype,'H') as 'external_host_type', " +
+		"coalesce(m.region,''), coalesce(m.placement,''), coalesce(m.pages,0), coalesce(m.pages_crawled,0), " +
+		"coalesce(m.banner_image,''), coalesce(m.banner_width,0), coalesce(m.banner_height,0), coalesce(m.banner_alt,''), coalesce(m.banner_size,''), coalesce(m.hidden,''), " +
+		"coalesce(m.watch_domain,''), coalesce(m.watch_label,'') " +
 		"FROM monitor as m " +
 		"LEFT OUTER JOIN types as t1 ON t1.hostname=m.source_host " +
 		"LEFT OUTER JOIN types as t2 ON t2.hostname=m.external_host " +
-		"WHERE m.created >= '%s' AND m.created <= date('%s', '+1 day');", day, day)
+		"WHERE " + where
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, args...)
 
 	if err != nil {
 		log.Printf("Error getting data from monitor: %v", err)
@@ -134,13 +524,24 @@ func GetAllDataFromMonitorByDay(dbFilepath string, day string) ([]Monitor, error
 	var data []Monitor
 	for rows.Next() {
 		m := Monitor{}
-		err = rows.Scan(&m.SourceHost, &m.ExternalLink, &m.Count, &m.ExternalHost, &m.Created, &m.SourceHostType, &m.ExternalHostType)
+		err = rows.Scan(&m.RunID, &m.SourceHost, &m.ExternalLink, &m.Count, &m.ExternalHost, &m.Created, &m.SourceHostType, &m.ExternalHostType, &m.Region,
+			&m.Placement, &m.Pages, &m.PagesCrawled, &m.BannerImage, &m.BannerWidth, &m.BannerHeight, &m.BannerAlt, &m.BannerSize, &m.Hidden,
+			&m.WatchDomain, &m.WatchLabel)
+		m.PagesRatio = pagesRatio(m.Pages, m.PagesCrawled)
 		data = append(data, m)
 	}
 
 	return data, nil
 }
 
+// pagesRatio is the share of the crawled pages of the source host with the link
+func pagesRatio(pages int, pagesCrawled int) float64 {
+	if pagesCrawled == 0 {
+		return 0
+	}
+	return float64(pages) / float64(pagesCrawled)
+}
+
 func SetCrawlStatus(dbFilepath string, status string) bool {
 	db, err := sql.Open("sqlite3", dbFilepath)
 	if err != nil {
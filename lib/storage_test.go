@@ -153,7 +153,8 @@ func TestGetAllDataFromSqlite_MapToStruct(t *testing.T) {
 	externalLink = "http://b/1?10"
 	count = 810
 	externalHost = "host3"
-	_ = SaveRecordToMonitor(DBFilepath, sourceHost, externalLink, count, externalHost)
+	_ = SaveRecordToMonitorWithInfo(DBFilepath, sourceHost, externalLink, count, externalHost,
+		LinkInfo{Region: "article", Placement: PlacementSitewide, Pages: 4, PagesCrawled: 5})
 
 	monitors, err := GetAllDataFromMonitor(DBFilepath, 9)
 	assert.NoError(t, err)
@@ -167,6 +168,13 @@ func TestGetAllDataFromSqlite_MapToStruct(t *testing.T) {
 	assert.Equal(t, "type1", monitors[10].ExternalHostType)
 	assert.Equal(t, "H", monitors[11].SourceHostType)
 	assert.Equal(t, "H", monitors[11].ExternalHostType)
+	assert.Equal(t, "", monitors[10].Region)
+	assert.Equal(t, "article", monitors[11].Region)
+	assert.Equal(t, PlacementSitewide, monitors[11].Placement)
+	assert.Equal(t, 4, monitors[11].Pages)
+	assert.Equal(t, 5, monitors[11].PagesCrawled)
+	assert.Equal(t, 0.8, monitors[11].PagesRatio)
+	assert.Equal(t, 0.0, monitors[10].PagesRatio)
 
 	//for _, m := range monitors {
 	//	log.Printf("[%v] [%v] %v %v %v", m.SourceHostType, m.ExternalHostType, m.Created, m.ExternalHost, m.SourceHost)
@@ -210,6 +218,10 @@ func TestGetDataFromMonitor__ByDays(t *testing.T) {
 	monitors, err = GetAllDataFromMonitorByDay(DBFilepath, "1812-01-01")
 	assert.NoError(t, err)
 	assert.Equal(t, len(monitors), 0)
+
+	monitors, err = GetAllDataFromMonitorByDay(DBFilepath, "1812-01-01' OR '1'='1")
+	assert.NoError(t, err)
+	assert.Equal(t, len(monitors), 0)
 }
 
 func TestCrawlStatus(t *testing.T) {
@@ -246,4 +258,77 @@ func TestSaveHostType(t *testing.T) {
 func TestDeleteTypesTable(t *testing.T) {
 	err := DeleteTypesTable(DBFilepath)
 	assert.NoError(t, err)
-}
\ No newline at end of file
+}
+
+func TestSaveFilteredLink(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	res := SaveFilteredLink(DBFilepath, 1, FilteredLink{
+		SourceHost:  "a",
+		Link:        "http://b/price",
+		Reason:      "content type application/pdf is denied by application/pdf",
+		ContentType: "application/pdf",
+		Count:       3,
+	})
+	assert.Equal(t, true, res)
+
+	dates, err := GetAllDaysFromMonitor(DBFilepath)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(dates))
+
+	var today string
+	db, err := sql.Open("sqlite3", DBFilepath)
+	assert.NoError(t, err)
+	defer db.Close()
+	err = db.QueryRow("SELECT date('now')").Scan(&today)
+	assert.NoError(t, err)
+
+	filtered, err := GetFilteredLinksByDay(DBFilepath, today)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(filtered))
+	assert.Equal(t, "http://b/price", filtered[0].Link)
+	assert.Equal(t, "application/pdf", filtered[0].ContentType)
+	assert.Equal(t, 3, filtered[0].Count)
+
+	// the resumed run replaces its filtered links
+	assert.Equal(t, true, DeleteRunFromFiltered(DBFilepath, 2))
+	filtered, _ = GetFilteredLinksByDay(DBFilepath, today)
+	assert.Equal(t, 1, len(filtered))
+	assert.Equal(t, true, DeleteRunFromFiltered(DBFilepath, 1))
+	filtered, _ = GetFilteredLinksByDay(DBFilepath, today)
+	assert.Equal(t, 0, len(filtered))
+}
+
+func TestCreateDBIfNotExists_Migrations(t *testing.T) {
+	os.Remove(DBFilepath)
+	db, err := sql.Open("sqlite3", DBFilepath)
+	assert.NoError(t, err)
+	_, err = db.Exec("create table runs (id integer not null primary key, started date, finished date, status text, report text);")
+	assert.NoError(t, err)
+	db.Close()
+
+	CreateDBIfNotExists(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	id, err := StartRun(DBFilepath)
+	assert.NoError(t, err)
+	assert.NoError(t, ResumeRun(DBFilepath, id))
+	runs, err := GetLastRuns(DBFilepath, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, runs[0].Resumed)
+}
+
+func TestSaveDataToSqliteReplacesRun(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+
+	SaveDataToSqlite(DBFilepath, 1, map[string]map[string]int{"a.kg": {"http://x.kg/": 1, "http://y.kg/": 1}}, nil, false)
+	SaveDataToSqlite(DBFilepath, 2, map[string]map[string]int{"a.kg": {"http://x.kg/": 1}}, nil, false)
+	// the interrupted run 1 is resumed and saves all of its links
+	SaveDataToSqlite(DBFilepath, 1, map[string]map[string]int{"a.kg": {"http://x.kg/": 2, "http://y.kg/": 1, "http://z.kg/": 1}}, nil, false)
+
+	data, err := GetAllDataFromMonitor(DBFilepath, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(data))
+}
@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	IntervalRun   = "run"
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+var TimeSeriesIntervals = map[string]bool{IntervalRun: true, IntervalDay: true, IntervalWeek: true, IntervalMonth: true}
+
+// TimeSeriesQuery selects the links of the time series, the empty fields
+// select all of them. The types are the ones of sites.txt, "H" for the
+// hosts which are not there
+type TimeSeriesQuery struct {
+	SourceHost   string
+	ExternalHost string
+	SourceType   string
+	ExternalType string
+	Interval     string
+}
+
+// TimeSeriesPoint is the period of the time series: the run as "2017-01-02 #12",
+// the day, the week as "2017-W03" or the month as "2017-01". Links and Count
+// are the average numbers of the links and the occurrences of the runs in it
+type TimeSeriesPoint struct {
+	Period string
+	Runs   int
+	Links  float64
+	Count  float64
+}
+
+// GetTimeSeries returns the links and the occurrences selected by the query
+// saved by every run, rolled up by the interval. The links saved before the
+// runs were recorded in the monitor are taken by the day, the latest run of it
+func GetTimeSeries(dbFilepath string, query TimeSeriesQuery) ([]TimeSeriesPoint, error) {
+	if query.Interval == "" {
+		query.Interval = IntervalDay
+	}
+	if !TimeSeriesIntervals[query.Interval] {
+		return nil, fmt.Errorf("unknown interval %q, use run, day, week or month", query.Interval)
+	}
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	defer db.Close()
+
+	where := "1=1"
+	var args []interface{}
+	for _, filter := range []struct{ condition, value string }{
+		{"m.source_host=?", query.SourceHost},
+		{"m.external_host=?", query.ExternalHost},
+		{"coalesce(t1.hosttype,'H')=?", query.SourceType},
+		{"coalesce(t2.hosttype,'H')=?", query.ExternalType},
+	} {
+		if filter.value != "" {
+			where += " AND " + filter.condition
+			args = append(args, filter.value)
+		}
+	}
+	rows, err := db.Query("SELECT min(strftime('%Y-%m-%d', m.created)) as day, m.run_id, count(distinct m.source_host || ' ' || m.external_link), sum(m.count) "+
+		"FROM monitor as m "+
+		"LEFT OUTER JOIN types as t1 ON t1.hostname=m.source_host "+
+		"LEFT OUTER JOIN types as t2 ON t2.hostname=m.external_host "+
+		"WHERE "+where+" AND (m.run_id > 0 OR "+latestLegacyOfDay+") "+
+		"GROUP BY m.run_id, CASE WHEN m.run_id > 0 THEN '' ELSE strftime('%Y-%m-%d', m.created) END ORDER BY day, m.run_id", args...)
+	if err != nil {
+		log.Printf("Error getting time series: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []TimeSeriesPoint
+	for rows.Next() {
+		point := TimeSeriesPoint{Runs: 1}
+		var runID int64
+		if err := rows.Scan(&point.Period, &runID, &point.Links, &point.Count); err != nil {
+			log.Printf("Error getting time series: %v", err)
+			continue
+		}
+		if query.Interval == IntervalRun && runID > 0 {
+			point.Period = fmt.Sprintf("%v #%d", point.Period, runID)
+		}
+		runs = append(runs, point)
+	}
+	return RollupTimeSeries(runs, query.Interval), nil
+}
+
+// RollupTimeSeries averages the runs of every day, week or month, the runs
+// are sorted by the day
+func RollupTimeSeries(runs []TimeSeriesPoint, interval string) []TimeSeriesPoint {
+	points := []TimeSeriesPoint{}
+	for _, run := range runs {
+		period := timeSeriesPeriod(run.Period, interval)
+		last := len(points) - 1
+		if last < 0 || points[last].Period != period {
+			points = append(points, TimeSeriesPoint{Period: period})
+			last++
+		}
+		point := &points[last]
+		// the running average of the runs of the period
+		total := float64(point.Runs + run.Runs)
+		point.Links = (point.Links*float64(point.Runs) + run.Links*float64(run.Runs)) / total
+		point.Count = (point.Count*float64(point.Runs) + run.Count*float64(run.Runs)) / total
+		point.Runs += run.Runs
+	}
+	return points
+}
+
+func timeSeriesPeriod(day string, interval string) string {
+	t, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return day
+	}
+	switch interval {
+	case IntervalWeek:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case IntervalMonth:
+		return t.Format("2006-01")
+	}
+	return day
+}
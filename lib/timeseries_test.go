@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollupTimeSeries(t *testing.T) {
+	days := []TimeSeriesPoint{
+		{Period: "2017-01-30", Runs: 1, Links: 2, Count: 4},
+		{Period: "2017-01-31", Runs: 1, Links: 4, Count: 6},
+		{Period: "2017-02-01", Runs: 1, Links: 3, Count: 3},
+	}
+	assert.Equal(t, days, RollupTimeSeries(days, IntervalDay))
+	assert.Equal(t, []TimeSeriesPoint{{Period: "2017-W05", Runs: 3, Links: 3, Count: 13.0 / 3}}, RollupTimeSeries(days, IntervalWeek))
+	assert.Equal(t, []TimeSeriesPoint{
+		{Period: "2017-01", Runs: 2, Links: 3, Count: 5},
+		{Period: "2017-02", Runs: 1, Links: 3, Count: 3},
+	}, RollupTimeSeries(days, IntervalMonth))
+	assert.Equal(t, []TimeSeriesPoint{}, RollupTimeSeries(nil, IntervalMonth))
+}
+
+func TestGetTimeSeries(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+	SaveHostType(DBFilepath, "b.kg", "B")
+	db, _ := sql.Open("sqlite3", DBFilepath)
+	for _, row := range []struct {
+		source, link, host, created string
+		count                       int
+	}{
+		{"a.kg", "http://x.kg/1", "x.kg", "2017-01-01 10:00:00", 2},
+		// the run of the day before the latest one is not counted
+		{"a.kg", "http://x.kg/1", "x.kg", "2017-01-02 09:00:00", 3},
+		{"a.kg", "http://x.kg/1", "x.kg", "2017-01-02 10:00:00", 3},
+		{"a.kg", "http://x.kg/2", "x.kg", "2017-01-02 10:00:00", 1},
+		{"b.kg", "http://x.kg/1", "x.kg", "2017-01-02 10:00:00", 1},
+		{"a.kg", "http://b.kg/", "b.kg", "2017-01-02 10:00:00", 5},
+	} {
+		db.Exec("insert into monitor(source_host, external_link, count, external_host, created) values(?, ?, ?, ?, ?)",
+			row.source, row.link, row.count, row.host, row.created)
+	}
+	db.Close()
+
+	points, err := GetTimeSeries(DBFilepath, TimeSeriesQuery{ExternalHost: "x.kg"})
+	assert.NoError(t, err)
+	assert.Equal(t, []TimeSeriesPoint{{Period: "2017-01-01", Runs: 1, Links: 1, Count: 2}, {Period: "2017-01-02", Runs: 1, Links: 3, Count: 5}}, points)
+
+	points, _ = GetTimeSeries(DBFilepath, TimeSeriesQuery{SourceHost: "a.kg", Interval: IntervalMonth})
+	assert.Equal(t, []TimeSeriesPoint{{Period: "2017-01", Runs: 2, Links: 2, Count: 5.5}}, points)
+
+	points, _ = GetTimeSeries(DBFilepath, TimeSeriesQuery{ExternalType: "B"})
+	assert.Equal(t, []TimeSeriesPoint{{Period: "2017-01-02", Runs: 1, Links: 1, Count: 5}}, points)
+	points, _ = GetTimeSeries(DBFilepath, TimeSeriesQuery{SourceType: "B"})
+	assert.Equal(t, 1, len(points))
+
+	_, err = GetTimeSeries(DBFilepath, TimeSeriesQuery{Interval: "year"})
+	assert.Error(t, err)
+}
+
+func TestGetTimeSeriesByRun(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+	SaveRunRecordToMonitor(DBFilepath, 1, "a.kg", "http://x.kg/1", 2, "x.kg", LinkInfo{})
+	SaveRunRecordToMonitor(DBFilepath, 2, "a.kg", "http://x.kg/1", 4, "x.kg", LinkInfo{})
+	SaveRunRecordToMonitor(DBFilepath, 2, "a.kg", "http://x.kg/2", 2, "x.kg", LinkInfo{})
+	day := time.Now().UTC().Format("2006-01-02")
+
+	points, err := GetTimeSeries(DBFilepath, TimeSeriesQuery{Interval: IntervalRun})
+	assert.NoError(t, err)
+	assert.Equal(t, []TimeSeriesPoint{
+		{Period: day + " #1", Runs: 1, Links: 1, Count: 2},
+		{Period: day + " #2", Runs: 1, Links: 2, Count: 6},
+	}, points)
+
+	// the two runs of the day are averaged, not added up
+	points, _ = GetTimeSeries(DBFilepath, TimeSeriesQuery{})
+	assert.Equal(t, []TimeSeriesPoint{{Period: day, Runs: 2, Links: 1.5, Count: 4}}, points)
+}
@@ -0,0 +1,192 @@
+package lib
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	SkipQueryVariants   = "query variants"
+	SkipPathDepth       = "path depth"
+	SkipRepeatedSegment = "repeated path segments"
+	SkipSessionParam    = "session or sort parameter"
+	SkipDuplicate       = "duplicate content"
+	SkipCanonical       = "canonical duplicate"
+)
+
+type TrapGuardOptions struct {
+	MaxQueryVariants    int
+	MaxPathDepth        int
+	MaxRepeatedSegments int
+	SkipParams          []string
+}
+
+// TrapGuard keeps the crawler away from calendars, infinite pagination,
+// session-ID and sort-order variants of the same page and duplicates,
+// so MaxVisits budget is spent on the real content
+type TrapGuard struct {
+	options       TrapGuardOptions
+	mutex         sync.Mutex
+	queryVariants map[string]map[string]bool
+	contentHashes map[string]string
+	canonicals    map[string]string
+	skipped       map[string]int
+	skippedURLs   map[string]bool
+}
+
+func NewTrapGuard(options TrapGuardOptions) *TrapGuard {
+	return &TrapGuard{
+		options:       options,
+		queryVariants: make(map[string]map[string]bool),
+		contentHashes: make(map[string]string),
+		canonicals:    make(map[string]string),
+		skipped:       make(map[string]int),
+		skippedURLs:   make(map[string]bool),
+	}
+}
+
+// Allow checks the url before it is fetched. If the url looks like a trap
+// it is counted as skipped once, however many times it is found, and the
+// reason is returned
+func (g *TrapGuard) Allow(u *url.URL) (bool, string) {
+	reason := g.check(u)
+	if reason == "" {
+		return true, ""
+	}
+	g.mutex.Lock()
+	seen := g.skippedURLs[u.String()]
+	g.skippedURLs[u.String()] = true
+	g.mutex.Unlock()
+	if !seen {
+		g.Skip(reason)
+	}
+	return false, reason
+}
+
+func (g *TrapGuard) check(u *url.URL) string {
+	query := u.Query()
+	for _, param := range g.options.SkipParams {
+		for key := range query {
+			if strings.EqualFold(key, strings.TrimSpace(param)) {
+				return SkipSessionParam
+			}
+		}
+	}
+	if strings.Contains(strings.ToLower(u.Path), ";jsessionid=") {
+		return SkipSessionParam
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment != "" {
+			segments = append(segments, strings.ToLower(segment))
+		}
+	}
+	if g.options.MaxPathDepth > 0 && len(segments) > g.options.MaxPathDepth {
+		return SkipPathDepth
+	}
+	if g.options.MaxRepeatedSegments > 0 {
+		seen := make(map[string]int)
+		for _, segment := range segments {
+			seen[segment]++
+			if seen[segment] > g.options.MaxRepeatedSegments {
+				return SkipRepeatedSegment
+			}
+		}
+	}
+
+	if g.options.MaxQueryVariants > 0 && u.RawQuery != "" {
+		key := u.Host + u.Path
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+		if g.queryVariants[key] == nil {
+			g.queryVariants[key] = make(map[string]bool)
+		}
+		if !g.queryVariants[key][u.RawQuery] && len(g.queryVariants[key]) >= g.options.MaxQueryVariants {
+			return SkipQueryVariants
+		}
+		g.queryVariants[key][u.RawQuery] = true
+	}
+	return ""
+}
+
+// IsDuplicate checks the fetched page. A page is a duplicate if the page with
+// the same content or the same rel=canonical url was already seen in this crawl.
+// The url of the first page is returned
+func (g *TrapGuard) IsDuplicate(pageURL string, canonical string, body []byte) (bool, string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	key := pageURL
+	if canonical != "" {
+		key = canonical
+	}
+	if first, ok := g.canonicals[key]; ok && first != pageURL {
+		g.skipped[SkipCanonical]++
+		return true, first
+	}
+	g.canonicals[key] = pageURL
+
+	if len(body) == 0 {
+		return false, ""
+	}
+	hash := ContentHash(body)
+	if first, ok := g.contentHashes[hash]; ok && first != pageURL {
+		g.skipped[SkipDuplicate]++
+		return true, first
+	}
+	g.contentHashes[hash] = pageURL
+	return false, ""
+}
+
+func (g *TrapGuard) Skip(reason string) {
+	g.mutex.Lock()
+	g.skipped[reason]++
+	g.mutex.Unlock()
+}
+
+// AddSkipped counts the urls skipped by the other guard, e.g. on a worker
+func (g *TrapGuard) AddSkipped(skipped map[string]int) {
+	g.mutex.Lock()
+	for reason, count := range skipped {
+		g.skipped[reason] += count
+	}
+	g.mutex.Unlock()
+}
+
+func (g *TrapGuard) Skipped() map[string]int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	skipped := make(map[string]int)
+	for reason, count := range g.skipped {
+		skipped[reason] = count
+	}
+	return skipped
+}
+
+// ContentHash is used to find pages with the same content
+func ContentHash(body []byte) string {
+	sum := sha1.Sum(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// FormatSkippedReport makes a line for the run report, e.g.
+// "Skipped URLs: 8 (duplicate content: 3, query variants: 5)"
+func FormatSkippedReport(skipped map[string]int) string {
+	var reasons []string
+	total := 0
+	for reason, count := range skipped {
+		reasons = append(reasons, fmt.Sprintf("%s: %d", reason, count))
+		total += count
+	}
+	if total == 0 {
+		return "Skipped URLs: 0"
+	}
+	sort.Strings(reasons)
+	return fmt.Sprintf("Skipped URLs: %d (%s)", total, strings.Join(reasons, ", "))
+}
@@ -0,0 +1,105 @@
+package lib
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTrapGuard() *TrapGuard {
+	return NewTrapGuard(TrapGuardOptions{
+		MaxQueryVariants:    3,
+		MaxPathDepth:        4,
+		MaxRepeatedSegments: 2,
+		SkipParams:          []string{"phpsessid", " sort"},
+	})
+}
+
+func allow(g *TrapGuard, rawurl string) (bool, string) {
+	u, _ := url.Parse(rawurl)
+	return g.Allow(u)
+}
+
+func TestTrapGuardQueryVariants(t *testing.T) {
+	g := newTestTrapGuard()
+	for i := 0; i < 3; i++ {
+		ok, _ := allow(g, "http://a.kg/calendar?day="+strconv.Itoa(i))
+		assert.True(t, ok)
+	}
+	ok, reason := allow(g, "http://a.kg/calendar?day=3")
+	assert.False(t, ok)
+	assert.Equal(t, SkipQueryVariants, reason)
+
+	ok, _ = allow(g, "http://a.kg/calendar?day=1")
+	assert.True(t, ok)
+	ok, _ = allow(g, "http://a.kg/news?page=5")
+	assert.True(t, ok)
+}
+
+func TestTrapGuardPath(t *testing.T) {
+	g := newTestTrapGuard()
+
+	ok, reason := allow(g, "http://a.kg/a/b/c/d/e")
+	assert.False(t, ok)
+	assert.Equal(t, SkipPathDepth, reason)
+
+	ok, reason = allow(g, "http://a.kg/news/news/news")
+	assert.False(t, ok)
+	assert.Equal(t, SkipRepeatedSegment, reason)
+
+	ok, _ = allow(g, "http://a.kg/news/2017/news")
+	assert.True(t, ok)
+}
+
+func TestTrapGuardSessionParams(t *testing.T) {
+	g := newTestTrapGuard()
+
+	ok, reason := allow(g, "http://a.kg/?PHPSESSID=abc")
+	assert.False(t, ok)
+	assert.Equal(t, SkipSessionParam, reason)
+
+	ok, _ = allow(g, "http://a.kg/list?sort=price")
+	assert.False(t, ok)
+
+	ok, _ = allow(g, "http://a.kg/page;jsessionid=123")
+	assert.False(t, ok)
+
+	// the same url found again on the other pages is skipped, but not counted
+	ok, _ = allow(g, "http://a.kg/list?sort=price")
+	assert.False(t, ok)
+
+	assert.Equal(t, 3, g.Skipped()[SkipSessionParam])
+
+	g.AddSkipped(map[string]int{SkipSessionParam: 2, SkipPathDepth: 1})
+	assert.Equal(t, 5, g.Skipped()[SkipSessionParam])
+	assert.Equal(t, 1, g.Skipped()[SkipPathDepth])
+}
+
+func TestTrapGuardDuplicates(t *testing.T) {
+	g := newTestTrapGuard()
+
+	duplicate, _ := g.IsDuplicate("http://a.kg/1", "", []byte("page"))
+	assert.False(t, duplicate)
+	duplicate, first := g.IsDuplicate("http://a.kg/2", "", []byte("page"))
+	assert.True(t, duplicate)
+	assert.Equal(t, "http://a.kg/1", first)
+
+	duplicate, _ = g.IsDuplicate("http://a.kg/3?utm=1", "http://a.kg/3", []byte("page 3"))
+	assert.False(t, duplicate)
+	duplicate, first = g.IsDuplicate("http://a.kg/3", "", []byte("page 3 with other banner"))
+	assert.True(t, duplicate)
+	assert.Equal(t, "http://a.kg/3?utm=1", first)
+
+	duplicate, _ = g.IsDuplicate("http://a.kg/4", "", nil)
+	assert.False(t, duplicate)
+	duplicate, _ = g.IsDuplicate("http://a.kg/5", "", nil)
+	assert.False(t, duplicate)
+
+	assert.Equal(t, "Skipped URLs: 2 (canonical duplicate: 1, duplicate content: 1)", FormatSkippedReport(g.Skipped()))
+}
+
+func TestFormatSkippedReportEmpty(t *testing.T) {
+	assert.Equal(t, "Skipped URLs: 0", FormatSkippedReport(map[string]int{}))
+}
@@ -2,6 +2,7 @@ package lib
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"net/http"
 	"net/http/httputil"
@@ -22,12 +23,18 @@ const (
 )
 
 var (
+	// the resolvers share the connections, through the proxies too
+	resolveTransport = &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
 	resolveCache map[string]string
+	contentTypeCache map[string]string
 	lastCachedReturn = false
 )
 
 func ClearResolveCache() {
 	resolveCache = make(map[string]string)
+	contentTypeCache = make(map[string]string)
 }
 
 func Debug(data []byte, err error) {
@@ -56,7 +63,13 @@ func GetSliceFromFile(realFile string, defaultFile string) ([]string, error) {
 	return lines, scanner.Err()
 }
 
-func SaveDataToSqlite(DBFilepath string, externalLinksResolved map[string]map[string]int, verbose bool) bool {
+// SaveDataToSqlite saves the resolved links of every source host found by
+// the run with the info about where they were found. The links saved by the
+// run before, e.g. before it was interrupted, are replaced
+func SaveDataToSqlite(DBFilepath string, runID int64, externalLinksResolved map[string]map[string]int, linksInfo map[string]map[string]LinkInfo, verbose bool) bool {
+	if runID > 0 && !DeleteRunFromMonitor(DBFilepath, runID) {
+		return false
+	}
 	for sourceHost, externalLinks := range externalLinksResolved {
 		for externalLink, count := range externalLinks {
 			var externalHost string
@@ -69,7 +82,7 @@ func SaveDataToSqlite(DBFilepath string, externalLinksResolved map[string]map[st
 			if verbose {
 				log.Printf("Saving result of %s: ", externalLink)
 			}
-			res := SaveRecordToMonitor(DBFilepath, sourceHost, externalLink, count, externalHost)
+			res := SaveRunRecordToMonitor(DBFilepath, runID, sourceHost, externalLink, count, externalHost, linksInfo[sourceHost][externalLink])
 			if verbose {
 				log.Printf("The result of saving is: %t", res)
 			}
@@ -80,18 +93,25 @@ func SaveDataToSqlite(DBFilepath string, externalLinksResolved map[string]map[st
 
 // TODO: need to use cache, do not resolve same URLs
 func Resolve(url string, host string, resolveTimeout int, verbose bool, userAgent string, mutex *sync.Mutex) string {
+	resolvedUrl, _, _ := ResolveWithContentType(context.Background(), url, host, resolveTimeout, verbose, userAgent, mutex)
+	return resolvedUrl
+}
+
+// ResolveWithContentType works like Resolve, but also returns the Content-Type
+// header of the final response, so the caller can filter out downloads
+// without fetching the link one more time. The request is aborted when
+// the ctx is canceled. If the link can not be resolved, it is returned
+// as is with the error
+func ResolveWithContentType(ctx context.Context, url string, host string, resolveTimeout int, verbose bool, userAgent string, mutex *sync.Mutex) (string, string, error) {
 	lastCachedReturn = false
 	if resolveCache[url] != "" {
 		log.Printf("URL %v is in cache, return the resolved value %v", url, resolveCache[url])
 		lastCachedReturn = true
-		return resolveCache[url]
+		return resolveCache[url], contentTypeCache[url], nil
 	}
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
 	client := &http.Client{
-		Transport: tr,
+		Transport: GetProxyPool(host).RoundTripper(resolveTransport),
 		Timeout:   time.Duration(resolveTimeout) * time.Second,
 	}
 
@@ -105,8 +125,9 @@ func Resolve(url string, host string, resolveTimeout int, verbose bool, userAgen
 		if verbose {
 			log.Println("Bad URL: " + url + " Err:" + err.Error())
 		}
-		return url
+		return url, "", err
 	}
+	request = request.WithContext(ctx)
 
 	request.Header.Add("User-Agent", userAgent)
 	request.Header.Add("Referer", "http://"+host)
@@ -125,21 +146,53 @@ func Resolve(url string, host string, resolveTimeout int, verbose bool, userAgen
 		}
 		defer response.Body.Close()
 
+		contentType := response.Header.Get("Content-Type")
+		if contentType == "" && ctx.Err() == nil {
+			contentType = getContentType(client, response.Request.URL.String(), userAgent)
+		}
+
 		if mutex != nil {
 			mutex.Lock()
 		}
 
 		resolveCache[url] = response.Request.URL.String()
+		contentTypeCache[url] = contentType
 
 		if mutex != nil {
 			mutex.Unlock()
 		}
 
-		return response.Request.URL.String()
+		return response.Request.URL.String(), contentType, nil
 	} else {
 		log.Printf("Error client.Do %v", err)
-		return url
+		return url, "", err
+	}
+}
+
+// GetContentType asks the server for the Content-Type of the url with a HEAD
+// request. Empty string is returned if the server does not tell it
+func GetContentType(url string, timeout int, userAgent string) string {
+	client := &http.Client{
+		Transport: resolveTransport,
+		Timeout:   time.Duration(timeout) * time.Second,
+	}
+	return getContentType(client, url, userAgent)
+}
+
+func getContentType(client *http.Client, url string, userAgent string) string {
+	request, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return ""
+	}
+	request.Header.Add("User-Agent", userAgent)
+
+	response, err := client.Do(request)
+	if err != nil {
+		log.Printf("Error getting content type of %v: %v", url, err)
+		return ""
 	}
+	defer response.Body.Close()
+	return response.Header.Get("Content-Type")
 }
 
 func GetHostsFromFile(sitesFilepath string, sitesDefaultFilepath string) ([]string, error) {
@@ -176,9 +229,17 @@ func HasInternalOutPatterns(href string, internalOutPatterns []string) bool {
 	return false
 }
 
+// HasBadSuffixes checks the path part of the href only, so query strings,
+// fragments and the letter case do not hide the extension
 func HasBadSuffixes(href string, badSuffixes []string) bool {
+	path := href
+	u, err := url.Parse(href)
+	if err == nil {
+		path = u.Path
+	}
+	path = strings.ToLower(path)
 	for i := range badSuffixes {
-		if strings.HasSuffix(href, badSuffixes[i]) {
+		if strings.HasSuffix(path, strings.ToLower(badSuffixes[i])) {
 			return true
 		}
 	}
@@ -4,6 +4,9 @@ import (
 	"testing"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"net/http"
+	"net/http/httptest"
+	"context"
 )
 
 func TestMain(m *testing.M) {
@@ -63,4 +66,47 @@ func TestPopulateHostsAndTypes(t *testing.T) {
 	assert.NoError(t, err)
 	err = PopulateHostsAndTypes(DBFilepath, "", "../sites.default.txt")
 	assert.NoError(t, err)
-}
\ No newline at end of file
+}
+
+func TestHasBadSuffixes(t *testing.T) {
+	suffixes := []string{".png", ".jpg", ".pdf"}
+	assert.True(t, HasBadSuffixes("http://a.kg/price.pdf", suffixes))
+	assert.True(t, HasBadSuffixes("http://a.kg/logo.PNG", suffixes))
+	assert.True(t, HasBadSuffixes("http://a.kg/photo.jpg?w=100#top", suffixes))
+	assert.False(t, HasBadSuffixes("http://a.kg/pdf-viewer?file=1", suffixes))
+	assert.False(t, HasBadSuffixes("http://a.kg/", suffixes))
+}
+
+func TestResolveWithContentType(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/short" {
+			http.Redirect(w, r, "/file", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+	}))
+	defer ts.Close()
+
+	res, contentType, err := ResolveWithContentType(context.Background(), ts.URL+"/short", "a.kg", 10, false, "Googlebot", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ts.URL+"/file", res)
+	assert.Equal(t, "application/pdf", contentType)
+
+	_, contentType, _ = ResolveWithContentType(context.Background(), ts.URL+"/short", "a.kg", 10, false, "Googlebot", nil)
+	assert.Equal(t, true, lastCachedReturn)
+	assert.Equal(t, "application/pdf", contentType)
+}
+
+func TestResolveWithContentTypeCanceled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/file", http.StatusFound)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, contentType, err := ResolveWithContentType(ctx, ts.URL+"/canceled", "a.kg", 10, false, "Googlebot", nil)
+	assert.Error(t, err)
+	assert.Equal(t, ts.URL+"/canceled", res)
+	assert.Equal(t, "", contentType)
+}
@@ -0,0 +1,220 @@
+package lib
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	WatchlistFilepath        = "./watchlist.yml"
+	WatchlistDefaultFilepath = "./watchlist.default.yml"
+)
+
+const (
+	WatchlistFlag = "flag"
+	WatchlistOnly = "only"
+)
+
+// WatchedDomain is the competitor or the client domain from watchlist.yml,
+// its subdomains are watched too, e.g.
+//
+//	competitor.kg:
+//	  label: competitor
+type WatchedDomain struct {
+	Domain string `yaml:"-"`
+	Label  string `yaml:"label"`
+}
+
+// GetWatchlist reads the watched domains sorted by the domain. Nothing is
+// watched if neither of the files exists
+func GetWatchlist(realFile string, defaultFile string) ([]WatchedDomain, error) {
+	data, err := ioutil.ReadFile(realFile)
+	if os.IsNotExist(err) {
+		data, err = ioutil.ReadFile(defaultFile)
+	}
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var parsed map[string]WatchedDomain
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		return nil, err
+	}
+	var watchlist []WatchedDomain
+	for domain, watched := range parsed {
+		watched.Domain = strings.ToLower(strings.TrimSpace(domain))
+		watchlist = append(watchlist, watched)
+	}
+	sort.Slice(watchlist, func(i, j int) bool {
+		return watchlist[i].Domain < watchlist[j].Domain
+	})
+	return watchlist, nil
+}
+
+// MatchWatchlist finds the watched domain of the host, the most specific one
+// if both the domain and its subdomain are watched
+func MatchWatchlist(watchlist []WatchedDomain, host string) (WatchedDomain, bool) {
+	host = strings.ToLower(host)
+	var match WatchedDomain
+	found := false
+	for _, watched := range watchlist {
+		if (host == watched.Domain || strings.HasSuffix(host, "."+watched.Domain)) && len(watched.Domain) > len(match.Domain) {
+			match = watched
+			found = true
+		}
+	}
+	return match, found
+}
+
+// watchColumns are the values of the watch columns of the monitor
+func watchColumns(watched *WatchedDomain) (string, string) {
+	if watched == nil {
+		return "", ""
+	}
+	return watched.Domain, watched.Label
+}
+
+// GetWatchedDomains returns the watched domains the links were saved for
+func GetWatchedDomains(dbFilepath string) ([]WatchedDomain, error) {
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT watch_domain, max(coalesce(watch_label,'')) FROM monitor WHERE coalesce(watch_domain,'')!='' " +
+		"GROUP BY watch_domain ORDER BY watch_domain")
+	if err != nil {
+		log.Printf("Error getting watched domains: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	watchlist := []WatchedDomain{}
+	for rows.Next() {
+		var watched WatchedDomain
+		if err := rows.Scan(&watched.Domain, &watched.Label); err != nil {
+			log.Printf("Error getting watched domains: %v", err)
+			continue
+		}
+		watchlist = append(watchlist, watched)
+	}
+	return watchlist, nil
+}
+
+// MergeWatchlist adds the domains of the watchlist to the domains the links
+// were saved for, so the domains watched since the last run are there too.
+// The labels of the watchlist win
+func MergeWatchlist(saved []WatchedDomain, watchlist []WatchedDomain) []WatchedDomain {
+	merged := []WatchedDomain{}
+	labels := make(map[string]string)
+	for _, watched := range watchlist {
+		labels[watched.Domain] = watched.Label
+		merged = append(merged, watched)
+	}
+	for _, watched := range saved {
+		if _, ok := labels[watched.Domain]; !ok {
+			merged = append(merged, watched)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Domain < merged[j].Domain
+	})
+	return merged
+}
+
+// WatchSource is the source host linking to the watched domain on the day:
+// the number of the links, the occurrences and the pages with them
+type WatchSource struct {
+	SourceHost string
+	Links      int
+	Count      int
+	Pages      int
+}
+
+// WatchTrend is the number of the sources, the links and the occurrences
+// of the watched domain found by the run on the day, the links saved before
+// the runs were recorded have no RunID and are counted by the day
+type WatchTrend struct {
+	RunID   int64
+	Day     string
+	Sources int
+	Links   int
+	Count   int
+}
+
+// WatchReport is the dashboard of the watched domain: the sources linking
+// to it on the latest day it was found and the trend over all the runs
+type WatchReport struct {
+	Domain  string
+	Label   string
+	Day     string
+	Sources []WatchSource
+	Trend   []WatchTrend
+}
+
+// GetWatchReport makes the dashboard of the domain from all the links saved
+// to it and its subdomains, also the ones saved before it was watched. The
+// sources are counted by their latest run of the day
+func GetWatchReport(dbFilepath string, domain string) (WatchReport, error) {
+	domain = strings.ToLower(domain)
+	report := WatchReport{Domain: domain, Sources: []WatchSource{}, Trend: []WatchTrend{}}
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		log.Print(err)
+		return report, err
+	}
+	defer db.Close()
+
+	matches := "(lower(m.external_host)=? OR substr(lower(m.external_host), -length(?))=?)"
+	args := []interface{}{domain, "." + domain, "." + domain}
+	db.QueryRow("SELECT coalesce(max(watch_label),'') FROM monitor WHERE watch_domain=?", domain).Scan(&report.Label)
+
+	rows, err := db.Query("SELECT m.run_id, min(strftime('%Y-%m-%d', m.created)) as day, count(distinct m.source_host), count(distinct m.external_link), sum(m.count) "+
+		"FROM monitor as m WHERE "+matches+" AND (m.run_id > 0 OR "+latestLegacyOfDay+") "+
+		"GROUP BY m.run_id, CASE WHEN m.run_id > 0 THEN '' ELSE strftime('%Y-%m-%d', m.created) END ORDER BY day, m.run_id", args...)
+	if err != nil {
+		log.Printf("Error getting watch report: %v", err)
+		return report, err
+	}
+	for rows.Next() {
+		var trend WatchTrend
+		if err := rows.Scan(&trend.RunID, &trend.Day, &trend.Sources, &trend.Links, &trend.Count); err != nil {
+			log.Printf("Error getting watch report: %v", err)
+			continue
+		}
+		report.Trend = append(report.Trend, trend)
+	}
+	rows.Close()
+	if len(report.Trend) == 0 {
+		return report, nil
+	}
+
+	report.Day = report.Trend[len(report.Trend)-1].Day
+	rows, err = db.Query("SELECT m.source_host, count(distinct m.external_link), sum(m.count), sum(coalesce(m.pages,0)) "+
+		"FROM monitor as m WHERE "+matches+" AND "+latestRunOfDay+" AND strftime('%Y-%m-%d', m.created)=? GROUP BY m.source_host ORDER BY sum(m.count) DESC, m.source_host",
+		append(args, report.Day)...)
+	if err != nil {
+		log.Printf("Error getting watch report: %v", err)
+		return report, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var source WatchSource
+		if err := rows.Scan(&source.SourceHost, &source.Links, &source.Count, &source.Pages); err != nil {
+			log.Printf("Error getting watch report: %v", err)
+			continue
+		}
+		report.Sources = append(report.Sources, source)
+	}
+	return report, nil
+}
@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetWatchlist(t *testing.T) {
+	realFile := "/tmp/spiderwoman-watchlist.yml"
+	ioutil.WriteFile(realFile, []byte("Competitor.kg:\n  label: competitor\nclient.kg: {}\n"), 0644)
+	defer os.Remove(realFile)
+
+	watchlist, err := GetWatchlist(realFile, "/tmp/no-such-file.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, []WatchedDomain{{Domain: "client.kg"}, {Domain: "competitor.kg", Label: "competitor"}}, watchlist)
+
+	watchlist, err = GetWatchlist("/tmp/no-such-file.yml", "../watchlist.default.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(watchlist))
+}
+
+func TestMatchWatchlist(t *testing.T) {
+	watchlist := []WatchedDomain{{Domain: "competitor.kg"}, {Domain: "ads.competitor.kg", Label: "ads"}}
+
+	watched, ok := MatchWatchlist(watchlist, "www.Competitor.kg")
+	assert.True(t, ok)
+	assert.Equal(t, "competitor.kg", watched.Domain)
+	watched, _ = MatchWatchlist(watchlist, "x.ads.competitor.kg")
+	assert.Equal(t, "ads", watched.Label)
+	_, ok = MatchWatchlist(watchlist, "notcompetitor.kg")
+	assert.False(t, ok)
+}
+
+func TestGetWatchReport(t *testing.T) {
+	os.Remove(DBFilepath)
+	CreateDBIfNotExists(DBFilepath)
+	db, _ := sql.Open("sqlite3", DBFilepath)
+	for _, row := range []struct {
+		runID                       int64
+		source, link, host, created string
+		count                       int
+	}{
+		// saved before the runs were recorded
+		{0, "a.kg", "http://competitor.kg/", "competitor.kg", "2017-01-01 10:00:00", 2},
+		// the earlier run of the day, the later one does not find competitor.kg/old
+		{2, "a.kg", "http://competitor.kg/", "competitor.kg", "2017-01-02 09:00:00", 7},
+		{2, "a.kg", "http://competitor.kg/old", "competitor.kg", "2017-01-02 09:00:00", 1},
+		{3, "a.kg", "http://competitor.kg/", "competitor.kg", "2017-01-02 10:00:00", 3},
+		{3, "a.kg", "http://www.competitor.kg/x", "www.competitor.kg", "2017-01-02 10:00:00", 1},
+		{3, "b.kg", "http://competitor.kg/", "competitor.kg", "2017-01-02 10:00:00", 1},
+		{3, "b.kg", "http://other.kg/", "other.kg", "2017-01-02 10:00:00", 5},
+		{3, "b.kg", "http://a_b.kg/", "a_b.kg", "2017-01-02 10:00:00", 1},
+		{3, "b.kg", "http://www.axb.kg/", "www.axb.kg", "2017-01-02 10:00:00", 1},
+	} {
+		db.Exec("insert into monitor(run_id, source_host, external_link, count, external_host, created) values(?, ?, ?, ?, ?, ?)",
+			row.runID, row.source, row.link, row.count, row.host, row.created)
+	}
+	db.Close()
+	SaveRecordToMonitorWithInfo(DBFilepath, "c.kg", "http://client.kg/", 1, "client.kg",
+		LinkInfo{Watched: &WatchedDomain{Domain: "client.kg", Label: "client"}})
+
+	report, err := GetWatchReport(DBFilepath, "competitor.kg")
+	assert.NoError(t, err)
+	assert.Equal(t, "2017-01-02", report.Day)
+	assert.Equal(t, []WatchSource{{SourceHost: "a.kg", Links: 2, Count: 4}, {SourceHost: "b.kg", Links: 1, Count: 1}}, report.Sources)
+	assert.Equal(t, []WatchTrend{
+		{Day: "2017-01-01", Sources: 1, Links: 1, Count: 2},
+		{RunID: 2, Day: "2017-01-02", Sources: 1, Links: 2, Count: 8},
+		{RunID: 3, Day: "2017-01-02", Sources: 2, Links: 2, Count: 5},
+	}, report.Trend)
+
+	watchlist, err := GetWatchedDomains(DBFilepath)
+	assert.NoError(t, err)
+	assert.Equal(t, []WatchedDomain{{Domain: "client.kg", Label: "client"}}, watchlist)
+	report, _ = GetWatchReport(DBFilepath, "client.kg")
+	assert.Equal(t, "client", report.Label)
+
+	report, err = GetWatchReport(DBFilepath, "nobody.kg")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(report.Sources))
+
+	report, _ = GetWatchReport(DBFilepath, "a_b.kg")
+	assert.Equal(t, []WatchSource{{SourceHost: "b.kg", Links: 1, Count: 1}}, report.Sources)
+}
+
+func TestMergeWatchlist(t *testing.T) {
+	saved := []WatchedDomain{{Domain: "competitor.kg", Label: "old"}, {Domain: "gone.kg", Label: "client"}}
+	watchlist := []WatchedDomain{{Domain: "competitor.kg", Label: "competitor"}, {Domain: "new.kg"}}
+	assert.Equal(t, []WatchedDomain{
+		{Domain: "competitor.kg", Label: "competitor"},
+		{Domain: "gone.kg", Label: "client"},
+		{Domain: "new.kg"},
+	}, MergeWatchlist(saved, watchlist))
+	assert.Equal(t, []WatchedDomain{}, MergeWatchlist(nil, nil))
+}
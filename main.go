@@ -1,7 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -14,10 +20,15 @@ import (
 	"log"
 	"github.com/urfave/cli"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 )
 
 type Ext struct {
 	*gocrawl.DefaultExtender
+	host    string
+	session *lib.SiteSession
 }
 
 var (
@@ -27,9 +38,31 @@ var (
 	syncResolve           sync.WaitGroup
 	err                   error
 	externalLinksIterator int
+	runID                 int64
+	trapGuard             *lib.TrapGuard
+	knownPages            map[string]lib.Page
+	crawledPages          []lib.Page
+	notModifiedPages      int
+	sameContentPages      int
+	resumedVisited        map[string]bool
+	shutdown              context.Context = context.Background()
+	crawlCtx              context.Context = context.Background()
+	crawlMutex            sync.Mutex
+	progress              lib.RunProgress
+	sitesConfig           map[string]lib.SiteConfig
+	secrets               map[string]string
+	resolving             bool
+	phaseStarted          time.Time
+	distributed           bool
+	workerMode            bool
 
 	externalLinks         map[string]map[string]int
 	externalLinksResolved map[string]map[string]int
+	linksInfo             map[string]map[string]lib.LinkInfo
+	resolvedLinksInfo     map[string]map[string]lib.LinkInfo
+	hostPages             map[string]int
+	embedHosts            map[string]map[string]int
+	filteredLinks         map[string]map[string]lib.FilteredLink
 	config 		      simple_config.SimpleConfig = simple_config.NewSimpleConfig("./config", "yml")
 
 	userAgent             string                    = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
@@ -42,6 +75,17 @@ var (
 	excelZipFilePath      string                    = config.GetString("zip-xls-path")
 	internalOutPatterns   []string                  = []string{"/go/", "/go.php?", "/goto/", "/banners/click/", "/adrotate-out.php?", "/bsdb/bs.php?"}
 	badSuffixes           []string                  = []string{".png", ".jpg", ".pdf"}
+	mimeFilter            lib.MimeFilter            = lib.NewMimeFilter(config.GetString("mime-allow"), configString("mime-deny", defaultMimeDeny))
+)
+
+const (
+	defaultMimeDeny       = "image/*,video/*,audio/*,application/pdf,application/zip,application/octet-stream"
+	defaultTrapSkipParams = "phpsessid,jsessionid,sid,sessionid,session_id,sort,order,orderby,dir"
+	notModifiedHeader     = "X-Spiderwoman-Not-Modified"
+	requestPollInterval   = 10 * time.Second
+	progressSaveInterval  = 2 * time.Second
+	eventsKeepRuns        = 10
+	jobsPollInterval      = time.Second
 )
 
 func main() {
@@ -53,7 +97,7 @@ func main() {
 		{
 			Name:    "once",
 			Aliases: []string{"o"},
-			Usage:   "run the crawl and stop",
+			Usage:   "run the crawl and stop, the crawl requested through the API is run if there is one",
 			Action:  actionOnce,
 		},
 		{
@@ -62,6 +106,59 @@ func main() {
 			Usage:   "start crawl forever using cron feature",
 			Action:  actionForever,
 		},
+		{
+			Name:    "resume",
+			Aliases: []string{"r"},
+			Usage:   "continue the crawl which was interrupted",
+			Action:  actionResume,
+		},
+		{
+			Name:   "coordinator",
+			Usage:  "crawl forever like the forever command, but give the hosts and the links to the workers",
+			Action: actionCoordinator,
+		},
+		{
+			Name:  "worker",
+			Usage: "crawl the hosts and resolve the links given by the coordinator",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "coordinator", Value: "http://localhost:8090", Usage: "url of the coordinator"},
+				cli.StringFlag{Name: "token", Usage: "token shared with the coordinator, coordinator-token of the config by default"},
+				cli.StringFlag{Name: "name", Usage: "name of the worker, the hostname and the pid by default"},
+			},
+			Action: actionWorker,
+		},
+		{
+			Name:  "diff",
+			Usage: "print the links added and removed between two days, the latest two by default",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "from", Usage: "the earlier day, e.g. 2017-01-20"},
+				cli.StringFlag{Name: "to", Usage: "the later day, e.g. 2017-01-21"},
+			},
+			Action: actionDiff,
+		},
+		{
+			Name:  "export",
+			Usage: "export the links for the other tools",
+			Subcommands: []cli.Command{
+				{
+					Name:  "graph",
+					Usage: "export the graph of the source and the external hosts for Gephi or Graphviz, the latest day by default",
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "format", Value: "graphml", Usage: "graphml, gexf or dot"},
+						cli.StringFlag{Name: "from", Usage: "the first day, e.g. 2017-01-20"},
+						cli.StringFlag{Name: "to", Usage: "the last day, e.g. 2017-01-21"},
+						cli.Int64Flag{Name: "run", Usage: "the id of the crawl, instead of the days"},
+						cli.StringFlag{Name: "output", Usage: "the file to write, the standard output by default"},
+					},
+					Action: actionExportGraph,
+				},
+			},
+		},
+		{
+			Name:   "test-alerts",
+			Usage:  "send the test alert to all the channels of alerts.yml",
+			Action: actionTestAlerts,
+		},
 	}
 
 	app.Run(os.Args)
@@ -69,7 +166,37 @@ func main() {
 
 func actionOnce(c *cli.Context) error {
 	initialize()
-	crawl()
+	crawlMutex.Lock()
+	defer crawlMutex.Unlock()
+	lib.CreateDBIfNotExists(sqliteDBPath)
+	run, unfinished, _ := lib.GetUnfinishedRun(sqliteDBPath)
+	if unfinished && run.Status == lib.RunRequested {
+		startRequestedCrawl(run)
+		return nil
+	}
+	if unfinished {
+		log.Printf("Run %v was not finished, starting a new one", run.ID)
+		lib.FinishRun(sqliteDBPath, run.ID, lib.RunAbandoned, run.Report)
+		lib.DeleteCheckpoint(sqliteDBPath, run.ID)
+	}
+	startCrawl()
+	return nil
+}
+
+func actionResume(c *cli.Context) error {
+	initialize()
+	crawlMutex.Lock()
+	defer crawlMutex.Unlock()
+	lib.CreateDBIfNotExists(sqliteDBPath)
+	run, unfinished, err := lib.GetUnfinishedRun(sqliteDBPath)
+	if err != nil {
+		return err
+	}
+	if !unfinished {
+		log.Print("There is no interrupted crawl to resume")
+		return nil
+	}
+	resumeCrawl(run)
 	return nil
 }
 
@@ -86,11 +213,147 @@ func actionForever(c *cli.Context) error {
 		}
 		gocron.Every(1).Day().At(config.GetString("start-time")).Do(crawl)
 	}
-	<- gocron.Start()
+	stopped := gocron.Start()
+	go func() {
+		for {
+			select {
+			case <-shutdown.Done():
+				return
+			case <-time.After(requestPollInterval):
+				crawlRequested()
+			}
+		}
+	}()
+	<-shutdown.Done()
+	stopped <- true
+	// wait for the running crawl to save the partial results
+	crawlMutex.Lock()
+	log.Print("Spiderwoman is stopped")
+	return nil
+}
+
+// actionCoordinator serves the jobs to the workers, the results are merged
+// and saved by the coordinator as the results of the local crawl. It listens
+// on localhost unless coordinator-addr is set, the workers must send the
+// coordinator-token
+func actionCoordinator(c *cli.Context) error {
+	if config.GetString("coordinator-token") == "" {
+		log.Fatal("You need to set coordinator-token value in config.yaml")
+	}
+	distributed = true
+	lib.CreateDBIfNotExists(sqliteDBPath)
+	coordinator := &lib.Coordinator{
+		DBFilepath:  sqliteDBPath,
+		Token:       config.GetString("coordinator-token"),
+		Lease:       time.Duration(configInt("job-lease-seconds", 60)) * time.Second,
+		MaxAttempts: configInt("job-max-attempts", 3),
+	}
+	addr := configString("coordinator-addr", "localhost:8090")
+	go func() {
+		log.Printf("Coordinator is listening on %v", addr)
+		log.Fatal(http.ListenAndServe(addr, coordinator))
+	}()
+	return actionForever(c)
+}
+
+func actionWorker(c *cli.Context) error {
+	workerMode = true
+	shutdown = handleSignals()
+	lib.ClearResolveCache()
+	if err := loadSitesConfig(); err != nil {
+		log.Fatal(err)
+	}
+	name := c.String("name")
+	if name == "" {
+		hostname, _ := os.Hostname()
+		name = fmt.Sprintf("%v-%d", hostname, os.Getpid())
+	}
+	token := c.String("token")
+	if token == "" {
+		token = config.GetString("coordinator-token")
+	}
+	worker := &lib.Worker{
+		Name:              name,
+		CoordinatorURL:    c.String("coordinator"),
+		Token:             token,
+		PollInterval:      jobsPollInterval,
+		HeartbeatInterval: time.Duration(configInt("job-heartbeat-seconds", 10)) * time.Second,
+		Handlers: map[string]lib.JobHandler{
+			lib.JobCrawl:   workerCrawl,
+			lib.JobResolve: workerResolve,
+		},
+	}
+	log.Printf("Worker %v takes the jobs from %v", name, worker.CoordinatorURL)
+	worker.Run(shutdown)
+	log.Printf("Worker %v is stopped", name)
+	return nil
+}
+
+func actionDiff(c *cli.Context) error {
+	lib.CreateDBIfNotExists(sqliteDBPath)
+	diff, err := lib.GetDiff(sqliteDBPath, c.String("from"), c.String("to"))
+	if err != nil {
+		return err
+	}
+	fmt.Print(lib.FormatDiff(diff))
+	return nil
+}
+
+func actionExportGraph(c *cli.Context) error {
+	write, ok := lib.GraphFormats[c.String("format")]
+	if !ok {
+		return fmt.Errorf("unknown format %q, use graphml, gexf or dot", c.String("format"))
+	}
+	lib.CreateDBIfNotExists(sqliteDBPath)
+	from, to := c.String("from"), c.String("to")
+	var runID int64
+	if c.Int64("run") != 0 {
+		run, found, err := lib.GetRun(sqliteDBPath, c.Int64("run"))
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("there is no crawl %d", c.Int64("run"))
+		}
+		from, to, runID = lib.RunDay(run), lib.RunDay(run), run.ID
+	}
+	graph, err := lib.GetLinkGraph(sqliteDBPath, from, to, runID)
+	if err != nil {
+		return err
+	}
+	output := os.Stdout
+	if c.String("output") != "" {
+		output, err = os.Create(c.String("output"))
+		if err != nil {
+			return err
+		}
+		defer output.Close()
+	}
+	if err := write(output, graph); err != nil {
+		return err
+	}
+	log.Printf("Exported %d hosts and %d edges of %v - %v", len(graph.Nodes), len(graph.Edges), graph.From, graph.To)
 	return nil
 }
 
+func actionTestAlerts(c *cli.Context) error {
+	config, err := lib.GetAlertsConfig(lib.AlertsFilepath, lib.AlertsDefaultFilepath)
+	if err != nil {
+		return err
+	}
+	secrets, err := lib.GetSecrets(configString("secrets-path", "./secrets.yml"))
+	if err != nil {
+		return err
+	}
+	err = lib.SendAlerts(config, secrets, []lib.Alert{{Kind: "test", Message: "Test alert from Spiderwoman"}})
+	if err == nil {
+		log.Printf("The test alert was sent to %d channels", len(config.Channels))
+	}
+	return err
+}
+
 func initialize() {
+	shutdown = handleSignals()
 	lib.ClearResolveCache()
 	err = lib.PopulateHostsAndTypes(sqliteDBPath, lib.SitesFilepath, lib.SitesDefaultFilepath)
 	if err != nil {
@@ -98,70 +361,252 @@ func initialize() {
 	}
 }
 
+// handleSignals cancels the returned context on the first SIGINT or SIGTERM,
+// so the crawl stops and saves what is collected. The second signal exits immediately
+func handleSignals() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Got %v, stopping the crawl and saving partial results. Send it again to exit immediately", sig)
+		cancel()
+		sig = <-signals
+		log.Printf("Got %v again, exiting without saving", sig)
+		os.Exit(1)
+	}()
+	return ctx
+}
+
+// crawl continues the run if the previous process died in the middle of it
+// or starts the run requested through the API, otherwise it starts a new one
 func crawl() {
-	externalLinks = make(map[string]map[string]int)
-	externalLinksResolved = make(map[string]map[string]int)
+	crawlMutex.Lock()
+	defer crawlMutex.Unlock()
+	if shutdown.Err() != nil {
+		return
+	}
 	lib.CreateDBIfNotExists(sqliteDBPath)
-	lib.SetCrawlStatus(sqliteDBPath, "Crawl started and crawling")
-	hosts, err = lib.GetHostsFromFile(lib.SitesFilepath, lib.SitesDefaultFilepath)
+	run, unfinished, err := lib.GetUnfinishedRun(sqliteDBPath)
 	if err != nil {
-		log.Printf("Error opening or parsing config file: %v", err)
+		log.Printf("Error getting the last run: %v", err)
+	}
+	if unfinished && run.Status == lib.RunRequested {
+		startRequestedCrawl(run)
+		return
+	}
+	if unfinished {
+		resumeCrawl(run)
 		return
 	}
+	startCrawl()
+}
 
-	for _, host := range hosts {
-		ext := &Ext{&gocrawl.DefaultExtender{}}
-		opts := gocrawl.NewOptions(ext)
-		opts.CrawlDelay = 0
-		if verbose {
-			opts.LogFlags = gocrawl.LogAll
-		} else {
-			opts.LogFlags = gocrawl.LogError
+// crawlRequested starts the run requested through the API, if there is one
+func crawlRequested() {
+	crawlMutex.Lock()
+	defer crawlMutex.Unlock()
+	if shutdown.Err() != nil {
+		return
+	}
+	run, unfinished, err := lib.GetUnfinishedRun(sqliteDBPath)
+	if err != nil || !unfinished || run.Status != lib.RunRequested {
+		return
+	}
+	startRequestedCrawl(run)
+}
+
+func startCrawl() {
+	lib.CreateDBIfNotExists(sqliteDBPath)
+	lib.SetCrawlStatus(sqliteDBPath, lib.RunStarted)
+	id, err := lib.StartRun(sqliteDBPath)
+	if err != nil {
+		log.Printf("Error starting the run: %v", err)
+	}
+	checkpoint, _ := lib.GetCheckpoint(sqliteDBPath, id)
+	runCrawl(checkpoint, nil)
+}
+
+func startRequestedCrawl(run lib.Run) {
+	log.Printf("Starting the run %v requested through the API", run.ID)
+	lib.SetCrawlStatus(sqliteDBPath, lib.RunStarted)
+	lib.StartRequestedRun(sqliteDBPath, run.ID)
+	checkpoint, _ := lib.GetCheckpoint(sqliteDBPath, run.ID)
+	runCrawl(checkpoint, run.Sites)
+}
+
+func resumeCrawl(run lib.Run) {
+	log.Printf("Resuming the run %v", run.ID)
+	lib.SetCrawlStatus(sqliteDBPath, lib.RunResumed)
+	lib.ResumeRun(sqliteDBPath, run.ID)
+	lib.RequeueCanceledJobs(sqliteDBPath, run.ID)
+	checkpoint, err := lib.GetCheckpoint(sqliteDBPath, run.ID)
+	if err != nil {
+		log.Printf("Error getting the checkpoint of the run %v: %v", run.ID, err)
+	}
+	runCrawl(checkpoint, run.Sites)
+}
+
+// runCrawl crawls the hosts (only the given sites if any) and resolves the links.
+// Everything that is done is saved in the checkpoint, so the run can be continued
+// from the given one if the process dies. If the crawl is stopped by a signal
+// or canceled through the API, the resolved links are saved as a partial run,
+// the run stopped by a signal keeps its checkpoint to be resumed
+func runCrawl(checkpoint lib.Checkpoint, sites []string) {
+	var cancelRun context.CancelFunc
+	crawlCtx, cancelRun = context.WithCancel(shutdown)
+	defer cancelRun()
+	runID = checkpoint.RunID
+	lib.DeleteOldEvents(sqliteDBPath, runID, eventsKeepRuns)
+	externalLinks = checkpoint.Links
+	externalLinksResolved = make(map[string]map[string]int)
+	linksInfo = checkpoint.LinksInfo
+	resolvedLinksInfo = make(map[string]map[string]lib.LinkInfo)
+	hostPages = checkpoint.Pages
+	embedHosts = checkpoint.Embeds
+	filteredLinks = make(map[string]map[string]lib.FilteredLink)
+	trapGuard = newTrapGuard()
+	trapGuard.AddSkipped(checkpoint.Skipped)
+	crawledPages = nil
+	notModifiedPages = checkpoint.Counters[lib.CounterNotModified]
+	sameContentPages = checkpoint.Counters[lib.CounterSameContent]
+	knownPages, err = lib.GetPages(sqliteDBPath)
+	if err != nil {
+		log.Printf("Error getting pages from previous runs: %v", err)
+		knownPages = make(map[string]lib.Page)
+	}
+	resumedVisited = make(map[string]bool)
+	for _, urls := range checkpoint.Visited {
+		for _, url := range urls {
+			resumedVisited[url] = true
 		}
-		opts.SameHostOnly = true
-		opts.MaxVisits = maxVisits
-		opts.HeadBeforeGet = false
-		opts.UserAgent = userAgent
-		opts.RobotUserAgent = userAgent
-		c := gocrawl.NewCrawlerWithOptions(opts)
-		c.Run("http://" + host)
+	}
+	if err := loadSitesConfig(); err != nil {
+		failRun(err)
+		return
+	}
+	hosts, err = lib.GetHostsFromFile(lib.SitesFilepath, lib.SitesDefaultFilepath)
+	if err != nil {
+		failRun(fmt.Errorf("Error opening or parsing config file: %v", err))
+		return
+	}
+	if len(sites) > 0 {
+		hosts = onlySites(hosts, sites)
+	}
+
+	mutex.Lock()
+	progress = lib.RunProgress{HostsTotal: len(hosts)}
+	resolving = false
+	phaseStarted = time.Now()
+	mutex.Unlock()
+	watcherDone := make(chan bool)
+	go watchRun(runID, cancelRun, watcherDone)
+
+	if distributed {
+		distributeCrawl(hosts)
+	} else {
+		crawlHosts(checkpoint, hosts)
 	}
 
 	lib.SetCrawlStatus(sqliteDBPath, "Resolving URLS")
 	log.Print("Going to resolve URLs...")
-	for host := range externalLinks {
-		for url, times := range externalLinks[host] {
-			externalLinksIterator++
-			syncResolve.Add(1)
-			go func(url string, times int, host string, wg *sync.WaitGroup, mutex *sync.Mutex) {
-				resolvedUrl := lib.Resolve(url, host, resolveTimeout, verbose, userAgent, mutex)
-				defer wg.Done()
-
-				if lib.HasStopHost(resolvedUrl, stopHosts) {
-					log.Printf("Url %v is in stoplist, not saving in map", resolvedUrl)
-					return
-				}
-
-				mutex.Lock()
-				if externalLinksResolved[host] == nil {
-					externalLinksResolved[host] = make(map[string]int)
-				}
-				externalLinksResolved[host][resolvedUrl] = times
-				mutex.Unlock()
-			}(url, times, host, &syncResolve, &mutex)
-			if externalLinksIterator%resolveURLsPool == 0 {
-				syncResolve.Wait()
-			}
+	mutex.Lock()
+	resolving = true
+	phaseStarted = time.Now()
+	mutex.Unlock()
+	if distributed {
+		distributeResolve()
+	} else {
+		for host := range externalLinks {
+			resolveLinks(host, externalLinks[host], linksInfo[host], checkpoint.Resolutions[host])
 		}
 	}
 	syncResolve.Wait()
 
 	lib.SetCrawlStatus(sqliteDBPath, "Saving the list")
 	log.Print("Saving the list")
-	lib.SaveDataToSqlite(sqliteDBPath, externalLinksResolved, verbose)
-	lib.SetCrawlStatus(sqliteDBPath, "Crawl done")
+	watchLinks()
+	lib.SaveDataToSqlite(sqliteDBPath, runID, externalLinksResolved, classifyLinks(), verbose)
+	if distributed {
+		// the pages of the local crawl are saved as they are crawled
+		err = lib.SavePages(sqliteDBPath, crawledPages)
+		if err != nil {
+			log.Printf("Error saving pages: %v", err)
+		}
+	}
+	// the links filtered out by the run before it was interrupted are replaced
+	if lib.DeleteRunFromFiltered(sqliteDBPath, runID) {
+		for _, links := range filteredLinks {
+			for _, link := range links {
+				lib.SaveFilteredLink(sqliteDBPath, runID, link)
+			}
+		}
+	}
+	status := lib.RunDone
+	if shutdown.Err() != nil {
+		status = lib.RunInterrupted
+	} else if crawlCtx.Err() != nil {
+		status = lib.RunCanceled
+	}
+	lib.SetCrawlStatus(sqliteDBPath, status)
+	report := lib.FormatSkippedReport(trapGuard.Skipped()) + "; " +
+		fmt.Sprintf("Unchanged pages: %d (not modified: %d, same content: %d)",
+			notModifiedPages+sameContentPages, notModifiedPages, sameContentPages)
+	networks, err := lib.GetNetworks(lib.NetworksFilepath, lib.NetworksDefaultFilepath)
+	if err != nil {
+		log.Printf("Error opening or parsing networks file: %v", err)
+	}
+	presences := lib.NetworkPresences(networks, embedHosts, hostPages)
+	lib.SaveNetworkPresences(sqliteDBPath, runID, presences)
+	report += "; " + lib.FormatNetworksReport(presences)
+	added, gone, err := lib.UpdateLinkLifetimes(sqliteDBPath, runID, externalLinksResolved, lifetimeHosts(status))
+	if err == nil {
+		report += "; " + lib.FormatLifetimeReport(added, gone)
+	}
+	if proxyStats := lib.GetAllProxyStats(); len(proxyStats) > 0 {
+		report += "; " + lib.FormatProxyReport(proxyStats)
+		lib.SaveProxyStats(sqliteDBPath, runID, proxyStats)
+	}
+	if alerts := sendAlerts(status, report); len(alerts) > 0 {
+		report += "; " + lib.FormatAlertsReport(alerts)
+	}
+	log.Print(report)
+	close(watcherDone)
+	lib.SaveRunProgress(sqliteDBPath, runID, currentProgress())
+	lib.FinishRun(sqliteDBPath, runID, status, report)
+	if status != lib.RunInterrupted {
+		lib.DeleteCheckpoint(sqliteDBPath, runID)
+	}
+	emitProgress(lib.RunProgress{})
+	emitEvent(lib.Event{Kind: lib.EventRunFinished, Message: status})
+
+	appendExcel()
+
+	log.Print("Backuping database")
+	err = lib.BackupDatabase(sqliteDBPath)
+	if (err != nil) {
+		log.Printf("Backup error: %v", err)
+	} else {
+		log.Print("Database has been copied to /tmp/res.db")
+	}
+
+	log.Print("Zip XLS File")
+	err = lib.ZipFile(excelFilePath, excelZipFilePath)
+	if (err != nil) {
+		log.Printf("Zip error: %v", err)
+	} else {
+		log.Printf("Zipped xls file was saved in %v", excelZipFilePath)
+	}
+}
 
+// appendExcel appends the sheets of the latest day to the XLS file, the file
+// is made from all of the days if it does not exist
+func appendExcel() {
 	days, _ := lib.GetAllDaysFromMonitor(sqliteDBPath)
+	if len(days) == 0 {
+		log.Print("There are no links saved, nothing to append to the XLS file")
+		return
+	}
 	log.Printf("Appendig XLS file with sheet %v", days[0])
 	err = lib.AppendExcelFromDB(sqliteDBPath, excelFilePath, days[0])
 	if (err != nil && strings.Contains(err.Error(), "no such file or directory")) {
@@ -175,30 +620,512 @@ func crawl() {
 			}
 		}
 	}
+	if len(days) > 1 {
+		log.Printf("Appendig XLS file with the diff of %v and %v", days[1], days[0])
+		err = lib.AppendDiffExcelFromDB(sqliteDBPath, excelFilePath, days[1], days[0])
+		if err != nil {
+			log.Print(err)
+		}
+	}
+	log.Printf("Appendig XLS file with the leaderboards of %v", days[0])
+	err = lib.AppendReportsExcelFromDB(sqliteDBPath, excelFilePath, days[0])
+	if err != nil {
+		log.Print(err)
+	}
+}
 
-	log.Print("Backuping database")
-	err = lib.BackupDatabase(sqliteDBPath)
-	if (err != nil) {
-		log.Printf("Backup error: %v", err)
-	} else {
-		log.Print("Database has been copied to /tmp/res.db")
+// crawlHosts crawls the hosts one by one, the hosts crawled before
+// the interruption are skipped and the host crawled at that moment is
+// continued from its queued urls
+func crawlHosts(checkpoint lib.Checkpoint, hosts []string) {
+	for _, host := range hosts {
+		if crawlCtx.Err() != nil {
+			break
+		}
+		seeds := []string{"http://" + host}
+		visits := maxVisits
+		switch checkpoint.Hosts[host] {
+		case lib.HostDone:
+			log.Printf("Host %v was crawled before the interruption", host)
+			addProgress(&progress.HostsDone)
+			continue
+		case lib.HostCrawling:
+			seeds = checkpoint.Queued[host]
+			visits -= len(checkpoint.Visited[host])
+			log.Printf("Continue crawling %v from %d queued urls", host, len(seeds))
+			if len(seeds) == 0 || visits <= 0 {
+				lib.SaveCheckpointHost(sqliteDBPath, runID, host, lib.HostDone)
+				addProgress(&progress.HostsDone)
+				continue
+			}
+		}
+
+		lib.SaveCheckpointHost(sqliteDBPath, runID, host, lib.HostCrawling)
+		emitEvent(lib.Event{Kind: lib.EventHostStarted, Host: host})
+		if crawlHost(host, seeds, visits) {
+			lib.SaveCheckpointHost(sqliteDBPath, runID, host, lib.HostDone)
+			addProgress(&progress.HostsDone)
+			emitEvent(lib.Event{Kind: lib.EventHostFinished, Host: host, Count: externalLinksCount(host)})
+		}
 	}
+}
 
-	log.Print("Zip XLS File")
-	err = lib.ZipFile(excelFilePath, excelZipFilePath)
-	if (err != nil) {
-		log.Printf("Zip error: %v", err)
+// crawlHost runs gocrawl on the host from the seeds until the visits are made,
+// false is returned if the crawl is stopped
+func crawlHost(host string, seeds []string, visits int) bool {
+	ext := &Ext{&gocrawl.DefaultExtender{}, host, siteSession(host)}
+	opts := gocrawl.NewOptions(ext)
+	opts.CrawlDelay = 0
+	if verbose {
+		opts.LogFlags = gocrawl.LogAll
 	} else {
-		log.Printf("Zipped xls file was saved in %v", excelZipFilePath)
+		opts.LogFlags = gocrawl.LogError
+	}
+	opts.SameHostOnly = true
+	opts.MaxVisits = visits
+	opts.HeadBeforeGet = false
+	opts.UserAgent = userAgent
+	opts.RobotUserAgent = userAgent
+	c := gocrawl.NewCrawlerWithOptions(opts)
+	finished := make(chan bool)
+	go func() {
+		select {
+		case <-crawlCtx.Done():
+			c.Stop()
+		case <-finished:
+		}
+	}()
+	c.Run(seeds)
+	close(finished)
+	return crawlCtx.Err() == nil
+}
+
+// resolveLinks resolves the links of the host in the pool of goroutines, the links
+// resolved or filtered before the interruption are taken from the resolutions.
+// The resolved link keeps the info of the link it was resolved from.
+// Use syncResolve to wait for the results
+func resolveLinks(host string, links map[string]int, info map[string]lib.LinkInfo, resolutions map[string]lib.Resolution) {
+	for url, times := range links {
+		if resolution, ok := resolutions[url]; ok {
+			if resolution.State == lib.ResolutionDone {
+				addResolvedLink(host, resolution.Resolved, resolution.Times, info[url])
+			} else if resolution.Reason != "" {
+				addFilteredLink(host, resolution.Resolved, resolution.Reason, resolution.ContentType, resolution.Times)
+			}
+			continue
+		}
+		if crawlCtx.Err() != nil {
+			// the partial run has only the resolved links
+			continue
+		}
+		externalLinksIterator++
+		syncResolve.Add(1)
+		go func(url string, times int, host string, wg *sync.WaitGroup, mutex *sync.Mutex) {
+			resolvedUrl, contentType, err := lib.ResolveWithContentType(crawlCtx, url, host, resolveTimeout, verbose, userAgent, mutex)
+			resolvedUrl = lib.NormalizeURL(resolvedUrl)
+			defer wg.Done()
+			if err != nil && crawlCtx.Err() != nil {
+				// stopped in the middle, the link is resolved on resume
+				return
+			}
+			if err != nil {
+				addProgress(&progress.LinksFailed)
+				emitEvent(lib.Event{Kind: lib.EventResolveFailed, Host: host, URL: url, Message: err.Error()})
+			}
+
+			resolution := lib.Resolution{URL: url, Times: times, Resolved: resolvedUrl, ContentType: contentType, State: lib.ResolutionSkipped}
+			defer func() {
+				if !workerMode {
+					lib.SaveCheckpointResolution(sqliteDBPath, runID, host, resolution)
+				}
+				addProgress(&progress.LinksResolved)
+			}()
+
+			if lib.HasStopHost(resolvedUrl, stopHosts) {
+				log.Printf("Url %v is in stoplist, not saving in map", resolvedUrl)
+				return
+			}
+
+			if ok, reason := mimeFilter.Check(contentType); !ok {
+				log.Printf("Url %v is filtered: %v", resolvedUrl, reason)
+				resolution.Reason = reason
+				addFilteredLink(host, resolvedUrl, reason, contentType, times)
+				return
+			}
+
+			resolution.State = lib.ResolutionDone
+			addResolvedLink(host, resolvedUrl, times, info[url])
+		}(url, times, host, &syncResolve, &mutex)
+		if externalLinksIterator%resolveURLsPool == 0 {
+			syncResolve.Wait()
+		}
+	}
+}
+
+// distributeCrawl gives the crawl of every host to the workers and merges
+// the results. The jobs of the resumed run are not given again
+func distributeCrawl(hosts []string) {
+	jobs, _ := lib.GetJobs(sqliteDBPath, runID, lib.JobCrawl)
+	if len(jobs) == 0 {
+		for _, host := range hosts {
+			job := lib.CrawlJob{Seeds: []string{"http://" + host}, MaxVisits: maxVisits}
+			for _, page := range knownPages {
+				if page.SourceHost == host {
+					job.KnownPages = append(job.KnownPages, page)
+				}
+			}
+			lib.EnqueueJob(sqliteDBPath, runID, lib.JobCrawl, host, job)
+		}
+	}
+
+	jobs = waitForJobs(lib.JobCrawl, func(job lib.Job) {
+		addProgress(&progress.HostsDone)
+		emitEvent(lib.Event{Kind: lib.EventHostFinished, Host: job.Host, Message: job.State})
+	})
+	for _, job := range jobs {
+		var result lib.CrawlResult
+		if job.State != lib.JobDone || json.Unmarshal(job.Result, &result) != nil {
+			log.Printf("Host %v was not crawled: %v %v", job.Host, job.State, job.Error)
+			continue
+		}
+		for host, links := range result.Links {
+			addLinks(host, links, result.LinksInfo[host])
+		}
+		mutex.Lock()
+		for host, pages := range result.HostPages {
+			hostPages[host] += pages
+		}
+		for host, embeds := range result.Embeds {
+			if embedHosts[host] == nil {
+				embedHosts[host] = make(map[string]int)
+			}
+			for embed, pages := range embeds {
+				embedHosts[host][embed] += pages
+			}
+		}
+		crawledPages = append(crawledPages, result.Pages...)
+		notModifiedPages += result.NotModified
+		sameContentPages += result.SameContent
+		progress.PagesVisited += result.Visited
+		mutex.Unlock()
+		trapGuard.AddSkipped(result.Skipped)
+	}
+}
+
+// distributeResolve gives the links to the workers in batches and merges
+// the results. The links of the batches which failed are saved as they are,
+// the ones of the batches canceled by the stop are left for the resume
+func distributeResolve() {
+	jobs, _ := lib.GetJobs(sqliteDBPath, runID, lib.JobResolve)
+	if len(jobs) == 0 {
+		if crawlCtx.Err() != nil {
+			return
+		}
+		batchSize := configInt("resolve-batch-size", 500)
+		for host, links := range externalLinks {
+			job := lib.ResolveJob{Links: make(map[string]int), LinksInfo: make(map[string]lib.LinkInfo)}
+			for url, times := range links {
+				job.Links[url] = times
+				job.LinksInfo[url] = linksInfo[host][url]
+				if len(job.Links) == batchSize {
+					lib.EnqueueJob(sqliteDBPath, runID, lib.JobResolve, host, job)
+					job = lib.ResolveJob{Links: make(map[string]int), LinksInfo: make(map[string]lib.LinkInfo)}
+				}
+			}
+			if len(job.Links) > 0 {
+				lib.EnqueueJob(sqliteDBPath, runID, lib.JobResolve, host, job)
+			}
+		}
+	}
+
+	jobs = waitForJobs(lib.JobResolve, func(job lib.Job) {
+		var batch lib.ResolveJob
+		json.Unmarshal(job.Payload, &batch)
+		mutex.Lock()
+		progress.LinksResolved += len(batch.Links)
+		mutex.Unlock()
+	})
+	for _, job := range jobs {
+		var batch lib.ResolveJob
+		var result lib.ResolveResult
+		json.Unmarshal(job.Payload, &batch)
+		if job.State == lib.JobCanceled {
+			continue
+		}
+		if job.State != lib.JobDone || json.Unmarshal(job.Result, &result) != nil {
+			for url, times := range batch.Links {
+				addResolvedLink(job.Host, url, times, batch.LinksInfo[url])
+			}
+			continue
+		}
+		for link, times := range result.Resolved {
+			addResolvedLink(job.Host, link, times, result.LinksInfo[link])
+		}
+		for _, link := range result.Filtered {
+			addFilteredLink(link.SourceHost, link.Link, link.Reason, link.ContentType, link.Count)
+		}
+		mutex.Lock()
+		progress.LinksFailed += result.Failed
+		mutex.Unlock()
+	}
+}
+
+// waitForJobs waits until all the jobs of the kind are finished by the workers,
+// the finished is called once for every job. The leases of the workers which died
+// are expired here too, so the jobs out of attempts fail even if no worker asks
+// for a job anymore. If the run is stopped, the jobs left are canceled and the jobs done so far are returned
+func waitForJobs(kind string, finished func(job lib.Job)) []lib.Job {
+	seen := make(map[int64]bool)
+	for {
+		lib.ExpireJobs(sqliteDBPath, configInt("job-max-attempts", 3))
+		jobs, err := lib.GetJobs(sqliteDBPath, runID, kind)
+		if err != nil {
+			log.Printf("Error getting the jobs: %v", err)
+		}
+		left := 0
+		for _, job := range jobs {
+			if !lib.JobFinished(job) {
+				left++
+				continue
+			}
+			if !seen[job.ID] {
+				seen[job.ID] = true
+				finished(job)
+			}
+		}
+		if err == nil && left == 0 {
+			return jobs
+		}
+		select {
+		case <-crawlCtx.Done():
+			lib.CancelJobs(sqliteDBPath, runID)
+			jobs, _ = lib.GetJobs(sqliteDBPath, runID, kind)
+			return jobs
+		case <-time.After(jobsPollInterval):
+		}
+	}
+}
+
+// workerCrawl crawls the host of the job on the worker
+func workerCrawl(ctx context.Context, job lib.Job) (interface{}, error) {
+	var crawlJob lib.CrawlJob
+	if err := json.Unmarshal(job.Payload, &crawlJob); err != nil {
+		return nil, err
+	}
+	crawlCtx = ctx
+	externalLinks = make(map[string]map[string]int)
+	linksInfo = make(map[string]map[string]lib.LinkInfo)
+	hostPages = make(map[string]int)
+	embedHosts = make(map[string]map[string]int)
+	trapGuard = newTrapGuard()
+	crawledPages = nil
+	notModifiedPages = 0
+	sameContentPages = 0
+	progress = lib.RunProgress{}
+	resumedVisited = make(map[string]bool)
+	knownPages = make(map[string]lib.Page)
+	for _, page := range crawlJob.KnownPages {
+		knownPages[page.URL] = page
+	}
+
+	if !crawlHost(job.Host, crawlJob.Seeds, crawlJob.MaxVisits) {
+		return nil, ctx.Err()
+	}
+	return lib.CrawlResult{
+		Links:       externalLinks,
+		LinksInfo:   linksInfo,
+		HostPages:   hostPages,
+		Embeds:      embedHosts,
+		Pages:       crawledPages,
+		Skipped:     trapGuard.Skipped(),
+		Visited:     progress.PagesVisited,
+		NotModified: notModifiedPages,
+		SameContent: sameContentPages,
+	}, nil
+}
+
+// workerResolve resolves the batch of links of the job on the worker
+func workerResolve(ctx context.Context, job lib.Job) (interface{}, error) {
+	var resolveJob lib.ResolveJob
+	if err := json.Unmarshal(job.Payload, &resolveJob); err != nil {
+		return nil, err
+	}
+	crawlCtx = ctx
+	externalLinksResolved = make(map[string]map[string]int)
+	resolvedLinksInfo = make(map[string]map[string]lib.LinkInfo)
+	filteredLinks = make(map[string]map[string]lib.FilteredLink)
+	progress = lib.RunProgress{}
+
+	resolveLinks(job.Host, resolveJob.Links, resolveJob.LinksInfo, nil)
+	syncResolve.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	result := lib.ResolveResult{
+		Resolved:  externalLinksResolved[job.Host],
+		LinksInfo: resolvedLinksInfo[job.Host],
+		Failed:    progress.LinksFailed,
+	}
+	for _, link := range filteredLinks[job.Host] {
+		result.Filtered = append(result.Filtered, link)
+	}
+	return result, nil
+}
+
+// failRun finishes the run which could not be started, e.g. because of the
+// broken config, and sends the crawl-failed alert. The checkpoint is kept,
+// the run is resumed once the config is fixed
+func failRun(err error) {
+	log.Print(err)
+	lib.SetCrawlStatus(sqliteDBPath, lib.RunFailed)
+	lib.FinishRun(sqliteDBPath, runID, lib.RunFailed, err.Error())
+	sendAlerts(lib.RunFailed, err.Error())
+}
+
+// loadSitesConfig reads sites.yml and the secrets, and makes the proxy pools
+// with the fresh stats for the run
+func loadSitesConfig() error {
+	sitesConfig, err = lib.GetSitesConfig(lib.SitesConfigFilepath, lib.SitesConfigDefaultFilepath)
+	if err != nil {
+		return fmt.Errorf("Error opening or parsing sites config: %v", err)
+	}
+	secrets, err = lib.GetSecrets(configString("secrets-path", "./secrets.yml"))
+	if err != nil {
+		return fmt.Errorf("Error opening or parsing secrets file: %v", err)
+	}
+	return setupProxies()
+}
+
+// setupProxies makes the global proxy pool from the config and the pools
+// of the sites
+func setupProxies() error {
+	maxFailures := configInt("proxy-max-failures", 3)
+	cooldown := time.Duration(configInt("proxy-cooldown-seconds", 300)) * time.Second
+	global, err := lib.NewProxyPool("global", strings.Split(config.GetString("proxies"), ","),
+		config.GetString("proxy-mode"), maxFailures, cooldown)
+	if err != nil {
+		return fmt.Errorf("Error in the proxies of the config: %v", err)
+	}
+	sites := make(map[string]*lib.ProxyPool)
+	for site, siteConfig := range sitesConfig {
+		pool, err := lib.NewProxyPool(site, siteConfig.Proxies, siteConfig.ProxyMode, maxFailures, cooldown)
+		if err != nil {
+			return fmt.Errorf("Error in the proxies of %v: %v", site, err)
+		}
+		if pool != nil {
+			sites[site] = pool
+		}
+	}
+	lib.SetProxyPools(global, sites)
+	return nil
+}
+
+// siteSession sets the cookies, headers and basic auth of the site and logs in
+// before the crawl. Nil is returned for the sites without them
+func siteSession(host string) *lib.SiteSession {
+	siteConfig, ok := sitesConfig[strings.ToLower(host)]
+	if !ok || !siteConfig.HasSession() {
+		return nil
+	}
+	session, err := lib.NewSiteSession(crawlCtx, host, siteConfig, secrets, httpClient(host, nil))
+	if err != nil {
+		log.Printf("Error setting up the session of %v, crawling without the login: %v", host, err)
+	} else if siteConfig.Login != nil {
+		log.Printf("Logged in to %v", host)
+	}
+	return session
+}
+
+// httpClient is the client of gocrawl with the proxies and the cookies of the site
+func httpClient(host string, session *lib.SiteSession) *http.Client {
+	client := gocrawl.HttpClient
+	if pool := lib.GetProxyPool(host); pool != nil {
+		proxied := *client
+		proxied.Transport = pool.RoundTripper(http.DefaultTransport.(*http.Transport))
+		client = &proxied
+	}
+	if session != nil {
+		client = session.Client(client)
 	}
+	return client
+}
+
+func newTrapGuard() *lib.TrapGuard {
+	return lib.NewTrapGuard(lib.TrapGuardOptions{
+		MaxQueryVariants:    configInt("trap-max-query-variants", 20),
+		MaxPathDepth:        configInt("trap-max-path-depth", 10),
+		MaxRepeatedSegments: configInt("trap-max-repeated-segments", 2),
+		SkipParams:          strings.Split(configString("trap-skip-params", defaultTrapSkipParams), ","),
+	})
 }
 
 func (e *Ext) Visit(ctx *gocrawl.URLContext, res *http.Response, doc *goquery.Document) (interface{}, bool) {
 	log.Printf("Visit: %s\n", ctx.URL())
+	pageURL := lib.NormalizeURL(ctx.URL().String())
+	if known, ok := knownPages[pageURL]; ok && res != nil && res.Header.Get(notModifiedHeader) != "" {
+		log.Printf("Page %v is not modified, reusing %d links", ctx.URL(), len(known.Links))
+		addPage(ctx, known)
+		rememberPage(known, &notModifiedPages)
+		return known.InternalLinks, false
+	}
 	if doc == nil {
 		return nil, true
 	}
-	doc.Find("a").Each(func(i int, s *goquery.Selection) {
+	doc = utf8Document(res, doc)
+	if duplicatePage(ctx, res, doc) {
+		return nil, false
+	}
+
+	page := lib.Page{
+		URL:          pageURL,
+		SourceHost:   ctx.URL().Host,
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+		ContentHash:  lib.ContentHash(responseBody(res)),
+	}
+	if known, ok := knownPages[pageURL]; ok && known.ContentHash == page.ContentHash {
+		log.Printf("Page %v has the same content, reusing %d links", ctx.URL(), len(known.Links))
+		page.Links = known.Links
+		page.LinksInfo = known.LinksInfo
+		page.Embeds = known.Embeds
+		page.InternalLinks = known.InternalLinks
+		addPage(ctx, page)
+		rememberPage(page, &sameContentPages)
+		return nil, true
+	}
+
+	page.Links, page.LinksInfo = pageLinks(ctx, doc, sitesConfig[strings.ToLower(e.host)])
+	page.Embeds = lib.PageEmbeds(doc, ctx.URL())
+	page.InternalLinks = internalLinks(ctx, doc)
+	addPage(ctx, page)
+	rememberPage(page, nil)
+	return nil, true
+}
+
+// lifetimeHosts are the hosts with the pages crawled by the finished run,
+// the links of the stopped runs can not be told gone
+func lifetimeHosts(status string) []string {
+	if status != lib.RunDone {
+		return nil
+	}
+	var hosts []string
+	for host, pages := range hostPages {
+		if pages > 0 {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// pageLinks finds outbound links on the page and counts them, only in the
+// include selectors and outside the exclude selectors of the site. The info
+// has the include selector and the part of the page the link was found in first,
+// the banner if the link is the image and the reason if the link is hidden
+func pageLinks(ctx *gocrawl.URLContext, doc *goquery.Document, site lib.SiteConfig) (map[string]int, map[string]lib.LinkInfo) {
+	links := make(map[string]int)
+	info := make(map[string]lib.LinkInfo)
+	styles := lib.ParsePageStyles(doc)
+	lib.EachRegionAnchor(doc, site.Include, site.Exclude, func(region string, s *goquery.Selection) {
 		href, _ := s.Attr("href")
 
 		// analyze absolute urls, e.g. http://bla.com/lolz
@@ -225,29 +1152,491 @@ func (e *Ext) Visit(ctx *gocrawl.URLContext, res *http.Response, doc *goquery.Do
 			}
 		}
 
+		href = lib.NormalizeURL(href)
+
 		if lib.HasStopHost(href, stopHosts) {
 			return
 		}
 
 		if lib.HasBadSuffixes(href, badSuffixes) {
+			addFilteredLink(ctx.URL().Host, href, "bad suffix", "", 1)
 			return
 		}
 
-		mutex.Lock()
-		if externalLinks[ctx.URL().Host] == nil {
-			externalLinks[ctx.URL().Host] = make(map[string]int)
+		links[href] += 1
+		linkInfo, ok := info[href]
+		if !ok {
+			linkInfo = lib.LinkInfo{Region: region, Placement: lib.AnchorPlacement(s), Pages: 1}
 		}
-		externalLinks[ctx.URL().Host][href] += 1
-		mutex.Unlock()
+		if banner, found := lib.AnchorBanner(s, ctx.URL()); found && linkInfo.Banner == nil {
+			linkInfo.Banner = &banner
+		}
+		if linkInfo.Hidden == "" {
+			linkInfo.Hidden = styles.HiddenReason(s)
+		}
+		info[href] = linkInfo
+	})
+	return links, info
+}
 
+// internalLinks are remembered for the pages which will not be fetched
+// on the next run, so the crawler still can go deeper through them
+func internalLinks(ctx *gocrawl.URLContext, doc *goquery.Document) []string {
+	var links []string
+	seen := make(map[string]bool)
+	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		u, err := ctx.URL().Parse(href)
+		if err != nil || u.Host != ctx.URL().Host || (u.Scheme != "http" && u.Scheme != "https") {
+			return
+		}
+		u.Fragment = ""
+		link := lib.NormalizeURL(u.String())
+		if !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
 	})
-	return nil, true
+	return links
+}
+
+// onlySites keeps the hosts requested for the run, the unknown sites are ignored
+func onlySites(hosts []string, sites []string) []string {
+	var result []string
+	for _, site := range sites {
+		found := false
+		for _, host := range hosts {
+			if strings.EqualFold(strings.TrimSpace(site), host) {
+				result = append(result, host)
+				found = true
+			}
+		}
+		if !found {
+			log.Printf("Site %v is not in the sites list, skipping", site)
+		}
+	}
+	return result
+}
+
+// watchRun saves the progress of the run, emits the progress events and stops
+// the run if it was canceled through the API, until done is closed
+func watchRun(id int64, cancelRun context.CancelFunc, done chan bool) {
+	var last lib.RunProgress
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(progressSaveInterval):
+			lib.SaveRunProgress(sqliteDBPath, id, currentProgress())
+			last = emitProgress(last)
+			run, found, err := lib.GetRun(sqliteDBPath, id)
+			if err == nil && found && run.CancelRequested && crawlCtx.Err() == nil {
+				log.Printf("The run %v was canceled, stopping the crawl and saving partial results", id)
+				cancelRun()
+			}
+		}
+	}
+}
+
+func addProgress(counter *int) {
+	mutex.Lock()
+	*counter++
+	mutex.Unlock()
+}
+
+func currentProgress() lib.RunProgress {
+	mutex.Lock()
+	defer mutex.Unlock()
+	current := progress
+	current.LinksFound = 0
+	for _, links := range externalLinks {
+		current.LinksFound += len(links)
+	}
+	return current
 }
 
+func emitEvent(event lib.Event) {
+	if workerMode {
+		return
+	}
+	event.RunID = runID
+	lib.SaveEvent(sqliteDBPath, event)
+}
+
+// emitProgress emits the progress event with the ETA of the current phase
+// of the run, if the progress has changed since the last one
+func emitProgress(last lib.RunProgress) lib.RunProgress {
+	current := currentProgress()
+	if current == last {
+		return last
+	}
+	mutex.Lock()
+	elapsed := time.Since(phaseStarted)
+	var eta time.Duration
+	if resolving {
+		eta = lib.EstimateETA(elapsed, current.LinksResolved, current.LinksFound)
+	} else {
+		eta = lib.EstimateETA(elapsed, current.HostsDone, current.HostsTotal)
+	}
+	mutex.Unlock()
+	emitEvent(lib.Event{Kind: lib.EventProgress, Progress: &current, ETA: int(eta.Seconds())})
+	return current
+}
+
+// sendAlerts checks the alert rules against the finished run and the diff
+// of its links with the day before, and sends the alerts to the channels
+func sendAlerts(status string, report string) []lib.Alert {
+	config, err := lib.GetAlertsConfig(lib.AlertsFilepath, lib.AlertsDefaultFilepath)
+	if err != nil {
+		log.Printf("Error opening or parsing alerts file: %v", err)
+		return nil
+	}
+	if len(config.Rules) == 0 {
+		return nil
+	}
+	run := lib.AlertRun{RunID: runID, Status: status, Report: report}
+	mutex.Lock()
+	for _, links := range externalLinksResolved {
+		run.LinksSaved += len(links)
+	}
+	mutex.Unlock()
+	// only the hosts crawled by the finished run are compared with the run before
+	hosts := lifetimeHosts(status)
+	if len(hosts) > 0 {
+		run.Diff, err = lib.GetRunDiff(sqliteDBPath, runID, hosts)
+		if err != nil {
+			log.Printf("Error getting diff for alerts: %v", err)
+		}
+		run.NewHosts, err = lib.GetNewExternalHosts(sqliteDBPath, runID)
+		if err != nil {
+			log.Printf("Error getting new external hosts for alerts: %v", err)
+		}
+	}
+	alerts := lib.EvaluateAlerts(config.Rules, run)
+	if len(alerts) > 0 {
+		if err := lib.SendAlerts(config, secrets, alerts); err != nil {
+			log.Print(err)
+		}
+	}
+	return alerts
+}
+
+func externalLinksCount(host string) int {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return len(externalLinks[host])
+}
+
+// addResolvedLink adds the link to the resolved ones, the links resolved to
+// the same url are counted together
+func addResolvedLink(host string, link string, times int, info lib.LinkInfo) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if externalLinksResolved[host] == nil {
+		externalLinksResolved[host] = make(map[string]int)
+		resolvedLinksInfo[host] = make(map[string]lib.LinkInfo)
+	}
+	externalLinksResolved[host][link] += times
+	resolvedLinksInfo[host][link] = resolvedLinksInfo[host][link].Merge(info)
+}
+
+// addLinks counts the links of the host and the pages they are on
+func addLinks(host string, links map[string]int, info map[string]lib.LinkInfo) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if externalLinks[host] == nil {
+		externalLinks[host] = make(map[string]int)
+	}
+	if linksInfo[host] == nil {
+		linksInfo[host] = make(map[string]lib.LinkInfo)
+	}
+	for href, times := range links {
+		externalLinks[host][href] += times
+		linksInfo[host][href] = linksInfo[host][href].Merge(info[href])
+	}
+}
+
+// classifyLinks labels the resolved links by their placement, the links
+// on most of the crawled pages of the source host are sitewide
+func classifyLinks() map[string]map[string]lib.LinkInfo {
+	sitewidePercent := configInt("sitewide-percent", 80)
+	minPages := configInt("sitewide-min-pages", 3)
+	for host, links := range resolvedLinksInfo {
+		for link, info := range links {
+			info.PagesCrawled = hostPages[host]
+			info.Placement = lib.ClassifyPlacement(info, sitewidePercent, minPages)
+			links[link] = info
+		}
+	}
+	return resolvedLinksInfo
+}
+
+// watchLinks flags the resolved links to the domains of the watchlist,
+// the other links are dropped if only the watched ones are stored
+func watchLinks() {
+	watchlist, err := lib.GetWatchlist(lib.WatchlistFilepath, lib.WatchlistDefaultFilepath)
+	if err != nil {
+		log.Printf("Error opening or parsing watchlist file: %v", err)
+		return
+	}
+	if len(watchlist) == 0 {
+		return
+	}
+	only := configString("watchlist-mode", lib.WatchlistFlag) == lib.WatchlistOnly
+	for host, links := range externalLinksResolved {
+		for link := range links {
+			watched, ok := matchWatchlist(watchlist, link)
+			if !ok {
+				if only {
+					delete(links, link)
+				}
+				continue
+			}
+			if resolvedLinksInfo[host] == nil {
+				resolvedLinksInfo[host] = make(map[string]lib.LinkInfo)
+			}
+			info := resolvedLinksInfo[host][link]
+			info.Watched = &watched
+			resolvedLinksInfo[host][link] = info
+		}
+	}
+}
+
+func matchWatchlist(watchlist []lib.WatchedDomain, link string) (lib.WatchedDomain, bool) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return lib.WatchedDomain{}, false
+	}
+	return lib.MatchWatchlist(watchlist, u.Hostname())
+}
+
+// addPage counts the page with its outbound links and the third-party hosts
+// embedded on it, the links remember the url of the page to count every page once
+func addPage(ctx *gocrawl.URLContext, page lib.Page) {
+	host := ctx.URL().Host
+	info := make(map[string]lib.LinkInfo)
+	for href := range page.Links {
+		linkInfo := page.LinksInfo[href]
+		linkInfo.Pages = 1
+		linkInfo.PageURLs = map[string]bool{page.URL: true}
+		info[href] = linkInfo
+	}
+	addLinks(host, page.Links, info)
+	mutex.Lock()
+	hostPages[host]++
+	if embedHosts[host] == nil {
+		embedHosts[host] = make(map[string]int)
+	}
+	for _, embed := range page.Embeds {
+		embedHosts[host][embed]++
+	}
+	mutex.Unlock()
+	if !workerMode {
+		lib.SaveCheckpointLinks(sqliteDBPath, runID, host, page.Links, info)
+		lib.SaveCheckpointEmbeds(sqliteDBPath, runID, host, page.Embeds)
+	}
+	emitEvent(lib.Event{Kind: lib.EventPageVisited, Host: host, URL: lib.NormalizeURL(ctx.URL().String()), Count: len(page.Links)})
+}
+
+// rememberPage keeps the page for the next run, the local crawl saves it
+// right away, so the pages crawled before the interruption are not lost
+func rememberPage(page lib.Page, unchangedCounter *int) {
+	mutex.Lock()
+	crawledPages = append(crawledPages, page)
+	if unchangedCounter != nil {
+		*unchangedCounter++
+	}
+	mutex.Unlock()
+	if !workerMode {
+		if err := lib.SavePages(sqliteDBPath, []lib.Page{page}); err != nil {
+			log.Printf("Error saving page %v: %v", page.URL, err)
+		}
+	}
+}
+
+// saveCheckpointCounters keeps the counters of the run report in the
+// checkpoint, so the resumed run reports the pages crawled before too
+func saveCheckpointCounters() {
+	mutex.Lock()
+	counters := map[string]int{lib.CounterNotModified: notModifiedPages, lib.CounterSameContent: sameContentPages}
+	mutex.Unlock()
+	lib.SaveCheckpointCounters(sqliteDBPath, runID, counters, trapGuard.Skipped())
+}
+
+// Fetch sends a conditional request for the pages we have seen before,
+// a 304 answer is turned into an empty page marked with notModifiedHeader,
+// so Visit can reuse the links found on the previous run
+func (e *Ext) Fetch(ctx *gocrawl.URLContext, userAgent string, headRequest bool) (*http.Response, error) {
+	method := "GET"
+	if headRequest {
+		method = "HEAD"
+	}
+	req, err := http.NewRequest(method, ctx.URL().String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(crawlCtx)
+	req.Header.Set("User-Agent", userAgent)
+
+	known, ok := knownPages[lib.NormalizeURL(ctx.URL().String())]
+	if ok && !headRequest {
+		if known.ETag != "" {
+			req.Header.Set("If-None-Match", known.ETag)
+		}
+		if known.LastModified != "" {
+			req.Header.Set("If-Modified-Since", known.LastModified)
+		}
+	}
+
+	if e.session != nil {
+		e.session.Apply(req)
+	}
+	res, err := httpClient(e.host, e.session).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if ok && res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		header := make(http.Header)
+		header.Set("Content-Type", "text/html")
+		header.Set("ETag", known.ETag)
+		header.Set("Last-Modified", known.LastModified)
+		header.Set(notModifiedHeader, "1")
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: http.StatusOK,
+			Proto:      res.Proto,
+			ProtoMajor: res.ProtoMajor,
+			ProtoMinor: res.ProtoMinor,
+			Header:     header,
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+			Request:    req,
+		}, nil
+	}
+	return res, nil
+}
+
+// responseBody reads the body and puts it back, so it can be read once again
+func responseBody(res *http.Response) []byte {
+	if res == nil || res.Body == nil {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body
+}
+
+// utf8Document parses the page once again if it is not in UTF-8, e.g. windows-1251
+// or KOI8-R pages, otherwise goquery returns garbled texts and urls
+func utf8Document(res *http.Response, doc *goquery.Document) *goquery.Document {
+	if res == nil || res.Body == nil {
+		return doc
+	}
+	body := responseBody(res)
+	if len(body) == 0 {
+		return doc
+	}
+
+	decoded, charsetName, err := lib.DecodeToUTF8(body, res.Header.Get("Content-Type"))
+	if err != nil || charsetName == "utf-8" {
+		return doc
+	}
+	if verbose {
+		log.Printf("Page %v is in %v, converting to utf-8", doc.Url, charsetName)
+	}
+	utf8Doc, err := goquery.NewDocumentFromReader(bytes.NewReader(decoded))
+	if err != nil {
+		log.Printf("Error parsing converted page %v: %v", doc.Url, err)
+		return doc
+	}
+	utf8Doc.Url = doc.Url
+	return utf8Doc
+}
+
+// duplicatePage reports the page if the page with the same content or
+// the same rel=canonical url was already visited in this crawl
+func duplicatePage(ctx *gocrawl.URLContext, res *http.Response, doc *goquery.Document) bool {
+	canonical := ""
+	if href, ok := doc.Find("link[rel=canonical]").First().Attr("href"); ok {
+		if u, err := ctx.URL().Parse(href); err == nil {
+			canonical = lib.NormalizeURL(u.String())
+		}
+	}
+
+	duplicate, first := trapGuard.IsDuplicate(lib.NormalizeURL(ctx.URL().String()), canonical, responseBody(res))
+	if duplicate {
+		log.Printf("Page %v is a duplicate of %v, skipping", ctx.URL(), first)
+	}
+	return duplicate
+}
+
+func addFilteredLink(host string, link string, reason string, contentType string, count int) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if filteredLinks[host] == nil {
+		filteredLinks[host] = make(map[string]lib.FilteredLink)
+	}
+	filtered := filteredLinks[host][link]
+	filtered.SourceHost = host
+	filtered.Link = link
+	filtered.Reason = reason
+	filtered.ContentType = contentType
+	filtered.Count += count
+	filteredLinks[host][link] = filtered
+}
+
+// configString returns the value from config.yml or the default one if the key is not set
+func configString(key string, defaultValue string) string {
+	value := config.GetString(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func configInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(config.GetString(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// Filter is called for every link found on the page, also for the links to
+// the other hosts which gocrawl drops after it, those are not counted as traps
 func (e *Ext) Filter(ctx *gocrawl.URLContext, isVisited bool) bool {
+	if source := ctx.NormalizedSourceURL(); !ctx.IsRobotsURL() && source != nil && ctx.NormalizedURL().Host != source.Host {
+		return false
+	}
+	if isVisited || resumedVisited[lib.NormalizeURL(ctx.URL().String())] {
+		return false
+	}
+	if ok, reason := trapGuard.Allow(ctx.URL()); !ok {
+		if verbose {
+			log.Printf("Skip %v: %v", ctx.URL(), reason)
+		}
+		return false
+	}
 	return true
 }
 
+// Enqueued and Visited keep the crawl frontier in the checkpoint
+func (e *Ext) Enqueued(ctx *gocrawl.URLContext) {
+	if workerMode {
+		return
+	}
+	lib.SaveCheckpointURL(sqliteDBPath, runID, e.host, lib.NormalizeURL(ctx.URL().String()), lib.URLQueued)
+}
+
+func (e *Ext) Visited(ctx *gocrawl.URLContext, harvested interface{}) {
+	addProgress(&progress.PagesVisited)
+	if workerMode {
+		return
+	}
+	lib.SaveCheckpointURL(sqliteDBPath, runID, e.host, lib.NormalizeURL(ctx.URL().String()), lib.URLVisited)
+	saveCheckpointCounters()
+}
+
 func (de *Ext) RequestRobots(ctx *gocrawl.URLContext, robotAgent string) (data []byte, doRequest bool) {
 	return nil, false
 }
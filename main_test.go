@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/maddevsio/spiderwoman/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func resetLinks() {
+	crawlCtx = context.Background()
+	workerMode = true
+	externalLinks = make(map[string]map[string]int)
+	externalLinksResolved = make(map[string]map[string]int)
+	linksInfo = make(map[string]map[string]lib.LinkInfo)
+	resolvedLinksInfo = make(map[string]map[string]lib.LinkInfo)
+	filteredLinks = make(map[string]map[string]lib.FilteredLink)
+	hostPages = make(map[string]int)
+	embedHosts = make(map[string]map[string]int)
+}
+
+func TestAddResolvedLink(t *testing.T) {
+	resetLinks()
+	addResolvedLink("a.kg", "http://example.com/", 3, lib.LinkInfo{Placement: lib.PlacementFooter, Pages: 2, PageURLs: map[string]bool{"http://a.kg/": true, "http://a.kg/1": true}})
+	addResolvedLink("a.kg", "http://example.com/", 2, lib.LinkInfo{Placement: lib.PlacementContent, Pages: 2, PageURLs: map[string]bool{"http://a.kg/1": true, "http://a.kg/2": true}})
+
+	assert.Equal(t, 5, externalLinksResolved["a.kg"]["http://example.com/"])
+	assert.Equal(t, lib.LinkInfo{Placement: lib.PlacementFooter, Pages: 3, PageURLs: map[string]bool{"http://a.kg/": true, "http://a.kg/1": true, "http://a.kg/2": true}}, resolvedLinksInfo["a.kg"]["http://example.com/"])
+}
+
+func TestResolveLinksToSameURL(t *testing.T) {
+	resetLinks()
+	links := map[string]int{"http://goo.gl/x": 2, "http://example.com/": 1}
+	info := map[string]lib.LinkInfo{
+		"http://goo.gl/x":     {Pages: 2, PageURLs: map[string]bool{"http://a.kg/": true, "http://a.kg/1": true}},
+		"http://example.com/": {Pages: 1, PageURLs: map[string]bool{"http://a.kg/": true}},
+	}
+	resolutions := map[string]lib.Resolution{
+		"http://goo.gl/x":     {URL: "http://goo.gl/x", Times: 2, Resolved: "http://example.com/", State: lib.ResolutionDone},
+		"http://example.com/": {URL: "http://example.com/", Times: 1, Resolved: "http://example.com/", State: lib.ResolutionDone},
+	}
+	resolveLinks("a.kg", links, info, resolutions)
+	syncResolve.Wait()
+
+	assert.Equal(t, map[string]int{"http://example.com/": 3}, externalLinksResolved["a.kg"])
+	assert.Equal(t, 2, resolvedLinksInfo["a.kg"]["http://example.com/"].Pages)
+}
+
+func TestAddLinks(t *testing.T) {
+	resetLinks()
+	addLinks("a.kg", map[string]int{"http://b.kg/": 2}, map[string]lib.LinkInfo{"http://b.kg/": {Pages: 1}})
+	addLinks("a.kg", map[string]int{"http://b.kg/": 1}, map[string]lib.LinkInfo{"http://b.kg/": {Pages: 1}})
+
+	assert.Equal(t, 3, externalLinks["a.kg"]["http://b.kg/"])
+	assert.Equal(t, 2, linksInfo["a.kg"]["http://b.kg/"].Pages)
+}
+
+func TestResolveLinksRestoresFiltered(t *testing.T) {
+	resetLinks()
+	links := map[string]int{"http://bit.ly/1": 2, "http://bit.ly/2": 1}
+	resolutions := map[string]lib.Resolution{
+		"http://bit.ly/1": {URL: "http://bit.ly/1", Times: 2, Resolved: "http://b.kg/1.pdf", ContentType: "application/pdf", State: lib.ResolutionSkipped, Reason: "denied application/pdf"},
+		"http://bit.ly/2": {URL: "http://bit.ly/2", Times: 1, Resolved: "http://stop.kg/", State: lib.ResolutionSkipped},
+	}
+	resolveLinks("a.kg", links, nil, resolutions)
+	syncResolve.Wait()
+
+	assert.Equal(t, 0, len(externalLinksResolved["a.kg"]))
+	assert.Equal(t, map[string]lib.FilteredLink{
+		"http://b.kg/1.pdf": {SourceHost: "a.kg", Link: "http://b.kg/1.pdf", Reason: "denied application/pdf", ContentType: "application/pdf", Count: 2},
+	}, filteredLinks["a.kg"])
+}